@@ -0,0 +1,74 @@
+package abuse
+
+import (
+	"fmt"
+	"time"
+)
+
+// Ban reasons
+const (
+	ReasonMessageFlood  = "message-flood"
+	ReasonBadMessages   = "bad-messages"
+	ReasonInvalidShares = "invalid-shares"
+)
+
+// Conn counts the messages and shares of a single connection. It is only
+// used from the connection's own routine, so it has no lock.
+type Conn struct {
+	limits Limits
+
+	windowStart time.Time
+	messages    int
+	bad         int
+
+	shares  int
+	invalid int
+}
+
+func (g *Guard) NewConn() *Conn {
+	return &Conn{limits: g.Limits}
+}
+
+// Message counts a message from the connection, and returns why it should be
+// banned, or "" if it is within the limits.
+func (c *Conn) Message(valid bool, now time.Time) string {
+	if now.Sub(c.windowStart) >= time.Minute {
+		c.windowStart = now
+		c.messages = 0
+	}
+	c.messages++
+	if !valid {
+		c.bad++
+	}
+
+	if c.limits.MessagesPerMinute > 0 && c.messages > c.limits.MessagesPerMinute {
+		return ReasonMessageFlood
+	}
+	if c.limits.BadMessages > 0 && c.bad > c.limits.BadMessages {
+		return ReasonBadMessages
+	}
+	return ""
+}
+
+// Share counts a share from the connection, and returns why it should be
+// banned, or "" if it is within the limits. Only shares that are wrong, not
+// just late, should be invalid.
+func (c *Conn) Share(invalid bool) string {
+	c.shares++
+	if invalid {
+		c.invalid++
+	}
+
+	if c.limits.InvalidShareRatio <= 0 || c.shares < c.limits.InvalidShareMinimum {
+		return ""
+	}
+	if float64(c.invalid)/float64(c.shares) > c.limits.InvalidShareRatio {
+		return ReasonInvalidShares
+	}
+	return ""
+}
+
+// String is for the logs
+func (c *Conn) String() string {
+	return fmt.Sprintf("messages: %d, bad: %d, shares: %d, invalid: %d", c.messages, c.bad, c.shares, c.invalid)
+}
@@ -0,0 +1,283 @@
+package abuse
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var (
+	abLog = log.WithFields(log.Fields{"mod": "abuse"})
+)
+
+// KindIP is the kind of every ban for now. The kind is kept so the list can
+// hold other kinds of bans later.
+const KindIP = "ip"
+
+// banMemory is how far back earlier bans make a new ban longer
+const banMemory = 7 * 24 * time.Hour
+
+// Ban keeps an ip off the stratum server until it expires, or an admin clears
+// it
+type Ban struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"created"`
+	Kind      string    `gorm:"index:ban_kind_value" json:"kind"`
+	Value     string    `gorm:"index:ban_kind_value" json:"value"`
+	Reason    string    `json:"reason"`
+	// Offense is the number of bans in the last week, including this one.
+	// Each offense doubles the ban.
+	Offense   int        `json:"offense"`
+	Expires   time.Time  `json:"expires"`
+	ClearedBy string     `gorm:"default:''" json:"clearedby,omitempty"`
+	ClearedAt *time.Time `json:"cleared,omitempty"`
+}
+
+// Active returns if the ban still holds
+func (b Ban) Active(now time.Time) bool {
+	return b.ClearedAt == nil && now.Before(b.Expires)
+}
+
+func (b Ban) Error() string {
+	return fmt.Sprintf("%s %s is banned until %s: %s", b.Kind, b.Value, b.Expires.UTC().Format(time.RFC3339), b.Reason)
+}
+
+// Limits are the limits on the stratum connections. A limit of 0 is
+// unlimited.
+type Limits struct {
+	ConnectionsPerIP    int
+	ConnectionsPerUser  int
+	MessagesPerMinute   int
+	BadMessages         int
+	InvalidShareRatio   float64
+	InvalidShareMinimum int
+	BanDuration         time.Duration
+	MaxBanDuration      time.Duration
+}
+
+// Guard keeps the connection counts, and the ban list. The active bans are
+// kept in memory, so checking a new connection does not touch the database.
+type Guard struct {
+	DB     *gorm.DB
+	Limits Limits
+
+	sync.Mutex
+	ips   map[string]int
+	users map[string]int
+	// bans are the active bans, by kind and value
+	bans map[string]Ban
+}
+
+func NewGuard(conf *viper.Viper, db *gorm.DB) (*Guard, error) {
+	g := new(Guard)
+	g.DB = db
+	g.Limits = Limits{
+		ConnectionsPerIP:    conf.GetInt(config.ConfigAbuseMaxConnectionsPerIP),
+		ConnectionsPerUser:  conf.GetInt(config.ConfigAbuseMaxConnectionsPerUser),
+		MessagesPerMinute:   conf.GetInt(config.ConfigAbuseMaxMessagesPerMinute),
+		BadMessages:         conf.GetInt(config.ConfigAbuseMaxBadMessages),
+		InvalidShareRatio:   conf.GetFloat64(config.ConfigAbuseInvalidShareRatio),
+		InvalidShareMinimum: conf.GetInt(config.ConfigAbuseInvalidShareMinimum),
+		BanDuration:         conf.GetDuration(config.ConfigAbuseBanDuration),
+		MaxBanDuration:      conf.GetDuration(config.ConfigAbuseMaxBanDuration),
+	}
+	if g.Limits.BanDuration <= 0 {
+		return nil, fmt.Errorf("ban duration must be greater than 0")
+	}
+	if g.Limits.MaxBanDuration < g.Limits.BanDuration {
+		g.Limits.MaxBanDuration = g.Limits.BanDuration
+	}
+
+	g.ips = make(map[string]int)
+	g.users = make(map[string]int)
+	g.bans = make(map[string]Ban)
+
+	g.DB.AutoMigrate(&Ban{})
+	if err := g.load(time.Now()); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func banKey(kind, value string) string {
+	return kind + ":" + value
+}
+
+// load reads the active bans from the database
+func (g *Guard) load(now time.Time) error {
+	var bans []Ban
+	err := g.DB.Where("cleared_at IS NULL AND expires > ?", now).Find(&bans).Error
+	if err != nil {
+		return err
+	}
+
+	g.Lock()
+	defer g.Unlock()
+	for _, b := range bans {
+		g.bans[banKey(b.Kind, b.Value)] = b
+	}
+	return nil
+}
+
+// banned returns the active ban of the ip. The lock must be held.
+func (g *Guard) banned(ip string, now time.Time) (Ban, bool) {
+	key := banKey(KindIP, ip)
+	b, ok := g.bans[key]
+	if ok && !b.Active(now) {
+		delete(g.bans, key)
+		return b, false
+	}
+	return b, ok
+}
+
+// Banned returns the active ban of the ip, if there is one
+func (g *Guard) Banned(ip string, now time.Time) (Ban, bool) {
+	g.Lock()
+	defer g.Unlock()
+	return g.banned(ip, now)
+}
+
+// Connect counts a new connection from the ip. Banned ips, and ips over the
+// limit, are refused.
+func (g *Guard) Connect(ip string, now time.Time) error {
+	g.Lock()
+	defer g.Unlock()
+	if b, ok := g.banned(ip, now); ok {
+		refused.WithLabelValues("banned").Inc()
+		return b
+	}
+	if g.Limits.ConnectionsPerIP > 0 && g.ips[ip] >= g.Limits.ConnectionsPerIP {
+		refused.WithLabelValues("ip-limit").Inc()
+		return fmt.Errorf("too many connections from %s", ip)
+	}
+	g.ips[ip]++
+	return nil
+}
+
+// Disconnect releases a connection counted by Connect
+func (g *Guard) Disconnect(ip string) {
+	g.Lock()
+	defer g.Unlock()
+	if g.ips[ip] <= 1 {
+		delete(g.ips, ip)
+		return
+	}
+	g.ips[ip]--
+}
+
+// Authorize counts a new connection of the user. Users over the limit are
+// refused.
+func (g *Guard) Authorize(user string) error {
+	g.Lock()
+	defer g.Unlock()
+	if g.Limits.ConnectionsPerUser > 0 && g.users[user] >= g.Limits.ConnectionsPerUser {
+		refused.WithLabelValues("user-limit").Inc()
+		return fmt.Errorf("too many connections for %s", user)
+	}
+	g.users[user]++
+	return nil
+}
+
+// Deauthorize releases a connection counted by Authorize
+func (g *Guard) Deauthorize(user string) {
+	g.Lock()
+	defer g.Unlock()
+	if g.users[user] <= 1 {
+		delete(g.users, user)
+		return
+	}
+	g.users[user]--
+}
+
+// Ban bans the ip. Each ban in the last week doubles the length of the new
+// one, up to the max. Banning an ip that is already banned returns the
+// existing ban.
+func (g *Guard) Ban(ip, reason string, now time.Time) (Ban, error) {
+	g.Lock()
+	defer g.Unlock()
+	if b, ok := g.banned(ip, now); ok {
+		return b, nil
+	}
+
+	var earlier int
+	err := g.DB.Model(&Ban{}).Where("kind = ? AND value = ? AND created_at > ?", KindIP, ip, now.Add(-banMemory)).
+		Count(&earlier).Error
+	if err != nil {
+		return Ban{}, err
+	}
+
+	length := g.Limits.BanDuration
+	for i := 0; i < earlier && length < g.Limits.MaxBanDuration; i++ {
+		length *= 2
+	}
+	if length > g.Limits.MaxBanDuration {
+		length = g.Limits.MaxBanDuration
+	}
+
+	b := Ban{
+		CreatedAt: now,
+		Kind:      KindIP,
+		Value:     ip,
+		Reason:    reason,
+		Offense:   earlier + 1,
+		Expires:   now.Add(length),
+	}
+	if err := g.DB.Create(&b).Error; err != nil {
+		return Ban{}, err
+	}
+	g.bans[banKey(KindIP, ip)] = b
+
+	bans.WithLabelValues(reason).Inc()
+	abLog.WithFields(log.Fields{"ip": ip, "reason": reason, "offense": b.Offense, "expires": b.Expires}).
+		Warnf("banned")
+	return b, nil
+}
+
+// Bans returns the active bans, or every ban
+func (g *Guard) Bans(all bool, now time.Time) ([]Ban, error) {
+	var list []Ban
+	q := g.DB.Order("created_at desc")
+	if !all {
+		q = q.Where("cleared_at IS NULL AND expires > ?", now)
+	}
+	err := q.Find(&list).Error
+	return list, err
+}
+
+// Clear ends a ban early
+func (g *Guard) Clear(id uint, admin string, now time.Time) (Ban, error) {
+	g.Lock()
+	defer g.Unlock()
+
+	var b Ban
+	if err := g.DB.First(&b, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return b, fmt.Errorf("ban %d not found", id)
+		}
+		return b, err
+	}
+	if !b.Active(now) {
+		return b, fmt.Errorf("ban %d is not active", id)
+	}
+
+	b.ClearedBy = admin
+	b.ClearedAt = &now
+	err := g.DB.Model(&b).Updates(map[string]interface{}{
+		"cleared_by": admin,
+		"cleared_at": now,
+	}).Error
+	if err != nil {
+		return b, err
+	}
+
+	key := banKey(b.Kind, b.Value)
+	if cached, ok := g.bans[key]; ok && cached.ID == b.ID {
+		delete(g.bans, key)
+	}
+	return b, nil
+}
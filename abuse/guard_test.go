@@ -0,0 +1,142 @@
+package abuse_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/abuse"
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func guardForTests(t *testing.T) *Guard {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+
+	conf := viper.New()
+	config.SetDefaults(conf)
+	conf.Set(config.ConfigAbuseMaxConnectionsPerIP, 2)
+	conf.Set(config.ConfigAbuseMaxConnectionsPerUser, 1)
+	conf.Set(config.ConfigAbuseBanDuration, time.Minute)
+	conf.Set(config.ConfigAbuseMaxBanDuration, time.Minute*3)
+	g, err := NewGuard(conf, db)
+	require.NoError(t, err)
+	return g
+}
+
+func TestGuard_Limits(t *testing.T) {
+	require := require.New(t)
+	g := guardForTests(t)
+	defer g.DB.Close()
+	now := time.Now()
+
+	require.NoError(g.Connect("10.0.0.1", now))
+	require.NoError(g.Connect("10.0.0.1", now))
+	require.Error(g.Connect("10.0.0.1", now))
+	require.NoError(g.Connect("10.0.0.2", now))
+	g.Disconnect("10.0.0.1")
+	require.NoError(g.Connect("10.0.0.1", now))
+
+	require.NoError(g.Authorize("alice"))
+	require.Error(g.Authorize("alice"))
+	g.Deauthorize("alice")
+	require.NoError(g.Authorize("alice"))
+}
+
+func TestGuard_Ban(t *testing.T) {
+	require := require.New(t)
+	g := guardForTests(t)
+	defer g.DB.Close()
+	now := time.Now()
+
+	first, err := g.Ban("10.0.0.1", ReasonMessageFlood, now)
+	require.NoError(err)
+	require.Equal(1, first.Offense)
+	require.Equal(now.Add(time.Minute), first.Expires)
+	require.Error(g.Connect("10.0.0.1", now))
+
+	// Banning again while banned keeps the ban
+	again, err := g.Ban("10.0.0.1", ReasonBadMessages, now)
+	require.NoError(err)
+	require.Equal(first.ID, again.ID)
+
+	// The ban expires, and each new one is longer, up to the max
+	now = now.Add(time.Minute)
+	require.NoError(g.Connect("10.0.0.1", now))
+	second, err := g.Ban("10.0.0.1", ReasonMessageFlood, now)
+	require.NoError(err)
+	require.Equal(2, second.Offense)
+	require.Equal(now.Add(time.Minute*2), second.Expires)
+
+	now = second.Expires
+	third, err := g.Ban("10.0.0.1", ReasonMessageFlood, now)
+	require.NoError(err)
+	require.Equal(now.Add(time.Minute*3), third.Expires)
+
+	active, err := g.Bans(false, now)
+	require.NoError(err)
+	require.Len(active, 1)
+	all, err := g.Bans(true, now)
+	require.NoError(err)
+	require.Len(all, 3)
+
+	// An admin can clear it early, once
+	cleared, err := g.Clear(third.ID, "admin", now)
+	require.NoError(err)
+	require.Equal("admin", cleared.ClearedBy)
+	require.NoError(g.Connect("10.0.0.1", now))
+	_, err = g.Clear(third.ID, "admin", now)
+	require.Error(err)
+	_, err = g.Clear(100, "admin", now)
+	require.Error(err)
+}
+
+func TestGuard_Load(t *testing.T) {
+	require := require.New(t)
+	g := guardForTests(t)
+	defer g.DB.Close()
+
+	_, err := g.Ban("10.0.0.1", ReasonInvalidShares, time.Now())
+	require.NoError(err)
+
+	// A restarted pool keeps the bans
+	conf := viper.New()
+	config.SetDefaults(conf)
+	restarted, err := NewGuard(conf, g.DB)
+	require.NoError(err)
+	_, banned := restarted.Banned("10.0.0.1", time.Now())
+	require.True(banned)
+}
+
+func TestConn(t *testing.T) {
+	require := require.New(t)
+	g := guardForTests(t)
+	defer g.DB.Close()
+	g.Limits.MessagesPerMinute = 3
+	g.Limits.BadMessages = 1
+	g.Limits.InvalidShareRatio = 0.5
+	g.Limits.InvalidShareMinimum = 4
+
+	now := time.Now()
+	c := g.NewConn()
+	for i := 0; i < 3; i++ {
+		require.Empty(c.Message(true, now))
+	}
+	require.Equal(ReasonMessageFlood, c.Message(true, now))
+
+	// The count starts over each minute
+	now = now.Add(time.Minute)
+	require.Empty(c.Message(false, now))
+	require.Equal(ReasonBadMessages, c.Message(false, now))
+
+	// The ratio is only checked after the minimum
+	c = g.NewConn()
+	require.Empty(c.Share(true))
+	require.Empty(c.Share(true))
+	require.Empty(c.Share(false))
+	require.Empty(c.Share(false)) // 2 of 4 is not over half
+	require.Equal(ReasonInvalidShares, c.Share(true))
+}
@@ -0,0 +1,27 @@
+package abuse
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	bans = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pool_abuse_bans_total",
+		Help: "Bans made, by reason",
+	}, []string{"reason"})
+	refused = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pool_abuse_refused_total",
+		Help: "Connections and authorizations refused, by why",
+	}, []string{"reason"})
+)
+
+var prom sync.Once
+
+func RegisterPrometheus() {
+	prom.Do(func() {
+		prometheus.MustRegister(bans)
+		prometheus.MustRegister(refused)
+	})
+}
@@ -3,10 +3,14 @@ package accounting
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/FactomWyomingEntity/prosper-pool/config"
 	"github.com/FactomWyomingEntity/prosper-pool/difficulty"
+	"github.com/FactomWyomingEntity/prosper-pool/events"
 	"github.com/FactomWyomingEntity/prosper-pool/stratum"
 	"github.com/jinzhu/gorm"
 	"github.com/shopspring/decimal"
@@ -23,7 +27,8 @@ const AccountingPrecision = 8
 type Accountant struct {
 	DB *gorm.DB
 
-	// Jobs are indexed by job id
+	// Jobs are indexed by job id. The miner share maps are keyed by the
+	// WorkerKey, as minerids are only unique per user.
 	jobLock     sync.RWMutex
 	JobsByMiner map[int32]*ShareMap
 	JobsByUser  map[int32]*ShareMap
@@ -31,6 +36,8 @@ type Accountant struct {
 	newJobs     chan int32
 	rewards     chan *Reward
 	submissions <-chan *stratum.ShareSubmission
+	// sealed are the jobs waiting for their payouts to be written
+	sealed chan sealedJob
 
 	// shares is mainly used for debug/testing. Most submissions come from
 	// Stratum.
@@ -38,6 +45,15 @@ type Accountant struct {
 
 	// Pool Configuration
 	PoolFeeRate decimal.Decimal
+	// DeductECCost will take the ec cost of a job's submissions out of the
+	// rewards before the pool cut.
+	DeductECCost bool
+	// ReferralBonusRate is the cut of a referred user's payout paid to their
+	// referrer, out of the pool fee. 0 is no bonus.
+	ReferralBonusRate decimal.Decimal
+
+	// Bus is told what each user is credited, and what they are paid
+	Bus *events.Bus
 }
 
 func NewAccountant(conf *viper.Viper, db *gorm.DB) (*Accountant, error) {
@@ -46,12 +62,15 @@ func NewAccountant(conf *viper.Viper, db *gorm.DB) (*Accountant, error) {
 	a.shares = make(chan *Share, 100)
 	a.rewards = make(chan *Reward, 1000)
 	a.newJobs = make(chan int32, 100)
+	a.sealed = make(chan sealedJob, 100)
 	a.JobsByMiner = make(map[int32]*ShareMap)
 	a.JobsByUser = make(map[int32]*ShareMap)
 
 	a.DB.AutoMigrate(&UserOwedPayouts{})
 	a.DB.AutoMigrate(&OwedPayouts{})
 	a.DB.AutoMigrate(&Paid{})
+	a.DB.AutoMigrate(&JobLedger{})
+	a.DB.AutoMigrate(&ReferralBonus{})
 
 	cut := conf.GetString(config.ConfigPoolCut)
 
@@ -72,6 +91,16 @@ func NewAccountant(conf *viper.Viper, db *gorm.DB) (*Accountant, error) {
 	}
 
 	a.PoolFeeRate = a.PoolFeeRate.Truncate(AccountingPrecision)
+	a.DeductECCost = conf.GetBool(config.ConfigPoolDeductECCost)
+
+	bonus, err := decimal.NewFromString(conf.GetString(config.ConfigPoolReferralBonus))
+	if err != nil {
+		return nil, fmt.Errorf("referral bonus: %s", err.Error())
+	}
+	if bonus.IsNegative() || bonus.GreaterThan(decimal.New(1, 0)) {
+		return nil, fmt.Errorf("referral bonus must be between 0 and 1")
+	}
+	a.ReferralBonusRate = bonus.Truncate(AccountingPrecision)
 
 	return a, nil
 }
@@ -88,41 +117,32 @@ func (a *Accountant) ShareChannel() chan<- *Share {
 	return a.shares
 }
 
+func (a *Accountant) SetBus(b *events.Bus) {
+	a.Bus = b
+}
+
 func (a *Accountant) SetSubmissions(subs <-chan *stratum.ShareSubmission) {
 	a.submissions = subs
 }
 
 // Listen accepts new shares and shares for handling the payout accounting.
+// The shares are all handled here, in the order they come in. The payouts are
+// written in their own routine, so the shares never wait on the database.
 func (a *Accountant) Listen(ctx context.Context) {
+	go a.writePayouts(ctx)
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case submit := <-a.submissions:
-			// A new share from a miner that we need to account for
-			if !a.JobExists(submit.JobID) {
-				acctLog.WithFields(log.Fields{
-					"job":     submit.JobID,
-					"minerid": submit.MinerID,
-					"userid":  submit.Username,
-				}).Debugf("share submitted, but no job exits")
-				continue // Nothing to do if the job does not exist
-			}
-
-			share := Share{
-				JobID:      submit.JobID,
-				Nonce:      submit.Nonce,
-				Difficulty: difficulty.DifficultyFromTarget(submit.Target, difficulty.PDiff),
-				Target:     submit.Target,
-				// The share will be rejected if sealed
-				Accepted: true,
-				MinerID:  submit.MinerID,
-				UserID:   submit.Username,
-			}
-
-			a.AddShare(share)
+			// The job is sent before its first share, but the select could
+			// pick the share first
+			a.drainJobs()
+			a.addSubmission(submit)
 		case share := <-a.shares:
 			// A share from somewhere internal (probably a test)
+			a.drainJobs()
 			if !a.JobExists(share.JobID) {
 				acctLog.WithFields(log.Fields{
 					"job":     share.JobID,
@@ -133,68 +153,208 @@ func (a *Accountant) Listen(ctx context.Context) {
 			}
 
 			a.AddShare(*share)
-
 		case newJob := <-a.newJobs:
-			if a.JobExists(newJob) {
-				acctLog.WithFields(log.Fields{
-					"job": newJob,
-				}).Warnf("newjob, but already exists")
-				continue
-			}
-			a.NewJob(newJob)
+			a.addJob(newJob)
 		case reward := <-a.rewards:
-			rLog := acctLog.WithFields(log.Fields{
-				"job": reward.JobID,
-				"peg": reward.PoolReward / 1e8,
-			})
-			// Indication of a block being completed and us earning rewards
-			if !a.JobExists(reward.JobID) {
-				// TODO: We will still do the accounting so our numbers add up.
-				// 		But we should really see if we can do something to
-				//		payout our users if this happens. Like if we reboot
-				//		the pool, and didn't keep the user's pow. We could
-				//		just use the last blocks proportions or something.
-				rLog.Warnf("reward for job that does not exist")
-				a.JobsByMiner[reward.JobID] = NewShareMap()
-				a.JobsByUser[reward.JobID] = NewShareMap()
-			}
+			// Every share accepted before the reward is counted before the
+			// job is sealed
+			a.drainJobs()
+			a.drainSubmissions()
+			a.sealed <- a.sealJob(reward)
+		}
+	}
+}
 
-			a.jobLock.Lock()
-			us := a.JobsByUser[reward.JobID]
-			ms := a.JobsByMiner[reward.JobID]
+// drainJobs adds the new jobs that are waiting
+func (a *Accountant) drainJobs() {
+	for {
+		select {
+		case newJob := <-a.newJobs:
+			a.addJob(newJob)
+		default:
+			return
+		}
+	}
+}
 
-			if us.TotalDiff != ms.TotalDiff {
-				rLog.Error("miner job sum and user job sum differ")
-			}
-			us.Seal()
-			ms.Seal()
-
-			// Setup the payout struct with all the proportional payouts.
-			// This will also calculate the pool cut
-			pays := NewPayout(*reward, a.PoolFeeRate, *us)
-
-			dbErr := a.DB.FirstOrCreate(pays)
-			if dbErr.Error != nil {
-				// TODO: This is pretty bad. This means payments failed.
-				// 		We don't want to just panic and kill the pool.
-				//		Maybe, we can just write everything to a file,
-				//		and try to notify someone?
-
-				// TODO: Write to a file all the details so we can recover the payments
-				rLog.WithError(dbErr.Error).Error("failed to write payouts to database")
-			}
+// drainSubmissions adds the shares that are waiting
+func (a *Accountant) drainSubmissions() {
+	for {
+		select {
+		case submit := <-a.submissions:
+			a.addSubmission(submit)
+		default:
+			return
+		}
+	}
+}
+
+func (a *Accountant) addJob(newJob int32) {
+	if a.JobExists(newJob) {
+		acctLog.WithFields(log.Fields{
+			"job": newJob,
+		}).Warnf("newjob, but already exists")
+		return
+	}
+	a.NewJob(newJob)
+}
+
+// addSubmission adds a share from a miner that we need to account for
+func (a *Accountant) addSubmission(submit *stratum.ShareSubmission) {
+	share := Share{
+		JobID:      submit.JobID,
+		Nonce:      submit.Nonce,
+		Difficulty: difficulty.DifficultyFromTarget(submit.Target, difficulty.PDiff),
+		Target:     submit.Target,
+		// The share will be rejected if sealed
+		Accepted: true,
+		MinerID:  submit.MinerID,
+		UserID:   submit.Username,
+	}
+
+	if reason := a.AddShare(share); reason != "" {
+		// Stratum told the miner this share was accepted
+		sharesLost.WithLabelValues(reason).Inc()
+		acctLog.WithFields(log.Fields{
+			"job":     submit.JobID,
+			"minerid": submit.MinerID,
+			"userid":  submit.Username,
+			"reason":  reason,
+		}).Errorf("accepted share not counted")
+		return
+	}
+	sharesCounted.Inc()
+}
+
+// sealedJob is a job that takes no more shares, waiting for its payouts to
+// be written
+type sealedJob struct {
+	reward *Reward
+	users  *ShareMap
+}
+
+// sealJob stops the job taking shares
+func (a *Accountant) sealJob(reward *Reward) sealedJob {
+	rLog := acctLog.WithFields(log.Fields{"job": reward.JobID})
+
+	a.jobLock.Lock()
+	defer a.jobLock.Unlock()
+	// Indication of a block being completed and us earning rewards
+	if _, ok := a.JobsByMiner[reward.JobID]; !ok {
+		// TODO: We will still do the accounting so our numbers add up.
+		// 		But we should really see if we can do something to
+		//		payout our users if this happens. Like if we reboot
+		//		the pool, and didn't keep the user's pow. We could
+		//		just use the last blocks proportions or something.
+		rLog.Warnf("reward for job that does not exist")
+		a.JobsByMiner[reward.JobID] = NewShareMap()
+		a.JobsByUser[reward.JobID] = NewShareMap()
+	}
+
+	us := a.JobsByUser[reward.JobID]
+	ms := a.JobsByMiner[reward.JobID]
+
+	if us.TotalDiff != ms.TotalDiff {
+		rLog.Error("miner job sum and user job sum differ")
+	}
+	us.Seal()
+	ms.Seal()
+	return sealedJob{reward: reward, users: us}
+}
+
+// writePayouts writes the payouts of the sealed jobs. The share maps are
+// sealed, so they can be read without the job lock.
+func (a *Accountant) writePayouts(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-a.sealed:
+			a.writePayout(job.reward, job.users)
+		}
+	}
+}
 
-			rLog.WithFields(log.Fields{"pool-diff": us.TotalDiff}).Infof("pool stats")
-			a.jobLock.Unlock()
+func (a *Accountant) writePayout(reward *Reward, us *ShareMap) {
+	rLog := acctLog.WithFields(log.Fields{
+		"job": reward.JobID,
+		"peg": reward.PoolReward / 1e8,
+	})
+
+	// Tally what the job cost us in submissions
+	ledger, err := a.NewJobLedger(*reward)
+	if err != nil {
+		rLog.WithError(err).Error("failed to tally job ledger")
+	} else {
+		if a.DeductECCost && ledger.ECCostPEG > 0 {
+			reward.OperatingExpense = ledger.ECCostPEG
+			ledger.Deducted = true
+		}
+		if dbErr := a.DB.FirstOrCreate(ledger); dbErr.Error != nil {
+			rLog.WithError(dbErr.Error).Error("failed to write job ledger to database")
 		}
 	}
+
+	// Setup the payout struct with all the proportional payouts.
+	// This will also calculate the pool cut
+	pays := NewPayout(*reward, a.PoolFeeRate, *us)
+	if !a.ReferralBonusRate.IsZero() {
+		users := make([]string, 0, len(pays.UserPayouts))
+		for _, pay := range pays.UserPayouts {
+			users = append(users, pay.UserID)
+		}
+		referrers, err := a.Referrers(users)
+		if err != nil {
+			rLog.WithError(err).Error("failed to find referrers, no referral bonuses paid")
+		} else {
+			pays.PayReferrals(referrers, a.ReferralBonusRate)
+		}
+	}
+
+	dbErr := a.DB.FirstOrCreate(pays)
+	if dbErr.Error != nil {
+		// TODO: This is pretty bad. This means payments failed.
+		// 		We don't want to just panic and kill the pool.
+		//		Maybe, we can just write everything to a file,
+		//		and try to notify someone?
+
+		// TODO: Write to a file all the details so we can recover the payments
+		rLog.WithError(dbErr.Error).Error("failed to write payouts to database")
+	} else {
+		for _, pay := range pays.UserPayouts {
+			a.Bus.Publish(events.RewardCredited{
+				JobID:  pays.JobID,
+				UserID: pay.UserID,
+				Payout: pay.Payout,
+				Time:   time.Now(),
+			})
+		}
+	}
+
+	rLog.WithFields(log.Fields{"pool-diff": us.TotalDiff}).Infof("pool stats")
 }
 
-func (a *Accountant) AddShare(share Share) {
+// Reasons an accepted share is not counted
+const (
+	LostNoJob  = "no-job"
+	LostSealed = "sealed"
+)
+
+// AddShare counts the share. It returns why the share was not counted, or
+// "" if it was.
+func (a *Accountant) AddShare(share Share) string {
 	a.jobLock.Lock()
-	a.JobsByMiner[share.JobID].AddShare(share.MinerID, share)
+	defer a.jobLock.Unlock()
+	ms, ok := a.JobsByMiner[share.JobID]
+	if !ok {
+		return LostNoJob
+	}
+	if ms.Sealed {
+		return LostSealed
+	}
+	ms.AddShare(WorkerKey(share.UserID, share.MinerID), share)
 	a.JobsByUser[share.JobID].AddShare(share.UserID, share)
-	a.jobLock.Unlock()
+	return ""
 }
 
 // NewJob adds a new job to the maps
@@ -211,3 +371,88 @@ func (a *Accountant) JobExists(jobid int32) bool {
 	_, ok := a.JobsByMiner[jobid]
 	return ok
 }
+
+// WorkerKey is the key for a miner in the JobsByMiner share maps
+func WorkerKey(userID, minerID string) string {
+	return userID + "," + minerID
+}
+
+// WorkerStats is the work done by a single miner of a user in a job
+type WorkerStats struct {
+	MinerID         string    `json:"minerid"`
+	TotalShares     int       `json:"shares"`
+	TotalDifficulty float64   `json:"difficulty"`
+	HashRate        float64   `json:"hashrate"` // In h/s
+	FirstShare      time.Time `json:"firstshare"`
+	LastShare       time.Time `json:"lastshare"`
+}
+
+// LatestJob returns the most recent job being accounted for, 0 if there is
+// none.
+func (a *Accountant) LatestJob() int32 {
+	a.jobLock.RLock()
+	defer a.jobLock.RUnlock()
+	var latest int32
+	for jobid := range a.JobsByMiner {
+		if jobid > latest {
+			latest = jobid
+		}
+	}
+	return latest
+}
+
+// UserWorkers returns the work done by each of the user's miners in the job
+func (a *Accountant) UserWorkers(jobid int32, userID string) []WorkerStats {
+	a.jobLock.RLock()
+	defer a.jobLock.RUnlock()
+
+	shares, ok := a.JobsByMiner[jobid]
+	if !ok {
+		return nil
+	}
+
+	prefix := WorkerKey(userID, "")
+	var workers []WorkerStats
+	for key, sum := range shares.Sums {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		w := WorkerStats{
+			MinerID:         strings.TrimPrefix(key, prefix),
+			TotalShares:     sum.TotalShares,
+			TotalDifficulty: sum.TotalDifficulty,
+			FirstShare:      sum.FirstShare,
+			LastShare:       sum.LastShare,
+		}
+		// Same as the payouts, too few shares is not a hashrate
+		if sum.TotalShares >= 5 {
+			w.HashRate = sum.LastHashrate()
+		}
+		workers = append(workers, w)
+	}
+
+	sort.Slice(workers, func(i, j int) bool { return workers[i].MinerID < workers[j].MinerID })
+	return workers
+}
+
+// PoolHashrate estimates the pool hashrate from the users' shares in the job.
+// It uses the same rules as the payouts.
+func (a *Accountant) PoolHashrate(jobid int32) float64 {
+	a.jobLock.RLock()
+	defer a.jobLock.RUnlock()
+
+	shares, ok := a.JobsByUser[jobid]
+	if !ok {
+		return 0
+	}
+
+	var hashrate float64
+	for _, sum := range shares.Sums {
+		if sum.TotalShares < 5 || sum.LastShare.Sub(sum.FirstShare) <= time.Second*20 {
+			continue
+		}
+		hashrate += sum.LastHashrate()
+	}
+	return hashrate
+}
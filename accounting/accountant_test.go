@@ -0,0 +1,115 @@
+package accounting_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	"github.com/FactomWyomingEntity/prosper-pool/difficulty"
+	"github.com/FactomWyomingEntity/prosper-pool/stratum"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/spf13/viper"
+)
+
+func TestAccountant_UserWorkers(t *testing.T) {
+	a := &Accountant{
+		JobsByMiner: make(map[int32]*ShareMap),
+		JobsByUser:  make(map[int32]*ShareMap),
+	}
+	a.NewJob(10)
+	a.NewJob(11)
+
+	// Both users have a miner called 'rig'
+	a.AddShare(Share{JobID: 11, Difficulty: 1, MinerID: "rig", UserID: "alice"})
+	a.AddShare(Share{JobID: 11, Difficulty: 2, MinerID: "rig", UserID: "alice"})
+	a.AddShare(Share{JobID: 11, Difficulty: 1, MinerID: "laptop", UserID: "alice"})
+	a.AddShare(Share{JobID: 11, Difficulty: 5, MinerID: "rig", UserID: "bob"})
+
+	if j := a.LatestJob(); j != 11 {
+		t.Errorf("exp latest job 11, found %d", j)
+	}
+
+	workers := a.UserWorkers(11, "alice")
+	if len(workers) != 2 {
+		t.Fatalf("exp 2 workers, found %d", len(workers))
+	}
+	if workers[0].MinerID != "laptop" || workers[0].TotalShares != 1 {
+		t.Errorf("bad laptop stats: %v", workers[0])
+	}
+	if workers[1].MinerID != "rig" || workers[1].TotalShares != 2 || workers[1].TotalDifficulty != 3 {
+		t.Errorf("bad rig stats: %v", workers[1])
+	}
+
+	if workers := a.UserWorkers(10, "alice"); len(workers) != 0 {
+		t.Errorf("exp no workers in job 10, found %d", len(workers))
+	}
+	if workers := a.UserWorkers(12, "alice"); len(workers) != 0 {
+		t.Errorf("exp no workers in a missing job, found %d", len(workers))
+	}
+}
+
+func TestAccountant_AddShare(t *testing.T) {
+	a := &Accountant{
+		JobsByMiner: make(map[int32]*ShareMap),
+		JobsByUser:  make(map[int32]*ShareMap),
+	}
+	a.NewJob(10)
+
+	if lost := a.AddShare(Share{JobID: 10, Difficulty: 1, MinerID: "rig", UserID: "alice"}); lost != "" {
+		t.Errorf("exp share counted, lost as %s", lost)
+	}
+	if lost := a.AddShare(Share{JobID: 11, Difficulty: 1, MinerID: "rig", UserID: "alice"}); lost != LostNoJob {
+		t.Errorf("exp share lost as %s, found '%s'", LostNoJob, lost)
+	}
+	a.JobsByMiner[10].Seal()
+	if lost := a.AddShare(Share{JobID: 10, Difficulty: 1, MinerID: "rig", UserID: "alice"}); lost != LostSealed {
+		t.Errorf("exp share lost as %s, found '%s'", LostSealed, lost)
+	}
+}
+
+// Every share sent before the job's reward is paid, even if the job and
+// reward are waiting at the same time as the shares.
+func TestAccountant_Listen(t *testing.T) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Exec("CREATE TABLE entry_submissions (job_id integer, blocked integer, ec_cost integer)")
+
+	conf := viper.New()
+	config.SetDefaults(conf)
+	a, err := NewAccountant(conf, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	submissions := make(chan *stratum.ShareSubmission, 10)
+	a.SetSubmissions(submissions)
+	for i := 0; i < 5; i++ {
+		submissions <- &stratum.ShareSubmission{Username: "alice", MinerID: "rig", JobID: 10, Target: difficulty.PDiff}
+	}
+	a.JobChannel() <- 10
+	a.RewardChannel() <- &Reward{JobID: 10, PoolReward: 100 * 1e8, Graded: 1, Winning: 1}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.Listen(ctx)
+
+	var pay UserOwedPayouts
+	for i := 0; i < 100; i++ {
+		if err := db.Where("job_id = ? AND user_id = ?", 10, "alice").First(&pay).Error; err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pay.TotalSubmissions != 5 {
+		t.Errorf("exp 5 shares paid, found %d", pay.TotalSubmissions)
+	}
+	if pay.Payout == 0 {
+		t.Errorf("exp a payout")
+	}
+}
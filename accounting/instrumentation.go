@@ -0,0 +1,27 @@
+package accounting
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	sharesCounted = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pool_accounting_shares_counted_total",
+		Help: "Accepted shares counted towards the payouts",
+	})
+	sharesLost = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pool_accounting_shares_lost_total",
+		Help: "Accepted shares that could not be counted, by why",
+	}, []string{"reason"})
+)
+
+var prom sync.Once
+
+func RegisterPrometheus() {
+	prom.Do(func() {
+		prometheus.MustRegister(sharesCounted)
+		prometheus.MustRegister(sharesLost)
+	})
+}
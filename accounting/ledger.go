@@ -0,0 +1,71 @@
+package accounting
+
+import (
+	"database/sql"
+	"math"
+)
+
+// ECPriceUSD is the fixed price of a single entry credit
+const ECPriceUSD = 0.001
+
+// JobLedger tracks the cost of our submissions for a job versus the rewards
+// we earned for it. This is how we know if our submission policy is
+// profitable.
+type JobLedger struct {
+	JobID int32 `gorm:"primary_key" json:"jobid"`
+
+	Submissions int `json:"submissions"` // Entries written to factomd
+	Blocked     int `json:"blocked"`     // Shares blocked from submitting
+
+	ECCost int64 `json:"eccost"` // Entry credits spent on submissions
+	// PEGPrice is the price of PEG in our opr for the job. It is used to
+	// convert the ec cost into PEG. If we do not know it, it is 0.
+	PEGPrice  float64 `json:"pegprice"`
+	ECCostPEG int64   `json:"eccostpeg"` // In PEG
+
+	PoolReward int64 `json:"poolreward"` // In PEG
+	Profit     int64 `json:"profit"`     // PoolReward - ECCostPEG
+	// Deducted is true if the ec cost was taken out of the rewards before
+	// the pool cut.
+	Deducted bool `json:"deducted"`
+}
+
+// ECCostToPEG converts the entry credit cost into PEG at the given PEG price.
+// If the PEG price is unknown, the cost is 0.
+func ECCostToPEG(ecs int64, pegPrice float64) int64 {
+	if pegPrice <= 0 {
+		return 0
+	}
+	return int64(math.Round(float64(ecs) * ECPriceUSD / pegPrice * 1e8))
+}
+
+// NewJobLedger tallies up the submission costs for the reward's job.
+func (a *Accountant) NewJobLedger(r Reward) (*JobLedger, error) {
+	l := new(JobLedger)
+	l.JobID = r.JobID
+	l.PoolReward = r.PoolReward
+	l.PEGPrice = r.PEGPrice
+
+	var submissions, blocked int
+	var cost sql.NullInt64
+	row := a.DB.Table("entry_submissions").
+		Where("job_id = ? AND blocked = 0", r.JobID).
+		Select("count(*), sum(ec_cost)").Row()
+	if err := row.Scan(&submissions, &cost); err != nil {
+		return nil, err
+	}
+
+	row = a.DB.Table("entry_submissions").
+		Where("job_id = ? AND blocked != 0", r.JobID).
+		Select("count(*)").Row()
+	if err := row.Scan(&blocked); err != nil {
+		return nil, err
+	}
+
+	l.Submissions = submissions
+	l.Blocked = blocked
+	l.ECCost = cost.Int64
+	l.ECCostPEG = ECCostToPEG(l.ECCost, l.PEGPrice)
+	l.Profit = l.PoolReward - l.ECCostPEG
+	return l, nil
+}
@@ -0,0 +1,30 @@
+package accounting_test
+
+import (
+	"testing"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/accounting"
+)
+
+func TestECCostToPEG(t *testing.T) {
+	type tVec struct {
+		ECs      int64
+		PEGPrice float64
+		Exp      int64
+	}
+
+	vecs := []tVec{
+		{ECs: 10, PEGPrice: 0, Exp: 0},       // Unknown price
+		{ECs: 10, PEGPrice: -1, Exp: 0},      // Bad price
+		{ECs: 0, PEGPrice: 0.001, Exp: 0},    // Nothing spent
+		{ECs: 10, PEGPrice: 0.001, Exp: 1e9}, // 1 EC == 1 PEG
+		{ECs: 25, PEGPrice: 0.005, Exp: 5 * 1e8},
+		{ECs: 1, PEGPrice: 0.003, Exp: 33333333},
+	}
+
+	for _, v := range vecs {
+		if r := ECCostToPEG(v.ECs, v.PEGPrice); r != v.Exp {
+			t.Errorf("%d ecs at %f, exp %d, found %d", v.ECs, v.PEGPrice, v.Exp, r)
+		}
+	}
+}
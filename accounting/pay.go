@@ -3,8 +3,10 @@ package accounting
 import (
 	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/FactomWyomingEntity/prosper-pool/authentication"
+	"github.com/FactomWyomingEntity/prosper-pool/events"
 	"github.com/jinzhu/gorm"
 )
 
@@ -20,6 +22,53 @@ type Paid struct {
 	TotalPaid int64 `gorm:"-"`
 }
 
+// Balance is what a user has earned, and what has been paid to them. All
+// amounts are in PEG.
+type Balance struct {
+	UserID string `json:"userid"`
+	Owed   int64  `json:"owed"` // Includes the referral bonuses
+	// Referrals is the part of what is owed from referral bonuses
+	Referrals int64 `json:"referrals"`
+	Paid      int64 `json:"paid"`
+	Balance   int64 `json:"balance"` // Owed - Paid
+}
+
+// UserBalance sums up the payouts owed and the payments made to a user
+func UserBalance(db *gorm.DB, userID string) (Balance, error) {
+	b := Balance{UserID: userID}
+
+	// Sum up what we paid
+	var paid sql.NullInt64
+	paidRow := db.Table("paids").
+		Where("user_id = ?", userID).Select("sum(payment_amount)").Row()
+	if err := paidRow.Scan(&paid); err != nil {
+		return b, err
+	}
+	b.Paid = paid.Int64
+
+	// Sum up what we owe
+	var owed sql.NullInt64
+	owedRow := db.Table("user_owed_payouts").
+		Where("user_id = ?", userID).Select("sum(payout)").Row()
+	if err := owedRow.Scan(&owed); err != nil {
+		return b, err
+	}
+	b.Owed = owed.Int64
+
+	// Sum up the referral bonuses
+	var referrals sql.NullInt64
+	referralRow := db.Model(&ReferralBonus{}).
+		Where("user_id = ?", userID).Select("sum(bonus)").Row()
+	if err := referralRow.Scan(&referrals); err != nil {
+		return b, err
+	}
+	b.Referrals = referrals.Int64
+	b.Owed += b.Referrals
+
+	b.Balance = b.Owed - b.Paid
+	return b, nil
+}
+
 // CalculatePayments does not insert the payments. It just preps them for
 // insert
 func (a *Accountant) CalculatePayments() ([]Paid, error) {
@@ -35,26 +84,17 @@ func (a *Accountant) CalculatePayments() ([]Paid, error) {
 	for _, u := range users {
 		var p Paid
 		p.UserID = u.UID
-		p.PayoutAddress = u.PayoutAddress
-		// Sum up what we paid
-		var paid sql.NullInt64
-		paidRow := a.DB.Table("paids").
-			Where("user_id = ?", u.UID).Select("sum(payment_amount)").Row()
-		err = paidRow.Scan(&paid)
+		// Address changes only receive payouts after their cooldown
+		p.PayoutAddress, err = authentication.PayoutAddressAt(a.DB, u, time.Now())
 		if err != nil {
 			return nil, err
 		}
-		p.TotalPaid = paid.Int64
-
-		// Sum up what we owe
-		var owed sql.NullInt64
-		owedRow := a.DB.Table("user_owed_payouts").
-			Where("user_id = ?", u.UID).Select("sum(payout)").Row()
-		err = owedRow.Scan(&owed)
+		balance, err := UserBalance(a.DB, u.UID)
 		if err != nil {
 			return nil, err
 		}
-		p.TotalOwed = owed.Int64
+		p.TotalPaid = balance.Paid
+		p.TotalOwed = balance.Owed
 
 		p.PaymentAmount = p.TotalOwed - p.TotalPaid
 		if p.PaymentAmount != 0 { // Don't include 0 payments
@@ -87,6 +127,18 @@ func (a *Accountant) WritePayments(payments []Paid) error {
 			return err
 		}
 	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
 
-	return tx.Commit().Error
+	for _, payment := range payments {
+		a.Bus.Publish(events.PayoutRecorded{
+			UserID:        payment.UserID,
+			PayoutAddress: payment.PayoutAddress,
+			EntryHash:     payment.EntryHash,
+			Amount:        payment.PaymentAmount,
+			Time:          time.Now(),
+		})
+	}
+	return nil
 }
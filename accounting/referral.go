@@ -0,0 +1,84 @@
+package accounting
+
+import (
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+// ReferralBonus is paid to the owner of the invite code a user joined with.
+// It is a cut of the referred user's payout, and it comes out of the pool
+// fee, so the referred user earns the same.
+type ReferralBonus struct {
+	JobID    int32  `gorm:"primary_key" json:"jobid"`
+	UserID   string `gorm:"primary_key" json:"userid"`   // The referrer earning the bonus
+	Referred string `gorm:"primary_key" json:"referred"` // The user that was referred
+	Bonus    int64  `json:"bonus"`                       // In PEG
+}
+
+// PayReferrals adds the referral bonuses to the payouts. Referrers are keyed
+// by the referred user. The bonuses cannot take more than the pool fee.
+func (p *OwedPayouts) PayReferrals(referrers map[string]string, rate decimal.Decimal) {
+	if rate.IsZero() || len(referrers) == 0 {
+		return
+	}
+
+	// Sorted so the same users get paid if the pool fee runs out
+	pays := make([]UserOwedPayouts, len(p.UserPayouts))
+	copy(pays, p.UserPayouts)
+	sort.Slice(pays, func(i, j int) bool { return pays[i].UserID < pays[j].UserID })
+
+	for _, pay := range pays {
+		referrer, ok := referrers[pay.UserID]
+		if !ok || referrer == pay.UserID {
+			continue
+		}
+
+		bonus := cut(pay.Payout, rate)
+		if bonus > p.PoolFee {
+			bonus = p.PoolFee
+		}
+		if bonus <= 0 {
+			continue
+		}
+
+		p.PoolFee -= bonus
+		p.ReferralBonus += bonus
+		p.Referrals = append(p.Referrals, ReferralBonus{
+			JobID:    p.JobID,
+			UserID:   referrer,
+			Referred: pay.UserID,
+			Bonus:    bonus,
+		})
+	}
+}
+
+// Referrers finds who referred each of the users. Only active referrers are
+// returned.
+func (a *Accountant) Referrers(users []string) (map[string]string, error) {
+	referrers := make(map[string]string)
+	if len(users) == 0 {
+		return referrers, nil
+	}
+
+	rows, err := a.DB.Table("users").
+		Select("users.uid, invite_codes.owner").
+		Joins("JOIN invite_codes ON invite_codes.code = users.invite_code").
+		Joins("JOIN users AS owners ON owners.uid = invite_codes.owner").
+		Where("users.uid IN (?) AND invite_codes.owner <> ''", users).
+		Where("owners.status = '' AND owners.deleted_at IS NULL").
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user, owner string
+		if err := rows.Scan(&user, &owner); err != nil {
+			return nil, err
+		}
+		referrers[user] = owner
+	}
+	return referrers, rows.Err()
+}
@@ -0,0 +1,63 @@
+package accounting_test
+
+import (
+	"testing"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/shopspring/decimal"
+)
+
+func TestOwedPayouts_PayReferrals(t *testing.T) {
+	newPays := func() *OwedPayouts {
+		p := new(OwedPayouts)
+		p.JobID = 10
+		p.PoolFee = 100
+		p.UserPayouts = []UserOwedPayouts{
+			{UserID: "alice", Payout: 1000},
+			{UserID: "bob", Payout: 2000},
+			{UserID: "carol", Payout: 500},
+		}
+		return p
+	}
+	referrers := map[string]string{
+		"alice": "carol",
+		"bob":   "carol",
+		"carol": "carol", // Cannot refer yourself
+	}
+
+	t.Run("bonus out of the pool fee", func(t *testing.T) {
+		p := newPays()
+		p.PayReferrals(referrers, decimal.NewFromFloat(0.01))
+		if len(p.Referrals) != 2 {
+			t.Fatalf("exp 2 referrals, found %d", len(p.Referrals))
+		}
+		if p.ReferralBonus != 30 || p.PoolFee != 70 {
+			t.Errorf("exp bonus 30 and fee 70, found %d and %d", p.ReferralBonus, p.PoolFee)
+		}
+		for _, r := range p.Referrals {
+			if r.UserID != "carol" || r.JobID != 10 {
+				t.Errorf("bad referral %v", r)
+			}
+		}
+	})
+
+	t.Run("capped at the pool fee", func(t *testing.T) {
+		p := newPays()
+		p.PayReferrals(referrers, decimal.NewFromFloat(0.05))
+		// alice is first, 50. bob gets the remaining 50
+		if p.ReferralBonus != 100 || p.PoolFee != 0 {
+			t.Errorf("exp bonus 100 and fee 0, found %d and %d", p.ReferralBonus, p.PoolFee)
+		}
+		if p.Referrals[0].Referred != "alice" || p.Referrals[1].Bonus != 50 {
+			t.Errorf("bad referrals %v", p.Referrals)
+		}
+	})
+
+	t.Run("no rate", func(t *testing.T) {
+		p := newPays()
+		p.PayReferrals(referrers, decimal.Zero)
+		if len(p.Referrals) != 0 || p.PoolFee != 100 {
+			t.Errorf("exp no referrals")
+		}
+	})
+}
@@ -23,7 +23,12 @@ type OwedPayouts struct {
 	PDiff         string  `gorm:"default:'ffff000000000000'" json:"pdiff"` // String to avoid sql uint64 errors
 	TotalHashrate float64 `gorm:"default:0" json:"totalhashrate"`
 
+	// ReferralBonus is the total paid to referrers, it is taken out of the
+	// PoolFee.
+	ReferralBonus int64 `gorm:"default:0" json:"referralbonus"`
+
 	UserPayouts []UserOwedPayouts `gorm:"foreignkey:JobID" json:"userpayouts,omitempty"`
+	Referrals   []ReferralBonus   `gorm:"foreignkey:JobID" json:"referrals,omitempty"`
 }
 
 func NewPayout(r Reward, poolFeeRate decimal.Decimal, work ShareMap) *OwedPayouts {
@@ -31,7 +36,8 @@ func NewPayout(r Reward, poolFeeRate decimal.Decimal, work ShareMap) *OwedPayout
 	p.PoolFeeRate = poolFeeRate
 	p.Reward = r
 	p.PDiff = fmt.Sprintf("%x", difficulty.PDiff)
-	remaining := p.TakePoolCut(p.Reward.PoolReward)
+	remaining := p.TakeOperatingExpense(p.Reward.PoolReward)
+	remaining = p.TakePoolCut(remaining)
 	p.Payouts(work, remaining)
 
 	return p
@@ -70,6 +76,19 @@ func (p *OwedPayouts) Payouts(work ShareMap, remaining int64) {
 	p.Dust = remaining - totalPayout
 }
 
+// TakeOperatingExpense will take any operating expenses, like the ec cost of
+// our submissions, and return the remaining rewards. Expenses cannot take more
+// than the rewards.
+func (p *OwedPayouts) TakeOperatingExpense(remaining int64) int64 {
+	if p.OperatingExpense > remaining {
+		p.OperatingExpense = remaining
+	}
+	if p.OperatingExpense < 0 {
+		p.OperatingExpense = 0
+	}
+	return remaining - p.OperatingExpense
+}
+
 // TakePoolCut will take the amount owed the pool, and return the
 // remaining rewards to be distributed
 func (p *OwedPayouts) TakePoolCut(remaining int64) int64 {
@@ -107,6 +126,14 @@ type Reward struct {
 
 	Winning int `json:"winningoprs"` // Number of oprs in the winning set
 	Graded  int `json:"gradedoprs"`  // Number of oprs in the graded set
+
+	// GradedSize is the number of oprs in the graded set from all miners
+	GradedSize int `gorm:"default:0" json:"gradedsize"`
+
+	// OperatingExpense is taken out before the pool cut. In PEG
+	OperatingExpense int64 `gorm:"default:0" json:"operatingexpense"`
+	// PEGPrice is the PEG price in the pool's opr for the job, if known.
+	PEGPrice float64 `gorm:"-" json:"-"`
 }
 
 // Share is an accepted piece of work done by a miner.
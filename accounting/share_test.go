@@ -100,6 +100,50 @@ func TestPayouts_TakePoolCut(t *testing.T) {
 	})
 }
 
+func TestPayouts_TakeOperatingExpense(t *testing.T) {
+	type tVec struct {
+		Expense   int64
+		Reward    int64
+		Remaining int64
+		Taken     int64
+	}
+
+	vecs := []tVec{
+		{Expense: 0, Reward: 10 * 1e8, Remaining: 10 * 1e8, Taken: 0},
+		{Expense: 1e8, Reward: 10 * 1e8, Remaining: 9 * 1e8, Taken: 1e8},
+		{Expense: 20 * 1e8, Reward: 10 * 1e8, Remaining: 0, Taken: 10 * 1e8}, // Can't take more than the reward
+		{Expense: -1, Reward: 10 * 1e8, Remaining: 10 * 1e8, Taken: 0},
+	}
+
+	for _, v := range vecs {
+		pays := OwedPayouts{}
+		pays.OperatingExpense = v.Expense
+
+		remain := pays.TakeOperatingExpense(v.Reward)
+		if remain != v.Remaining {
+			t.Errorf("exp %d remain, found %d", v.Remaining, remain)
+		}
+		if pays.OperatingExpense != v.Taken {
+			t.Errorf("exp %d taken, found %d", v.Taken, pays.OperatingExpense)
+		}
+	}
+
+	t.Run("expense before the pool cut", func(t *testing.T) {
+		pays := NewPayout(Reward{
+			JobID:            100,
+			PoolReward:       100 * 1e8,
+			OperatingExpense: 10 * 1e8,
+		}, decimal.NewFromFloat(0.10), *randomShareMap(100, 0))
+
+		if pays.PoolFee != 9*1e8 {
+			t.Errorf("exp pool fee of %d, found %d", int64(9*1e8), pays.PoolFee)
+		}
+		if pays.Dust+pays.PoolFee+pays.OperatingExpense != pays.Reward.PoolReward {
+			t.Errorf("dust + pool cut + expense should equal reward")
+		}
+	})
+}
+
 func TestNewPayout(t *testing.T) {
 	// Just testing the float -> int math and proportions
 	t.Run("ensure props add to 100", func(t *testing.T) {
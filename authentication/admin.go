@@ -0,0 +1,195 @@
+package authentication
+
+import (
+	crand "crypto/rand"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Factom-Asset-Tokens/base58"
+)
+
+// User roles
+const (
+	RoleUser  = ""
+	RoleAdmin = "admin"
+)
+
+// User statuses. Disabled and banned users cannot mine or log in. A ban is
+// for abuse, a disable is for anything else, like a user asking to leave.
+const (
+	StatusActive   = ""
+	StatusDisabled = "disabled"
+	StatusBanned   = "banned"
+)
+
+// AuditLog records every admin change, so we know who did what and when.
+type AuditLog struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"created"`
+	Admin     string    `gorm:"index:audit_admin" json:"admin"` // The admin, or 'cli'
+	Action    string    `json:"action"`
+	Target    string    `gorm:"index:audit_target" json:"target"` // The user or invite code changed
+	Detail    string    `json:"detail"`
+}
+
+// Audit actions
+const (
+	AuditCreateCode    = "create-code"
+	AuditRevokeCode    = "revoke-code"
+	AuditSetRole       = "set-role"
+	AuditSetStatus     = "set-status"
+	AuditPayoutAddress = "payout-address"
+	AuditResetTOTP     = "reset-2fa"
+	AuditClearBan      = "clear-ban"
+)
+
+// Audit records an admin change made outside the authenticator
+func (a *Authenticator) Audit(admin, action, target, detail string) error {
+	return a.audit(admin, action, target, detail)
+}
+
+func (a *Authenticator) audit(admin, action, target, detail string) error {
+	return a.DB.Create(&AuditLog{
+		Admin:  admin,
+		Action: action,
+		Target: target,
+		Detail: detail,
+	}).Error
+}
+
+// GenerateCode makes a new random invite code, and records who made it
+func (a *Authenticator) GenerateCode(admin string, opts CodeOptions) (string, error) {
+	data := make([]byte, 20)
+	_, _ = crand.Read(data)
+	code := base58.Encode(data)
+
+	opts.setDefaults()
+	if err := a.NewCodeWithOptions(code, admin, opts); err != nil {
+		return "", err
+	}
+
+	detail := fmt.Sprintf("uses: %d, owner: '%s', note: '%s'", opts.MaxRedemptions, opts.Owner, opts.Note)
+	if opts.ExpiresAt != nil {
+		detail += ", expires: " + opts.ExpiresAt.Format(time.RFC3339)
+	}
+	return code, a.audit(admin, AuditCreateCode, code, detail)
+}
+
+// ListCodes returns the invite codes, newest first. Claimed, revoked and
+// expired codes are only included if asked for.
+func (a *Authenticator) ListCodes(all bool) ([]InviteCode, error) {
+	var codes []InviteCode
+	q := a.DB.Order("created_at desc")
+	if !all {
+		q = q.Where("claimed = ? AND revoked = ?", false, false).
+			Where("expires_at IS NULL OR expires_at > ?", time.Now())
+	}
+	err := q.Find(&codes).Error
+	return codes, err
+}
+
+// RevokeCode stops an unclaimed invite code from being used
+func (a *Authenticator) RevokeCode(code, admin string) error {
+	res := a.DB.Model(&InviteCode{}).Where("code = ? AND claimed = ? AND revoked = ?", code, false, false).Update("revoked", true)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("no unclaimed code '%s'", code)
+	}
+	return a.audit(admin, AuditRevokeCode, code, "")
+}
+
+// ListUsers returns all the users
+func (a *Authenticator) ListUsers() ([]User, error) {
+	var users []User
+	err := a.DB.Order("uid asc").Find(&users).Error
+	return users, err
+}
+
+// GetUser returns the user with the uid
+func (a *Authenticator) GetUser(uid string) (*User, error) {
+	var u User
+	if err := a.DB.Where("uid = ?", uid).First(&u).Error; err != nil {
+		return nil, fmt.Errorf("user '%s': %s", uid, err.Error())
+	}
+	return &u, nil
+}
+
+// SetRole promotes or demotes a user. The role is 'admin' or 'user'.
+func (a *Authenticator) SetRole(uid, role, admin string) error {
+	role = strings.ToLower(role)
+	switch role {
+	case "user":
+		role = RoleUser
+	case RoleAdmin:
+	default:
+		return fmt.Errorf("role must be 'user' or 'admin', found '%s'", role)
+	}
+
+	u, err := a.GetUser(uid)
+	if err != nil {
+		return err
+	}
+	// The update changes the model, so keep the old value for the audit
+	old := u.Role
+	if err := a.DB.Model(u).Update("role", role).Error; err != nil {
+		return err
+	}
+	return a.audit(admin, AuditSetRole, uid, fmt.Sprintf("'%s' -> '%s'", old, role))
+}
+
+// SetStatus enables, disables or bans a user. The status is 'active',
+// 'disabled' or 'banned'.
+func (a *Authenticator) SetStatus(uid, status, reason, admin string) error {
+	status = strings.ToLower(status)
+	switch status {
+	case "active":
+		status = StatusActive
+	case StatusDisabled, StatusBanned:
+	default:
+		return fmt.Errorf("status must be 'active', 'disabled' or 'banned', found '%s'", status)
+	}
+
+	u, err := a.GetUser(uid)
+	if err != nil {
+		return err
+	}
+	// Update with a map, so the zero values are written
+	old := u.Status
+	err = a.DB.Model(u).Updates(map[string]interface{}{
+		"status":        status,
+		"status_reason": reason,
+	}).Error
+	if err != nil {
+		return err
+	}
+	return a.audit(admin, AuditSetStatus, uid, fmt.Sprintf("'%s' -> '%s': %s", old, status, reason))
+}
+
+// AuditTrail returns the most recent audit logs. If target is set, only the
+// logs for that user or code are returned.
+func (a *Authenticator) AuditTrail(target string, limit int) ([]AuditLog, error) {
+	var logs []AuditLog
+	q := a.DB.Order("id desc").Limit(limit)
+	if target != "" {
+		q = q.Where("target = ?", target)
+	}
+	err := q.Find(&logs).Error
+	return logs, err
+}
+
+// Active returns if the user is allowed to mine and log in
+func (u *User) Active() bool {
+	return u.Status == StatusActive
+}
+
+// Referrals returns the users that joined with the owner's codes
+func (a *Authenticator) Referrals(owner string) ([]User, error) {
+	var users []User
+	err := a.DB.Joins("JOIN invite_codes ON invite_codes.code = users.invite_code").
+		Where("invite_codes.owner = ?", owner).
+		Order("users.uid asc").Find(&users).Error
+	return users, err
+}
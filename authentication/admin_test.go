@@ -0,0 +1,91 @@
+package authentication_test
+
+import (
+	"testing"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/authentication"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticator_Codes(t *testing.T) {
+	require := require.New(t)
+	a := AuthForTests(t, true)
+	defer a.DB.Close()
+
+	code, err := a.GenerateCode("admin@gmail.com", CodeOptions{})
+	require.NoError(err)
+	other, err := a.GenerateCode("admin@gmail.com", CodeOptions{})
+	require.NoError(err)
+
+	codes, err := a.ListCodes(false)
+	require.NoError(err)
+	require.Len(codes, 2)
+
+	require.NoError(a.RevokeCode(code, "admin@gmail.com"))
+	require.Error(a.RevokeCode(code, "admin@gmail.com"), "already revoked")
+	require.False(a.CodeUnclaimed(code))
+	require.False(a.Claim(code, "test@gmail.com"))
+
+	require.True(a.Claim(other, "test@gmail.com"))
+	require.Error(a.RevokeCode(other, "admin@gmail.com"), "already claimed")
+
+	codes, err = a.ListCodes(false)
+	require.NoError(err)
+	require.Len(codes, 0)
+	codes, err = a.ListCodes(true)
+	require.NoError(err)
+	require.Len(codes, 2)
+
+	logs, err := a.AuditTrail(code, 10)
+	require.NoError(err)
+	require.Len(logs, 2)
+	require.Equal(AuditRevokeCode, logs[0].Action)
+	require.Equal(AuditCreateCode, logs[1].Action)
+}
+
+func TestAuthenticator_ManageUsers(t *testing.T) {
+	require := require.New(t)
+	a := AuthForTests(t, true)
+	defer a.DB.Close()
+
+	RegisterUser(a, "test@gmail.com", "password")
+	require.True(a.Active("test@gmail.com"))
+
+	// Roles
+	require.NoError(a.SetRole("test@gmail.com", "admin", "cli"))
+	u, err := a.GetUser("test@gmail.com")
+	require.NoError(err)
+	require.Equal(RoleAdmin, u.Role)
+
+	require.NoError(a.SetRole("test@gmail.com", "user", "cli"))
+	u, err = a.GetUser("test@gmail.com")
+	require.NoError(err)
+	require.Equal(RoleUser, u.Role)
+
+	require.Error(a.SetRole("test@gmail.com", "root", "cli"))
+	require.Error(a.SetRole("unknown@gmail.com", "admin", "cli"))
+
+	// Statuses
+	require.NoError(a.SetStatus("test@gmail.com", "banned", "flooding", "cli"))
+	require.False(a.Active("test@gmail.com"))
+	u, err = a.GetUser("test@gmail.com")
+	require.NoError(err)
+	require.Equal("flooding", u.StatusReason)
+
+	require.NoError(a.SetStatus("test@gmail.com", "active", "", "cli"))
+	require.True(a.Active("test@gmail.com"))
+	require.Error(a.SetStatus("test@gmail.com", "gone", "", "cli"))
+
+	// Payout addresses
+	require.Error(a.SetPayoutAddress("test@gmail.com", "FA123", "cli"))
+	require.NoError(a.SetPayoutAddress("test@gmail.com", "FA2jK2HcLnRdS94dEcU27rF3meoJfpUcZPSinpb7AwQvPRY6RL1Q", "cli"))
+	u, err = a.GetUser("test@gmail.com")
+	require.NoError(err)
+	require.Equal("FA2jK2HcLnRdS94dEcU27rF3meoJfpUcZPSinpb7AwQvPRY6RL1Q", u.PayoutAddress)
+
+	logs, err := a.AuditTrail("test@gmail.com", 10)
+	require.NoError(err)
+	require.Len(logs, 5)
+	require.Equal(AuditPayoutAddress, logs[0].Action)
+	require.Equal("'' -> 'admin'", logs[4].Detail)
+}
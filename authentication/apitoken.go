@@ -0,0 +1,136 @@
+package authentication
+
+import (
+	crand "crypto/rand"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Factom-Asset-Tokens/base58"
+)
+
+// API token scopes
+const (
+	// ScopeStats can read the user's workers and hashrate
+	ScopeStats = "stats"
+	// ScopePayouts can read the user's balance, earnings and payments
+	ScopePayouts = "payouts"
+	// ScopeWorkers can manage the user's worker tokens
+	ScopeWorkers = "workers"
+)
+
+// AllScopes are all the api token scopes
+var AllScopes = []string{ScopeStats, ScopePayouts, ScopeWorkers}
+
+// APITokenPrefix starts every api token, so they are easy to tell apart from
+// worker tokens and passwords.
+const APITokenPrefix = "pp_"
+
+// APIToken lets scripts and monitoring tools use the api as a user, without
+// the user's login. Each token is limited to its scopes. Only the hash of the
+// token is kept.
+type APIToken struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"created"`
+	UserID    string    `gorm:"index:api_token_user" json:"userid"`
+	Name      string    `json:"name"`
+	// Scopes are comma separated
+	Scopes    string     `json:"scopes"`
+	TokenHash string     `gorm:"unique_index" json:"-"`
+	LastUsed  *time.Time `json:"lastused,omitempty"`
+	Revoked   bool       `gorm:"default:false" json:"revoked"`
+}
+
+// HasScope returns if the token is allowed the scope
+func (t APIToken) HasScope(scope string) bool {
+	for _, s := range strings.Split(t.Scopes, ",") {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// NewAPIToken makes a new api token for the user. The token is only returned
+// here, it cannot be looked up later.
+func (a *Authenticator) NewAPIToken(uid, name string, scopes []string) (string, *APIToken, error) {
+	if len(scopes) == 0 {
+		return "", nil, fmt.Errorf("an api token needs at least 1 scope")
+	}
+	for _, scope := range scopes {
+		if !scopeValid(scope) {
+			return "", nil, fmt.Errorf("unknown scope '%s', must be one of %s", scope, strings.Join(AllScopes, ", "))
+		}
+	}
+	if !a.Exists(uid) {
+		return "", nil, fmt.Errorf("user '%s' does not exist", uid)
+	}
+
+	data := make([]byte, 24)
+	if _, err := crand.Read(data); err != nil {
+		return "", nil, err
+	}
+	token := APITokenPrefix + base58.Encode(data)
+
+	t := &APIToken{
+		UserID:    uid,
+		Name:      name,
+		Scopes:    strings.Join(scopes, ","),
+		TokenHash: hashToken(token),
+	}
+	if err := a.DB.Create(t).Error; err != nil {
+		return "", nil, err
+	}
+	return token, t, nil
+}
+
+func scopeValid(scope string) bool {
+	for _, s := range AllScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APITokens returns the user's api tokens
+func (a *Authenticator) APITokens(uid string) ([]APIToken, error) {
+	var tokens []APIToken
+	err := a.DB.Where("user_id = ?", uid).Order("id desc").Find(&tokens).Error
+	return tokens, err
+}
+
+// RevokeAPIToken stops the token from working. Users can only revoke their
+// own tokens.
+func (a *Authenticator) RevokeAPIToken(uid string, id uint) error {
+	res := a.DB.Model(&APIToken{}).Where("id = ? AND user_id = ?", id, uid).Update("revoked", true)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("no api token %d", id)
+	}
+	return nil
+}
+
+// LookupAPIToken finds the token and its user. Revoked tokens and tokens of
+// inactive users are not found.
+func (a *Authenticator) LookupAPIToken(token string) (*APIToken, *User, error) {
+	var t APIToken
+	err := a.DB.Where("token_hash = ? AND revoked = ?", hashToken(token), false).First(&t).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid api token")
+	}
+
+	u, err := a.GetUser(t.UserID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid api token")
+	}
+	if !u.Active() {
+		return nil, nil, fmt.Errorf("user is %s", u.Status)
+	}
+
+	now := time.Now()
+	a.DB.Model(&t).Update("last_used", &now)
+	return &t, u, nil
+}
@@ -0,0 +1,53 @@
+package authentication_test
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/authentication"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticator_APITokens(t *testing.T) {
+	require := require.New(t)
+	a := AuthForTests(t, true)
+	defer a.DB.Close()
+
+	RegisterUser(a, "test@gmail.com", "password")
+
+	_, _, err := a.NewAPIToken("test@gmail.com", "monitor", nil)
+	require.Error(err, "needs a scope")
+	_, _, err = a.NewAPIToken("test@gmail.com", "monitor", []string{"admin"})
+	require.Error(err, "unknown scope")
+	_, _, err = a.NewAPIToken("unknown@gmail.com", "monitor", []string{ScopeStats})
+	require.Error(err, "unknown user")
+
+	token, info, err := a.NewAPIToken("test@gmail.com", "monitor", []string{ScopeStats, ScopePayouts})
+	require.NoError(err)
+	require.True(strings.HasPrefix(token, APITokenPrefix))
+
+	found, u, err := a.LookupAPIToken(token)
+	require.NoError(err)
+	require.Equal("test@gmail.com", u.UID)
+	require.True(found.HasScope(ScopeStats))
+	require.True(found.HasScope(ScopePayouts))
+	require.False(found.HasScope(ScopeWorkers))
+
+	_, _, err = a.LookupAPIToken(token + "x")
+	require.Error(err)
+
+	// Inactive users cannot use their tokens
+	require.NoError(a.SetStatus("test@gmail.com", StatusDisabled, "", "cli"))
+	_, _, err = a.LookupAPIToken(token)
+	require.Error(err)
+	require.NoError(a.SetStatus("test@gmail.com", "active", "", "cli"))
+
+	require.NoError(a.RevokeAPIToken("test@gmail.com", info.ID))
+	_, _, err = a.LookupAPIToken(token)
+	require.Error(err, "revoked")
+
+	tokens, err := a.APITokens("test@gmail.com")
+	require.NoError(err)
+	require.Len(tokens, 1)
+	require.NotNil(tokens[0].LastUsed)
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/FactomWyomingEntity/prosper-pool/config"
 	"github.com/qor/roles"
 
 	"github.com/jinzhu/gorm"
@@ -27,6 +28,10 @@ type Authenticator struct {
 	localMux *http.ServeMux
 
 	Authority *authority.Authority
+
+	// AdminTwoFactor only gives admins their role once they turn on two
+	// factor
+	AdminTwoFactor bool
 }
 
 type User struct {
@@ -34,6 +39,19 @@ type User struct {
 	UID           string `gorm:"column:uid"`
 	Role          string
 	PayoutAddress string `gorm:"default:''"`
+
+	// Status is empty for active users. See StatusDisabled and StatusBanned
+	Status       string `gorm:"default:''"`
+	StatusReason string `gorm:"default:''"`
+
+	// InviteCode is the code the user joined with
+	InviteCode string `gorm:"default:''"`
+
+	// TOTPSecret is set on enroll, and only used once TOTPEnabled is on
+	TOTPSecret  string `gorm:"column:totp_secret;default:''" json:"-"`
+	TOTPEnabled bool   `gorm:"column:totp_enabled;default:false"`
+	// TOTPLastStep is the step of the last code used, so it cannot be reused
+	TOTPLastStep int64 `gorm:"column:totp_last_step;default:0" json:"-"`
 }
 
 type HotfixedAuthIdentity auth_identity.AuthIdentity
@@ -63,11 +81,16 @@ func NewAuthenticator(conf *viper.Viper, db *gorm.DB) (*Authenticator, error) {
 		},
 	})
 	a.Authority = au
+	a.AdminTwoFactor = conf.GetBool(config.ConfigWebAdminTwoFactor)
 	a.RegisterRoles()
 
 	db.AutoMigrate(&HotfixedAuthIdentity{})
 	db.AutoMigrate(&User{})
 	db.AutoMigrate(&InviteCode{})
+	db.AutoMigrate(&AuditLog{})
+	db.AutoMigrate(&PayoutAddressChange{})
+	db.AutoMigrate(&WorkerToken{})
+	db.AutoMigrate(&APIToken{})
 
 	// Register Auth providers
 	// Allow use username/password
@@ -84,7 +107,16 @@ func (a Authenticator) Exists(uid string) bool {
 	return false
 }
 
-func (a Authenticator) GetSessionManager(mux *http.ServeMux) http.Handler {
+// Active returns if the user exists, and is not disabled or banned
+func (a Authenticator) Active(uid string) bool {
+	var u User
+	if a.DB.Where("uid = ?", uid).First(&u).Error != nil {
+		return false
+	}
+	return u.Active()
+}
+
+func (a Authenticator) GetSessionManager(mux http.Handler) http.Handler {
 	return manager.SessionManager.Middleware(mux)
 }
 
@@ -100,6 +132,10 @@ func (a *Authenticator) RegisterRoles() {
 		if currentUser == nil {
 			return false
 		}
-		return currentUser.(*User) != nil && currentUser.(*User).Role == "admin"
+		u, ok := currentUser.(*User)
+		if !ok || u == nil || u.Role != RoleAdmin || !u.Active() {
+			return false
+		}
+		return !a.AdminTwoFactor || u.TOTPEnabled
 	})
 }
@@ -5,13 +5,57 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"time"
+
+	"github.com/jinzhu/gorm"
 )
 
+// InviteCode lets users join the pool. A code can be redeemed up to its
+// MaxRedemptions, after which it is Claimed. ClaimedBy and ClaimedTime are the
+// last redemption.
 type InviteCode struct {
 	Code        string    `gorm:"primary_key"`
 	ClaimedTime time.Time `gorm:"not null"`
 	Claimed     bool      `gorm:"not null"`
 	ClaimedBy   string    `gorm:"not null"`
+	Revoked     bool      `gorm:"not null;default:false"`
+	CreatedAt   time.Time
+
+	MaxRedemptions int        `gorm:"not null;default:1"`
+	Redemptions    int        `gorm:"not null;default:0"`
+	ExpiresAt      *time.Time // Never expires if nil
+
+	// CreatedBy is the admin that made the code. Owner is the user the code
+	// belongs to, who is credited with the referrals. Codes without an owner
+	// earn no referral bonus.
+	CreatedBy string `gorm:"default:''"`
+	Owner     string `gorm:"index:invite_owner;default:''"`
+	Note      string `gorm:"default:''"`
+}
+
+// CodeOptions are the settings for a new invite code
+type CodeOptions struct {
+	// MaxRedemptions defaults to 1
+	MaxRedemptions int        `json:"maxredemptions"`
+	ExpiresAt      *time.Time `json:"expires,omitempty"`
+	Owner          string     `json:"owner"`
+	Note           string     `json:"note"`
+}
+
+func (o *CodeOptions) setDefaults() {
+	if o.MaxRedemptions == 0 {
+		o.MaxRedemptions = 1
+	}
+}
+
+// Redeemable returns if the code can still be used
+func (i InviteCode) Redeemable(now time.Time) bool {
+	if i.Code == "" || i.Claimed || i.Revoked {
+		return false
+	}
+	if i.ExpiresAt != nil && !now.Before(*i.ExpiresAt) {
+		return false
+	}
+	return i.Redemptions < i.MaxRedemptions
 }
 
 func (a *Authenticator) RegisterUser(username, password, invitecode, payoutAddress string) bool {
@@ -30,15 +74,41 @@ func (a *Authenticator) RegisterUser(username, password, invitecode, payoutAddre
 	resp := httptest.NewRecorder()
 	mux.ServeHTTP(resp, req)
 
-	fmt.Println(a.DB.Model(&User{}).Where("uid = ?", username).Update("payout_address", payoutAddress).Error)
+	err := a.DB.Model(&User{}).Where("uid = ?", username).Updates(map[string]interface{}{
+		"payout_address": payoutAddress,
+		"invite_code":    invitecode,
+	}).Error
+	if err != nil {
+		aLog.WithError(err).WithField("user", username).Error("failed to set payout address and invite code")
+	}
 
 	return true
 }
 
 func (a *Authenticator) NewCode(code string) error {
-	return a.DB.Create(&InviteCode{Code: code}).Error
+	return a.NewCodeWithOptions(code, "", CodeOptions{})
+}
+
+func (a *Authenticator) NewCodeWithOptions(code, createdBy string, opts CodeOptions) error {
+	opts.setDefaults()
+	if opts.MaxRedemptions < 0 {
+		return fmt.Errorf("max redemptions must be positive")
+	}
+	if opts.Owner != "" && !a.Exists(opts.Owner) {
+		return fmt.Errorf("owner '%s' is not a user", opts.Owner)
+	}
+
+	return a.DB.Create(&InviteCode{
+		Code:           code,
+		MaxRedemptions: opts.MaxRedemptions,
+		ExpiresAt:      opts.ExpiresAt,
+		CreatedBy:      createdBy,
+		Owner:          opts.Owner,
+		Note:           opts.Note,
+	}).Error
 }
 
+// CodeUnclaimed returns if the code can still be redeemed
 func (a *Authenticator) CodeUnclaimed(code string) bool {
 	var i InviteCode
 	dbErr := a.DB.Where("code = ?", code).Find(&i)
@@ -47,23 +117,38 @@ func (a *Authenticator) CodeUnclaimed(code string) bool {
 		return false
 	}
 
-	return i.Code != "" && i.Claimed == false
+	return i.Redeemable(time.Now())
 }
 
+// Claim redeems the code for the user
 func (a *Authenticator) Claim(code string, user string) bool {
 	if !a.CodeUnclaimed(code) {
 		return false
 	}
 
-	dbErr := a.DB.Model(&InviteCode{}).Where("code = ?", code).Updates(InviteCode{
-		Code:        code,
-		ClaimedTime: time.Now(),
-		Claimed:     true,
-		ClaimedBy:   user,
-	})
+	// The conditions are checked again in the update, so 2 users cannot
+	// take the last redemption.
+	now := time.Now()
+	dbErr := a.DB.Model(&InviteCode{}).
+		Where("code = ? AND claimed = ? AND revoked = ?", code, false, false).
+		Where("redemptions < max_redemptions").
+		Where("expires_at IS NULL OR expires_at > ?", now).
+		Updates(map[string]interface{}{
+			"redemptions":  gorm.Expr("redemptions + 1"),
+			"claimed_time": now,
+			"claimed_by":   user,
+		})
 	if dbErr.Error != nil {
 		// TODO: ?
 		return false
 	}
-	return dbErr.RowsAffected == 1
+	if dbErr.RowsAffected != 1 {
+		return false
+	}
+
+	// Used up codes are claimed
+	a.DB.Model(&InviteCode{}).
+		Where("code = ? AND redemptions >= max_redemptions", code).
+		Update("claimed", true)
+	return true
 }
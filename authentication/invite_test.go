@@ -0,0 +1,85 @@
+package authentication_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/authentication"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInviteCode_Redeemable(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+
+	vecs := []struct {
+		Code InviteCode
+		Exp  bool
+	}{
+		{InviteCode{Code: "a", MaxRedemptions: 1}, true},
+		{InviteCode{Code: "a", MaxRedemptions: 1, Redemptions: 1}, false},
+		{InviteCode{Code: "a", MaxRedemptions: 5, Redemptions: 4}, true},
+		{InviteCode{Code: "a", MaxRedemptions: 1, Claimed: true}, false},
+		{InviteCode{Code: "a", MaxRedemptions: 1, Revoked: true}, false},
+		{InviteCode{Code: "a", MaxRedemptions: 1, ExpiresAt: &past}, false},
+		{InviteCode{Code: "a", MaxRedemptions: 1, ExpiresAt: &future}, true},
+		{InviteCode{MaxRedemptions: 1}, false},
+	}
+
+	for i, v := range vecs {
+		if r := v.Code.Redeemable(now); r != v.Exp {
+			t.Errorf("[%d] exp %t, found %t", i, v.Exp, r)
+		}
+	}
+}
+
+func TestAuthenticator_MultiUseCode(t *testing.T) {
+	require := require.New(t)
+	a := AuthForTests(t, true)
+	defer a.DB.Close()
+
+	RegisterUser(a, "owner@gmail.com", "password")
+	_, err := a.GenerateCode("cli", CodeOptions{Owner: "unknown@gmail.com"})
+	require.Error(err, "owner must be a user")
+
+	code, err := a.GenerateCode("cli", CodeOptions{MaxRedemptions: 2, Owner: "owner@gmail.com", Note: "meetup"})
+	require.NoError(err)
+
+	require.True(a.RegisterUser("one@gmail.com", "password", code, ""))
+	require.True(a.RegisterUser("two@gmail.com", "password", code, ""))
+	require.False(a.RegisterUser("three@gmail.com", "password", code, ""), "code is used up")
+
+	codes, err := a.ListCodes(true)
+	require.NoError(err)
+	require.Len(codes, 1)
+	require.True(codes[0].Claimed)
+	require.Equal(2, codes[0].Redemptions)
+	require.Equal("meetup", codes[0].Note)
+	require.Equal("cli", codes[0].CreatedBy)
+
+	u, err := a.GetUser("one@gmail.com")
+	require.NoError(err)
+	require.Equal(code, u.InviteCode)
+
+	referred, err := a.Referrals("owner@gmail.com")
+	require.NoError(err)
+	require.Len(referred, 2)
+	require.Equal("one@gmail.com", referred[0].UID)
+}
+
+func TestAuthenticator_ExpiredCode(t *testing.T) {
+	require := require.New(t)
+	a := AuthForTests(t, true)
+	defer a.DB.Close()
+
+	past := time.Now().Add(-time.Minute)
+	code, err := a.GenerateCode("cli", CodeOptions{ExpiresAt: &past})
+	require.NoError(err)
+
+	require.False(a.CodeUnclaimed(code))
+	require.False(a.Claim(code, "test@gmail.com"))
+
+	codes, err := a.ListCodes(false)
+	require.NoError(err)
+	require.Len(codes, 0)
+}
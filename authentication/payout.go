@@ -0,0 +1,144 @@
+package authentication
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pegnet/pegnet/modules/factoidaddress"
+	"github.com/qor/auth/providers/password"
+)
+
+// PayoutAddressChange is the history of a user's payout addresses. A change
+// made by the user only receives payouts after the cooldown, so a stolen
+// login cannot redirect payouts before the user notices. The address in the
+// User table is always the latest address asked for.
+type PayoutAddressChange struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"created"`
+	UserID    string    `gorm:"index:payout_change_user" json:"userid"`
+	Address   string    `json:"address"`
+	Previous  string    `json:"previous"`
+	ChangedBy string    `json:"changedby"` // The user, an admin, or 'cli'
+
+	// EffectiveAt is when payouts go to the new address
+	EffectiveAt time.Time `json:"effective"`
+	// Superseded changes were replaced by a newer change before they took
+	// effect. They never receive payouts.
+	Superseded bool `gorm:"default:false" json:"superseded"`
+}
+
+// CheckPassword returns an error if the password is not the user's
+func (a *Authenticator) CheckPassword(uid, pass string) error {
+	provider, ok := a.GetProvider("password").(*password.Provider)
+	if !ok {
+		return fmt.Errorf("no password provider")
+	}
+
+	var identity HotfixedAuthIdentity
+	err := a.DB.Where("provider = ? AND uid = ?", provider.GetName(), uid).First(&identity).Error
+	if err != nil {
+		return fmt.Errorf("invalid password")
+	}
+
+	if provider.Encryptor.Compare(identity.EncryptedPassword, strings.TrimSpace(pass)) != nil {
+		return fmt.Errorf("invalid password")
+	}
+	return nil
+}
+
+// RequestPayoutAddress is a user changing their own payout address. Their
+// password is checked, and the address only receives payouts after the
+// cooldown. Any change still waiting on its cooldown is superseded.
+func (a *Authenticator) RequestPayoutAddress(uid, address, pass string, cooldown time.Duration) (*PayoutAddressChange, error) {
+	if err := a.CheckPassword(uid, pass); err != nil {
+		return nil, err
+	}
+	return a.ChangeOwnPayoutAddress(uid, address, cooldown)
+}
+
+// ChangeOwnPayoutAddress is RequestPayoutAddress once the caller has checked
+// the password, and anything else like a two factor code.
+func (a *Authenticator) ChangeOwnPayoutAddress(uid, address string, cooldown time.Duration) (*PayoutAddressChange, error) {
+	return a.changePayoutAddress(uid, address, uid, time.Now().Add(cooldown))
+}
+
+// SetPayoutAddress is an admin changing where a user is paid. It takes effect
+// right away.
+func (a *Authenticator) SetPayoutAddress(uid, address, admin string) error {
+	change, err := a.changePayoutAddress(uid, address, admin, time.Now())
+	if err != nil {
+		return err
+	}
+	return a.audit(admin, AuditPayoutAddress, uid, fmt.Sprintf("'%s' -> '%s'", change.Previous, address))
+}
+
+func (a *Authenticator) changePayoutAddress(uid, address, by string, effective time.Time) (*PayoutAddressChange, error) {
+	if err := factoidaddress.Valid(address); err != nil {
+		return nil, fmt.Errorf("payout address '%s' is invalid: %s", address, err.Error())
+	}
+
+	u, err := a.GetUser(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	change := &PayoutAddressChange{
+		UserID:      uid,
+		Address:     address,
+		Previous:    u.PayoutAddress,
+		ChangedBy:   by,
+		EffectiveAt: effective,
+	}
+
+	tx := a.DB.Begin()
+	err = tx.Model(&PayoutAddressChange{}).
+		Where("user_id = ? AND superseded = ? AND effective_at > ?", uid, false, time.Now()).
+		Update("superseded", true).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Create(change).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Model(u).Update("payout_address", address).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return change, tx.Commit().Error
+}
+
+// PayoutAddressHistory returns the user's address changes, newest first
+func (a *Authenticator) PayoutAddressHistory(uid string) ([]PayoutAddressChange, error) {
+	var changes []PayoutAddressChange
+	err := a.DB.Where("user_id = ?", uid).Order("id desc").Find(&changes).Error
+	return changes, err
+}
+
+// PayoutAddressAt returns the address the user should be paid to at the time.
+// It is the newest change that took effect. If no change took effect yet,
+// it is the address the user had before their first change.
+func PayoutAddressAt(db *gorm.DB, u User, t time.Time) (string, error) {
+	var change PayoutAddressChange
+	err := db.Where("user_id = ? AND superseded = ? AND effective_at <= ?", u.UID, false, t).
+		Order("effective_at desc, id desc").First(&change).Error
+	if err == nil {
+		return change.Address, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return "", err
+	}
+
+	// Nothing took effect, so the original address is still in use
+	err = db.Where("user_id = ?", u.UID).Order("id asc").First(&change).Error
+	if err == gorm.ErrRecordNotFound {
+		return u.PayoutAddress, nil // Never changed
+	}
+	if err != nil {
+		return "", err
+	}
+	return change.Previous, nil
+}
@@ -0,0 +1,72 @@
+package authentication_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/authentication"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	addrA = "FA2VxtLRw5FqQ7xYoTmg14VRsuZC5zZSPeCsLWbVWrLdpF2abt21"
+	addrB = "FA32i2GjZWtDv5qT5iK2k1GFja3GRSkwqyyNixx1uVmQ7zmxmFJn"
+	addrC = "FA3WWfn5mjDMY1ueTgLouJ6rCBUwATmB1hodBExF3Pu2qYsS4FG8"
+)
+
+func TestAuthenticator_CheckPassword(t *testing.T) {
+	require := require.New(t)
+	a := AuthForTests(t, true)
+	defer a.DB.Close()
+
+	RegisterUser(a, "test@gmail.com", "password")
+	require.NoError(a.CheckPassword("test@gmail.com", "password"))
+	require.Error(a.CheckPassword("test@gmail.com", "wrong"))
+	require.Error(a.CheckPassword("unknown@gmail.com", "password"))
+}
+
+func TestAuthenticator_RequestPayoutAddress(t *testing.T) {
+	require := require.New(t)
+	a := AuthForTests(t, true)
+	defer a.DB.Close()
+
+	code, err := a.GenerateCode("cli", CodeOptions{})
+	require.NoError(err)
+	require.True(a.RegisterUser("test@gmail.com", "password", code, addrA))
+
+	addressAt := func(t time.Time) string {
+		u, err := a.GetUser("test@gmail.com")
+		require.NoError(err)
+		addr, err := PayoutAddressAt(a.DB, *u, t)
+		require.NoError(err)
+		return addr
+	}
+	require.Equal(addrA, addressAt(time.Now()), "never changed")
+
+	_, err = a.RequestPayoutAddress("test@gmail.com", addrB, "wrong", time.Hour)
+	require.Error(err, "bad password")
+	_, err = a.RequestPayoutAddress("test@gmail.com", "FA123", "password", time.Hour)
+	require.Error(err, "bad address")
+
+	change, err := a.RequestPayoutAddress("test@gmail.com", addrB, "password", time.Hour)
+	require.NoError(err)
+	require.Equal(addrA, change.Previous)
+	require.Equal(addrA, addressAt(time.Now()), "still cooling down")
+	require.Equal(addrB, addressAt(time.Now().Add(2*time.Hour)))
+
+	// A new change replaces the pending one
+	_, err = a.RequestPayoutAddress("test@gmail.com", addrC, "password", time.Hour*4)
+	require.NoError(err)
+	require.Equal(addrA, addressAt(time.Now().Add(2*time.Hour)), "addrB was superseded")
+	require.Equal(addrC, addressAt(time.Now().Add(5*time.Hour)))
+
+	// Admins change it right away
+	require.NoError(a.SetPayoutAddress("test@gmail.com", addrB, "cli"))
+	require.Equal(addrB, addressAt(time.Now()))
+
+	history, err := a.PayoutAddressHistory("test@gmail.com")
+	require.NoError(err)
+	require.Len(history, 3)
+	require.True(history[1].Superseded)
+	require.True(history[2].Superseded)
+}
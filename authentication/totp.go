@@ -0,0 +1,251 @@
+package authentication
+
+import (
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/qor/session/manager"
+)
+
+// TOTP settings. These are the defaults of every authenticator app, so the
+// otpauth uri does not need to list them.
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	// totpSkew is the number of periods before and after now a code is
+	// accepted, for clocks that are a little off.
+	totpSkew = 1
+)
+
+// sessionTwoFactorKey holds the login that passed two factor in the session
+const sessionTwoFactorKey = "two_factor"
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpCode is the RFC 6238 code for the key at the step
+func totpCode(key []byte, step uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], step)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// TOTPCode returns the code for the base32 secret at the time
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	return totpCode(key, uint64(t.Unix())/totpPeriod), nil
+}
+
+// matchTOTP returns the step the code is for. Only steps after the last used
+// step match, so a code cannot be used twice.
+func matchTOTP(secret, code string, now time.Time, last int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= last {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(key, uint64(step))), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPURI is the otpauth uri authenticator apps read from a qr code
+func TOTPURI(issuer, uid, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	return fmt.Sprintf("otpauth://totp/%s:%s?%s", url.PathEscape(issuer), url.PathEscape(uid), v.Encode())
+}
+
+// EnrollTOTP makes a new secret for the user. Two factor is not on until the
+// user confirms a code from their app, so a secret that never made it into
+// the app cannot lock them out.
+func (a *Authenticator) EnrollTOTP(uid, issuer string) (secret string, uri string, err error) {
+	u, err := a.GetUser(uid)
+	if err != nil {
+		return "", "", err
+	}
+	if u.TOTPEnabled {
+		return "", "", fmt.Errorf("two factor is already enabled")
+	}
+
+	data := make([]byte, 20)
+	if _, err := crand.Read(data); err != nil {
+		return "", "", err
+	}
+	secret = totpEncoding.EncodeToString(data)
+
+	err = a.DB.Model(u).Updates(map[string]interface{}{
+		"totp_secret":    secret,
+		"totp_last_step": 0,
+	}).Error
+	if err != nil {
+		return "", "", err
+	}
+	return secret, TOTPURI(issuer, uid, secret), nil
+}
+
+// ConfirmTOTP turns on two factor, if the code matches the enrolled secret
+func (a *Authenticator) ConfirmTOTP(uid, code string) error {
+	u, err := a.GetUser(uid)
+	if err != nil {
+		return err
+	}
+	if u.TOTPEnabled {
+		return fmt.Errorf("two factor is already enabled")
+	}
+	if u.TOTPSecret == "" {
+		return fmt.Errorf("two factor is not enrolled")
+	}
+
+	step, ok := matchTOTP(u.TOTPSecret, strings.TrimSpace(code), time.Now(), u.TOTPLastStep)
+	if !ok {
+		return fmt.Errorf("invalid two factor code")
+	}
+	err = a.DB.Model(u).Updates(map[string]interface{}{
+		"totp_enabled":   true,
+		"totp_last_step": step,
+	}).Error
+	if err != nil {
+		return err
+	}
+	aLog.WithField("user", uid).Infof("two factor enabled")
+	return nil
+}
+
+// VerifyTOTP checks a code of a user with two factor on. Each code can only
+// be used once.
+func (a *Authenticator) VerifyTOTP(uid, code string) error {
+	u, err := a.GetUser(uid)
+	if err != nil {
+		return err
+	}
+	if !u.TOTPEnabled {
+		return fmt.Errorf("two factor is not enabled")
+	}
+
+	step, ok := matchTOTP(u.TOTPSecret, strings.TrimSpace(code), time.Now(), u.TOTPLastStep)
+	if !ok {
+		return fmt.Errorf("invalid two factor code")
+	}
+
+	// Only one request can move the step forward, so a code racing itself
+	// is still only used once
+	res := a.DB.Model(&User{}).Where("uid = ? AND totp_last_step < ?", uid, step).
+		Update("totp_last_step", step)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("invalid two factor code")
+	}
+	return nil
+}
+
+// CheckTwoFactor confirms a sensitive action. Users without two factor have
+// nothing to confirm.
+func (a *Authenticator) CheckTwoFactor(uid, code string) error {
+	u, err := a.GetUser(uid)
+	if err != nil {
+		return err
+	}
+	if !u.TOTPEnabled {
+		return nil
+	}
+	if code == "" {
+		return fmt.Errorf("a two factor code is required")
+	}
+	return a.VerifyTOTP(uid, code)
+}
+
+// DisableTOTP turns off two factor. The user must give a current code.
+func (a *Authenticator) DisableTOTP(uid, code string) error {
+	if err := a.VerifyTOTP(uid, code); err != nil {
+		return err
+	}
+	if err := a.clearTOTP(uid); err != nil {
+		return err
+	}
+	aLog.WithField("user", uid).Infof("two factor disabled")
+	return nil
+}
+
+// ResetTOTP is an admin turning off two factor for a user that lost their
+// device
+func (a *Authenticator) ResetTOTP(uid, admin string) error {
+	if err := a.clearTOTP(uid); err != nil {
+		return err
+	}
+	return a.audit(admin, AuditResetTOTP, uid, "")
+}
+
+func (a *Authenticator) clearTOTP(uid string) error {
+	u, err := a.GetUser(uid)
+	if err != nil {
+		return err
+	}
+	return a.DB.Model(u).Updates(map[string]interface{}{
+		"totp_enabled":   false,
+		"totp_secret":    "",
+		"totp_last_step": 0,
+	}).Error
+}
+
+// sessionLogin identifies the current login of the session, so a two factor
+// check does not carry over to the next login.
+func (a *Authenticator) sessionLogin(r *http.Request, u *User) string {
+	c, err := a.SessionStorer.Get(r)
+	if err != nil || c.LastLoginAt == nil || c.UserID == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s|%d", u.UID, c.LastLoginAt.UnixNano())
+}
+
+// TwoFactorVerified returns if the user passed two factor for this login
+func (a *Authenticator) TwoFactorVerified(r *http.Request, u *User) bool {
+	login := a.sessionLogin(r, u)
+	return login != "" && manager.SessionManager.Get(r, sessionTwoFactorKey) == login
+}
+
+// MarkSession marks the login as passing two factor
+func (a *Authenticator) MarkSession(w http.ResponseWriter, r *http.Request, u *User) error {
+	login := a.sessionLogin(r, u)
+	if login == "" {
+		return fmt.Errorf("not logged in")
+	}
+	return manager.SessionManager.Add(w, r, sessionTwoFactorKey, login)
+}
+
+// VerifySession checks the code, and marks the login as passing two factor
+func (a *Authenticator) VerifySession(w http.ResponseWriter, r *http.Request, u *User, code string) error {
+	if a.sessionLogin(r, u) == "" {
+		return fmt.Errorf("not logged in")
+	}
+	if err := a.VerifyTOTP(u.UID, code); err != nil {
+		return err
+	}
+	return a.MarkSession(w, r, u)
+}
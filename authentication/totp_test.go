@@ -0,0 +1,70 @@
+package authentication_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/authentication"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// The sha1 vectors of RFC 6238, cut to 6 digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" // "12345678901234567890"
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, exp := range vectors {
+		code, err := TOTPCode(secret, time.Unix(unix, 0))
+		require.NoError(t, err)
+		require.Equal(t, exp, code, "time %d", unix)
+	}
+}
+
+func TestAuthenticator_TOTP(t *testing.T) {
+	require := require.New(t)
+	a := AuthForTests(t, true)
+	defer a.DB.Close()
+
+	code, err := a.GenerateCode("cli", CodeOptions{})
+	require.NoError(err)
+	require.True(a.RegisterUser("test@gmail.com", "password", code, addrA))
+	require.NoError(a.CheckTwoFactor("test@gmail.com", ""), "two factor is off")
+
+	secret, uri, err := a.EnrollTOTP("test@gmail.com", "Prosper Pool")
+	require.NoError(err)
+	require.Contains(uri, "otpauth://totp/Prosper%20Pool:test@gmail.com?")
+	require.Contains(uri, "secret="+secret)
+	require.Error(a.VerifyTOTP("test@gmail.com", "000000"), "not on until confirmed")
+	require.NoError(a.CheckTwoFactor("test@gmail.com", ""), "not on until confirmed")
+
+	now, err := TOTPCode(secret, time.Now())
+	require.NoError(err)
+	require.Error(a.ConfirmTOTP("test@gmail.com", "12345"))
+	require.NoError(a.ConfirmTOTP("test@gmail.com", now))
+	_, _, err = a.EnrollTOTP("test@gmail.com", "Prosper Pool")
+	require.Error(err, "already on")
+
+	require.Error(a.CheckTwoFactor("test@gmail.com", ""), "code required")
+	require.Error(a.VerifyTOTP("test@gmail.com", now), "codes cannot be reused")
+	require.Error(a.DisableTOTP("test@gmail.com", now), "codes cannot be reused")
+
+	// The next code is accepted early, for clock drift
+	next, err := TOTPCode(secret, time.Now().Add(30*time.Second))
+	require.NoError(err)
+	require.NoError(a.CheckTwoFactor("test@gmail.com", next))
+
+	require.NoError(a.ResetTOTP("test@gmail.com", "cli"))
+	u, err := a.GetUser("test@gmail.com")
+	require.NoError(err)
+	require.False(u.TOTPEnabled)
+	require.Empty(u.TOTPSecret)
+
+	logs, err := a.AuditTrail("test@gmail.com", 10)
+	require.NoError(err)
+	require.Equal(AuditResetTOTP, logs[0].Action)
+}
@@ -0,0 +1,96 @@
+package authentication
+
+import (
+	crand "crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/Factom-Asset-Tokens/base58"
+)
+
+// WorkerToken is a password for mining only. Users make them so their mining
+// machines never hold the account password. A token can be bound to a single
+// minerid. Only the hash of the token is kept.
+type WorkerToken struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"created"`
+	UserID    string    `gorm:"index:worker_token_user" json:"userid"`
+	Name      string    `json:"name"`
+	// MinerID limits the token to a single worker. Any worker if empty.
+	MinerID   string     `gorm:"default:''" json:"minerid"`
+	TokenHash string     `gorm:"unique_index" json:"-"`
+	LastUsed  *time.Time `json:"lastused,omitempty"`
+	Revoked   bool       `gorm:"default:false" json:"revoked"`
+}
+
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("%x", h)
+}
+
+// NewWorkerToken makes a new token for the user. The token is only returned
+// here, it cannot be looked up later.
+func (a *Authenticator) NewWorkerToken(uid, name, minerid string) (string, *WorkerToken, error) {
+	if !a.Exists(uid) {
+		return "", nil, fmt.Errorf("user '%s' does not exist", uid)
+	}
+
+	data := make([]byte, 24)
+	if _, err := crand.Read(data); err != nil {
+		return "", nil, err
+	}
+	token := base58.Encode(data)
+
+	wt := &WorkerToken{
+		UserID:    uid,
+		Name:      name,
+		MinerID:   minerid,
+		TokenHash: hashToken(token),
+	}
+	if err := a.DB.Create(wt).Error; err != nil {
+		return "", nil, err
+	}
+	return token, wt, nil
+}
+
+// WorkerTokens returns the user's tokens
+func (a *Authenticator) WorkerTokens(uid string) ([]WorkerToken, error) {
+	var tokens []WorkerToken
+	err := a.DB.Where("user_id = ?", uid).Order("id desc").Find(&tokens).Error
+	return tokens, err
+}
+
+// RevokeWorkerToken stops the token from working. Users can only revoke
+// their own tokens.
+func (a *Authenticator) RevokeWorkerToken(uid string, id uint) error {
+	res := a.DB.Model(&WorkerToken{}).Where("id = ? AND user_id = ?", id, uid).Update("revoked", true)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("no worker token %d", id)
+	}
+	return nil
+}
+
+// CheckMinerCredential checks the password a miner sent to authorize. It can
+// be a worker token for the miner, or the account password.
+func (a *Authenticator) CheckMinerCredential(uid, minerid, secret string) error {
+	if secret == "" {
+		return fmt.Errorf("no password")
+	}
+
+	var wt WorkerToken
+	err := a.DB.Where("token_hash = ? AND user_id = ? AND revoked = ?", hashToken(secret), uid, false).First(&wt).Error
+	if err == nil {
+		if wt.MinerID != "" && wt.MinerID != minerid {
+			return fmt.Errorf("worker token is for '%s'", wt.MinerID)
+		}
+		now := time.Now()
+		a.DB.Model(&wt).Update("last_used", &now)
+		return nil
+	}
+
+	return a.CheckPassword(uid, secret)
+}
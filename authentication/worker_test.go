@@ -0,0 +1,43 @@
+package authentication_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticator_WorkerTokens(t *testing.T) {
+	require := require.New(t)
+	a := AuthForTests(t, true)
+	defer a.DB.Close()
+
+	RegisterUser(a, "test@gmail.com", "password")
+	RegisterUser(a, "other@gmail.com", "password")
+
+	_, _, err := a.NewWorkerToken("unknown@gmail.com", "rack", "")
+	require.Error(err)
+
+	any, _, err := a.NewWorkerToken("test@gmail.com", "rack", "")
+	require.NoError(err)
+	rig, info, err := a.NewWorkerToken("test@gmail.com", "rig", "rig01")
+	require.NoError(err)
+
+	// The account password always works
+	require.NoError(a.CheckMinerCredential("test@gmail.com", "rig05", "password"))
+	require.Error(a.CheckMinerCredential("test@gmail.com", "rig05", "wrong"))
+	require.Error(a.CheckMinerCredential("test@gmail.com", "rig05", ""))
+
+	require.NoError(a.CheckMinerCredential("test@gmail.com", "rig05", any))
+	require.NoError(a.CheckMinerCredential("test@gmail.com", "rig01", rig))
+	require.Error(a.CheckMinerCredential("test@gmail.com", "rig05", rig), "bound to rig01")
+	require.Error(a.CheckMinerCredential("other@gmail.com", "rig01", rig), "not their token")
+
+	tokens, err := a.WorkerTokens("test@gmail.com")
+	require.NoError(err)
+	require.Len(tokens, 2)
+	require.NotNil(tokens[0].LastUsed)
+
+	require.Error(a.RevokeWorkerToken("other@gmail.com", info.ID), "not their token")
+	require.NoError(a.RevokeWorkerToken("test@gmail.com", info.ID))
+	require.Error(a.CheckMinerCredential("test@gmail.com", "rig01", rig), "revoked")
+}
@@ -1,19 +1,24 @@
 package cmd
 
 import (
-	crand "crypto/rand"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/FactomWyomingEntity/prosper-pool/web"
 
 	"github.com/FactomWyomingEntity/prosper-pool/accounting"
 
-	"github.com/Factom-Asset-Tokens/base58"
 	"github.com/FactomWyomingEntity/prosper-pool/authentication"
 	"github.com/FactomWyomingEntity/prosper-pool/database"
+	"github.com/FactomWyomingEntity/prosper-pool/engine"
+	"github.com/FactomWyomingEntity/prosper-pool/events"
+	"github.com/FactomWyomingEntity/prosper-pool/notify"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -21,9 +26,24 @@ import (
 func init() {
 	db.AddCommand(makeAdmin)
 	db.AddCommand(makeCode)
+	db.AddCommand(listCodes)
+	db.AddCommand(revokeCode)
+	db.AddCommand(listUsers)
+	db.AddCommand(setRole)
+	db.AddCommand(setStatus)
+	db.AddCommand(setPayoutAddress)
+	db.AddCommand(resetTwoFactor)
+	db.AddCommand(auditTrail)
 	db.AddCommand(makePayments)
 	db.AddCommand(recordPayments)
 	rootCmd.AddCommand(db)
+
+	listCodes.Flags().Bool("all", false, "Include the claimed, revoked and expired codes")
+	makeCode.Flags().Int("uses", 1, "The number of users that can join with the code")
+	makeCode.Flags().Duration("expires", 0, "How long until the code expires, eg '72h'. 0 never expires")
+	makeCode.Flags().String("owner", "", "The user credited with the referrals")
+	makeCode.Flags().String("note", "", "A note about who the code is for")
+	auditTrail.Flags().Int("limit", 50, "The number of logs to show")
 }
 
 var db = &cobra.Command{
@@ -73,12 +93,34 @@ var recordPayments = &cobra.Command{
 			return err
 		}
 
+		// The recorded payouts are published, so the users can be told
+		bus := events.NewBus()
+		paid := bus.Subscribe("notify", len(payments), events.DropNewest, events.TopicPayoutRecorded)
+		a.SetBus(bus)
+
 		err = a.WritePayments(payments)
 		if err != nil {
 			return err
 		}
 
 		fmt.Println("Payment data recorded")
+
+		// The payments are recorded, so a failed notification is not an
+		// error.
+		notifier, err := notify.NewService(viper.GetViper(), db.DB)
+		if err != nil {
+			log.WithError(err).Warnf("payout notifications not sent")
+			return nil
+		}
+		for len(paid.Events()) > 0 {
+			n, ok := engine.Notification(<-paid.Events())
+			if !ok {
+				continue
+			}
+			if err := notifier.Deliver(n); err != nil {
+				log.WithError(err).WithField("user", n.UserID).Warnf("payout notification failed")
+			}
+		}
 		return nil
 	},
 }
@@ -138,52 +180,279 @@ var makePayments = &cobra.Command{
 	},
 }
 
+// CliAdmin is the admin recorded in the audit trail for cli changes
+const CliAdmin = "cli"
+
+// dbAuthenticator connects to the database for the user and code commands
+func dbAuthenticator() (*authentication.Authenticator, error) {
+	db, err := database.New(viper.GetViper())
+	if err != nil {
+		return nil, err
+	}
+
+	return authentication.NewAuthenticator(viper.GetViper(), db.DB)
+}
+
 var makeAdmin = &cobra.Command{
 	Use:     "admin",
 	Short:   "Makes the target user an admin",
 	Example: "prosper db admin <email>",
 	PreRun:  SoftReadConfig, // TODO: Do a hard read
 	Args:    cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		db, err := database.New(viper.GetViper())
+	RunE: func(cmd *cobra.Command, args []string) error {
+		a, err := dbAuthenticator()
+		if err != nil {
+			return err
+		}
+
+		if err := a.SetRole(args[0], authentication.RoleAdmin, CliAdmin); err != nil {
+			return err
+		}
+		fmt.Printf("%s is now an admin\n", args[0])
+		return nil
+	},
+}
+
+var setRole = &cobra.Command{
+	Use:     "role <email> <admin|user>",
+	Short:   "Promotes or demotes a user",
+	Example: "prosper db role user@gmail.com user",
+	PreRun:  SoftReadConfig,
+	Args:    cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		a, err := dbAuthenticator()
+		if err != nil {
+			return err
+		}
+
+		if err := a.SetRole(args[0], args[1], CliAdmin); err != nil {
+			return err
+		}
+		fmt.Printf("%s is now a(n) %s\n", args[0], strings.ToLower(args[1]))
+		return nil
+	},
+}
+
+var setStatus = &cobra.Command{
+	Use:     "status <email> <active|disabled|banned> [reason]",
+	Short:   "Enables, disables or bans a user",
+	Long:    "Disabled and banned users cannot mine or log in.",
+	Example: "prosper db status user@gmail.com banned \"share flooding\"",
+	PreRun:  SoftReadConfig,
+	Args:    cobra.RangeArgs(2, 3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		a, err := dbAuthenticator()
 		if err != nil {
-			panic(err)
+			return err
+		}
+
+		reason := ""
+		if len(args) == 3 {
+			reason = args[2]
+		}
+		if err := a.SetStatus(args[0], args[1], reason, CliAdmin); err != nil {
+			return err
+		}
+		fmt.Printf("%s is now %s\n", args[0], strings.ToLower(args[1]))
+		if !a.Active(args[0]) {
+			// The cli is not the pool, so it can not reach the connections
+			fmt.Println("Miners already connected stay on until they reconnect, use admin.SetStatus to drop them")
 		}
+		return nil
+	},
+}
 
-		dbErr := db.DB.Model(&authentication.User{}).Where("uid = ?", args[0]).Update("role", "admin")
-		if dbErr.Error != nil {
-			panic(dbErr.Error)
+var setPayoutAddress = &cobra.Command{
+	Use:     "payout-address <email> <FA address>",
+	Short:   "Changes a user's payout address",
+	Example: "prosper db payout-address user@gmail.com FA2jK2HcLnRdS94dEcU27rF3meoJfpUcZPSinpb7AwQvPRY6RL1Q",
+	PreRun:  SoftReadConfig,
+	Args:    cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		a, err := dbAuthenticator()
+		if err != nil {
+			return err
 		}
 
-		fmt.Printf("%d rows affected\n", dbErr.RowsAffected)
+		if err := a.SetPayoutAddress(args[0], args[1], CliAdmin); err != nil {
+			return err
+		}
+		fmt.Printf("%s is now paid to %s\n", args[0], args[1])
+		return nil
+	},
+}
+
+var resetTwoFactor = &cobra.Command{
+	Use:     "reset-2fa <email>",
+	Short:   "Turns off two factor for a user that lost their device",
+	Example: "prosper db reset-2fa user@gmail.com",
+	PreRun:  SoftReadConfig,
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		a, err := dbAuthenticator()
+		if err != nil {
+			return err
+		}
+
+		if err := a.ResetTOTP(args[0], CliAdmin); err != nil {
+			return err
+		}
+		fmt.Printf("two factor is off for %s\n", args[0])
+		return nil
+	},
+}
+
+var listUsers = &cobra.Command{
+	Use:     "users",
+	Short:   "Lists all the users",
+	Example: "prosper db users",
+	PreRun:  SoftReadConfig,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		a, err := dbAuthenticator()
+		if err != nil {
+			return err
+		}
+
+		users, err := a.ListUsers()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "UID\tRole\tStatus\tPayout Address\tInvite Code\t2FA")
+		for _, u := range users {
+			role, status := u.Role, u.Status
+			if role == authentication.RoleUser {
+				role = "user"
+			}
+			if status == authentication.StatusActive {
+				status = "active"
+			} else if u.StatusReason != "" {
+				status += " (" + u.StatusReason + ")"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\n", u.UID, role, status, u.PayoutAddress, u.InviteCode, u.TOTPEnabled)
+		}
+		return w.Flush()
+	},
+}
+
+var listCodes = &cobra.Command{
+	Use:     "codes",
+	Short:   "Lists the unclaimed invite codes",
+	Example: "prosper db codes --all",
+	PreRun:  SoftReadConfig,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		a, err := dbAuthenticator()
+		if err != nil {
+			return err
+		}
+
+		all, _ := cmd.Flags().GetBool("all")
+		codes, err := a.ListCodes(all)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "Code\tCreated\tExpires\tUses\tOwner\tState\tNote")
+		now := time.Now()
+		for _, c := range codes {
+			state := "unclaimed"
+			if c.Revoked {
+				state = "revoked"
+			} else if c.Claimed {
+				state = fmt.Sprintf("claimed, last by %s at %s", c.ClaimedBy, c.ClaimedTime.Format(time.RFC3339))
+			} else if !c.Redeemable(now) {
+				state = "expired"
+			}
+			expires := "never"
+			if c.ExpiresAt != nil {
+				expires = c.ExpiresAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d/%d\t%s\t%s\t%s\n", c.Code, c.CreatedAt.Format(time.RFC3339),
+				expires, c.Redemptions, c.MaxRedemptions, c.Owner, state, c.Note)
+		}
+		return w.Flush()
+	},
+}
+
+var revokeCode = &cobra.Command{
+	Use:     "revoke <code>",
+	Short:   "Revokes an unclaimed invite code",
+	Example: "prosper db revoke <code>",
+	PreRun:  SoftReadConfig,
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		a, err := dbAuthenticator()
+		if err != nil {
+			return err
+		}
+
+		if err := a.RevokeCode(args[0], CliAdmin); err != nil {
+			return err
+		}
+		fmt.Printf("Code %s revoked\n", args[0])
+		return nil
+	},
+}
+
+var auditTrail = &cobra.Command{
+	Use:     "audit [email|code]",
+	Short:   "Shows the audit trail of admin changes",
+	Example: "prosper db audit user@gmail.com",
+	PreRun:  SoftReadConfig,
+	Args:    cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		a, err := dbAuthenticator()
+		if err != nil {
+			return err
+		}
+
+		target := ""
+		if len(args) == 1 {
+			target = args[0]
+		}
+		limit, _ := cmd.Flags().GetInt("limit")
+		logs, err := a.AuditTrail(target, limit)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "Time\tAdmin\tAction\tTarget\tDetail")
+		for _, l := range logs {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", l.CreatedAt.Format(time.RFC3339), l.Admin, l.Action, l.Target, l.Detail)
+		}
+		return w.Flush()
 	},
 }
 
 var makeCode = &cobra.Command{
 	Use:     "code",
 	Short:   "Makes a new invite code",
-	Example: "prosper db code",
+	Example: "prosper db code --uses 5 --expires 168h --owner user@gmail.com --note \"meetup\"",
 	PreRun:  SoftReadConfig, // TODO: Do a hard read
-	Run: func(cmd *cobra.Command, args []string) {
-		db, err := database.New(viper.GetViper())
+	RunE: func(cmd *cobra.Command, args []string) error {
+		a, err := dbAuthenticator()
 		if err != nil {
-			panic(err)
+			return err
 		}
 
-		data := make([]byte, 20)
-		_, _ = crand.Read(data)
-		code := base58.Encode(data)
-
-		a, err := authentication.NewAuthenticator(viper.GetViper(), db.DB)
-		if err != nil {
-			panic(err)
+		var opts authentication.CodeOptions
+		opts.MaxRedemptions, _ = cmd.Flags().GetInt("uses")
+		opts.Owner, _ = cmd.Flags().GetString("owner")
+		opts.Note, _ = cmd.Flags().GetString("note")
+		if expires, _ := cmd.Flags().GetDuration("expires"); expires > 0 {
+			t := time.Now().Add(expires)
+			opts.ExpiresAt = &t
 		}
 
-		err = a.NewCode(code)
+		code, err := a.GenerateCode(CliAdmin, opts)
 		if err != nil {
-			fmt.Println("failed to make code")
+			return fmt.Errorf("failed to make code: %s", err.Error())
 		}
 
 		fmt.Printf("New Code: %s\n", code)
+		return nil
 	},
 }
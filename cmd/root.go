@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -41,6 +42,12 @@ func init() {
 	rootCmd.AddCommand(datasources)
 	rootCmd.AddCommand(datasourcesPull)
 
+	datasourcesPull.Flags().String("record", "", "Save all the datasource api responses into this directory")
+	datasourcesPull.Flags().String("replay", "", "Serve the datasource api responses from this directory, rather than the network")
+	datasources.Flags().Bool("health", false, "Show the health and quota usage of each configured datasource")
+	datasources.Flags().String("api", "", "Get the datasource health from a running pool's admin api, eg: http://localhost:7070/api/v1/admin")
+	datasources.Flags().String("cookie", "", "The session cookie of a logged in admin, for --api, eg: '_session=...'")
+
 	rootCmd.PersistentFlags().Bool("profile", false, "Turn on profiling")
 	rootCmd.PersistentFlags().String("config", "$HOME/.prosper/prosper-pool.toml", "Location to config")
 	rootCmd.PersistentFlags().String("log", "info", "Change the logging level. Can choose from 'trace', 'debug', 'info', 'warn', 'error', or 'fatal'")
@@ -382,6 +389,21 @@ var datasourcesPull = &cobra.Command{
 	Short:  "Runs through all configured datasources",
 	PreRun: SoftReadConfig,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		record, _ := cmd.Flags().GetString("record")
+		replay, _ := cmd.Flags().GetString("replay")
+		switch {
+		case record != "" && replay != "":
+			return fmt.Errorf("only one of --record or --replay can be used")
+		case record != "":
+			if err := polling.SetHTTPMode(polling.HTTPRecord, record); err != nil {
+				return err
+			}
+		case replay != "":
+			if err := polling.SetHTTPMode(polling.HTTPReplay, replay); err != nil {
+				return err
+			}
+		}
+
 		// Default to printing everything
 		d := polling.NewDataSources(viper.GetViper(), false)
 		all := d.PullAllSources()
@@ -408,6 +430,10 @@ var datasources = &cobra.Command{
 	PreRun:    SoftReadConfig,
 	ValidArgs: append(opr.V2Assets, polling.AllDataSourcesList()...),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if health, _ := cmd.Flags().GetBool("health"); health {
+			return datasourceHealth(cmd)
+		}
+
 		// User selected a data source or asset
 		if len(args) == 1 {
 			if AssetListContainsCaseInsensitive(opr.V2Assets, args[0]) {
@@ -462,6 +488,56 @@ var datasources = &cobra.Command{
 	},
 }
 
+// datasourceHealth prints the health of each datasource. Without an api to
+// ask, all the configured sources are pulled once to check them.
+func datasourceHealth(cmd *cobra.Command) error {
+	var health []polling.SourceHealth
+	if api, _ := cmd.Flags().GetString("api"); api != "" {
+		body := []byte(`{"jsonrpc": "2.0", "id": 0, "method": "admin.DataSourceHealth"}`)
+		req, err := http.NewRequest(http.MethodPost, api, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if cookie, _ := cmd.Flags().GetString("cookie"); cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		var reply struct {
+			Result []polling.SourceHealth `json:"result"`
+			Error  *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+			return err
+		}
+		if reply.Error != nil {
+			return fmt.Errorf("%s", reply.Error.Message)
+		}
+		health = reply.Result
+	} else {
+		d := polling.NewDataSources(viper.GetViper(), false)
+		d.PullAllSources()
+		health = d.Health()
+	}
+
+	fmt.Printf("%-20s %-10s %-8s %-8s %-16s %s\n", "Datasource", "State", "Calls", "Errors", "Quota", "Last Error")
+	for _, h := range health {
+		quota := "none"
+		if h.Quota > 0 {
+			quota = fmt.Sprintf("%d/%d %s", h.QuotaUsed, h.Quota, h.QuotaPeriod)
+		}
+		fmt.Printf("%-20s %-10s %-8d %-8d %-16s %s\n", h.Name, h.State, h.TotalCalls, h.TotalErrors, quota, h.LastError)
+	}
+	return nil
+}
+
 func setConfigLoc(cmd *cobra.Command, args []string) (string, bool) {
 	configPath, _ := cmd.Flags().GetString("config")
 	path := os.ExpandEnv(configPath)
@@ -10,7 +10,10 @@ import (
 const (
 	LoggingLevel = "app.loglevel"
 
-	ConfigPoolCut = "pool.PoolFeeRate"
+	ConfigPoolCut             = "pool.PoolFeeRate"
+	ConfigPoolDeductECCost    = "pool.DeductECCost"
+	ConfigPoolReferralBonus   = "pool.ReferralBonus"
+	ConfigPoolAddressCooldown = "pool.PayoutAddressCooldown"
 
 	ConfigSQLHost     = "Database.host"
 	ConfigSQLPort     = "Database.port"
@@ -27,8 +30,18 @@ const (
 	ConfigApiLayerKey          = "Oracle.ApiLayerKey"
 	ConfigCoinMarketCapKey     = "Oracle.CoinMarketCapKey"
 	ConfigOpenExchangeRatesKey = "Oracle.OpenExchangeRatesKey"
+	ConfigOraclePriceFile      = "Oracle.PriceFile"
 
-	ConfigPollingStaleDuration = "Polling.StaleDuration"
+	ConfigPollingStaleDuration  = "Polling.StaleDuration"
+	ConfigPollingSourceTimeout  = "Polling.SourceTimeout"
+	ConfigPollingMarketCalendar = "Polling.MarketCalendar"
+
+	ConfigPollingPricingMode        = "Polling.PricingMode"
+	ConfigPollingConsensusDeviation = "Polling.ConsensusDeviation"
+	ConfigPollingConsensusAverage   = "Polling.ConsensusAverage"
+
+	ConfigPollingBreakerThreshold = "Polling.BreakerThreshold"
+	ConfigPollingBreakerCooldown  = "Polling.BreakerCooldown"
 
 	Config1ForgePriority            = "OracleDataSources.1Forge"
 	ConfigAPILayerPriority          = "OracleDataSources.APILayer"
@@ -41,6 +54,9 @@ const (
 	ConfigFixedUSDPriority          = "OracleDataSources.FixedUSD"
 	ConfigAlternativeMePriority     = "OracleDataSources.AlternativeMe"
 
+	ConfigPriceGuardMaxChange = "PriceGuard.MaxChange"
+	ConfigPriceGuardAction    = "PriceGuard.Action"
+
 	ConfigPoolIdentity  = "Pool.OPRIdentity"
 	ConfigPoolCoinbase  = "Pool.OPRCoinbase"
 	ConfigPoolESAddress = "Pool.ESAddress"
@@ -49,12 +65,43 @@ const (
 	ConfigSubmitterEMAN    = "Submit.EMA-N"
 	ConfigSubmitterSoftMax = "Submit.SoftMax"
 
-	ConfigWebPort = "Web.Port"
-
-	ConfigStratumRequireAuth    = "Stratum.RequireAuth"
-	ConfigStratumPort           = "Stratum.StratumPort"
-	ConfigStratumWelcomeMessage = "Stratum.WelcomeMessage"
-	ConfigStratumCheckAllWork   = "Stratum.ValidateAllShares"
+	ConfigWebPort              = "Web.Port"
+	ConfigWebStatsCache        = "Web.StatsCache"
+	ConfigWebAPITokenRateLimit = "Web.APITokenRateLimit"
+	ConfigWebAdminTwoFactor    = "Web.AdminTwoFactor"
+	ConfigWebTwoFactorIssuer   = "Web.TwoFactorIssuer"
+
+	ConfigStratumRequireAuth        = "Stratum.RequireAuth"
+	ConfigStratumPort               = "Stratum.StratumPort"
+	ConfigStratumWebSocketPort      = "Stratum.WebSocketPort"
+	ConfigStratumWelcomeMessage     = "Stratum.WelcomeMessage"
+	ConfigStratumCheckAllWork       = "Stratum.ValidateAllShares"
+	ConfigStratumCheckPassword      = "Stratum.CheckPassword"
+	ConfigStratumWorkerOfflineAfter = "Stratum.WorkerOfflineAfter"
+	ConfigStratumAccountingBuffer   = "Stratum.AccountingBuffer"
+	ConfigStratumAccountingTimeout  = "Stratum.AccountingTimeout"
+	ConfigStratumTrustedProxies     = "Stratum.TrustedProxies"
+	ConfigStratumProxyHeaderTimeout = "Stratum.ProxyHeaderTimeout"
+
+	ConfigNotifyFrom                 = "Notify.From"
+	ConfigNotifySMTPHost             = "Notify.SMTPHost"
+	ConfigNotifySMTPPort             = "Notify.SMTPPort"
+	ConfigNotifySMTPUsername         = "Notify.SMTPUsername"
+	ConfigNotifySMTPPassword         = "Notify.SMTPPassword"
+	ConfigNotifyAdminWebhook         = "Notify.AdminWebhook"
+	ConfigNotifyAdminWebhookSecret   = "Notify.AdminWebhookSecret"
+	ConfigNotifyAllowPrivateWebhooks = "Notify.AllowPrivateWebhooks"
+	ConfigNotifyLowECBalance         = "Notify.LowECBalance"
+	ConfigNotifySyncStallAfter       = "Notify.SyncStallAfter"
+
+	ConfigAbuseMaxConnectionsPerIP   = "Abuse.MaxConnectionsPerIP"
+	ConfigAbuseMaxConnectionsPerUser = "Abuse.MaxConnectionsPerUser"
+	ConfigAbuseMaxMessagesPerMinute  = "Abuse.MaxMessagesPerMinute"
+	ConfigAbuseMaxBadMessages        = "Abuse.MaxBadMessages"
+	ConfigAbuseInvalidShareRatio     = "Abuse.InvalidShareRatio"
+	ConfigAbuseInvalidShareMinimum   = "Abuse.InvalidShareMinimum"
+	ConfigAbuseBanDuration           = "Abuse.BanDuration"
+	ConfigAbuseMaxBanDuration        = "Abuse.MaxBanDuration"
 )
 
 func SetDefaults(conf *viper.Viper) {
@@ -76,6 +123,15 @@ func SetDefaults(conf *viper.Viper) {
 	conf.SetDefault(ConfigOpenExchangeRatesKey, "CHANGEME")
 
 	conf.SetDefault(ConfigPollingStaleDuration, time.Minute*30)
+	conf.SetDefault(ConfigPollingSourceTimeout, time.Second*30)
+	conf.SetDefault(ConfigPollingPricingMode, "priority")
+	conf.SetDefault(ConfigPollingConsensusDeviation, 0.05)
+	conf.SetDefault(ConfigPollingConsensusAverage, "median")
+	conf.SetDefault(ConfigPollingBreakerThreshold, 5)
+	conf.SetDefault(ConfigPollingBreakerCooldown, time.Minute*10)
+
+	conf.SetDefault(ConfigPriceGuardMaxChange, 0.2)
+	conf.SetDefault(ConfigPriceGuardAction, "fallback")
 
 	conf.SetDefault(Config1ForgePriority, -1)
 	conf.SetDefault(ConfigAPILayerPriority, -1)
@@ -89,6 +145,9 @@ func SetDefaults(conf *viper.Viper) {
 	conf.SetDefault(ConfigAlternativeMePriority, -1)
 
 	conf.SetDefault(ConfigPoolCut, "0.05")
+	conf.SetDefault(ConfigPoolDeductECCost, false)
+	conf.SetDefault(ConfigPoolReferralBonus, "0")
+	conf.SetDefault(ConfigPoolAddressCooldown, time.Hour*48)
 
 	conf.SetDefault(ConfigPoolIdentity, "Prosper")
 	conf.SetDefault(ConfigPoolCoinbase, "FA2jK2HcLnRdS94dEcU27rF3meoJfpUcZPSinpb7AwQvPRY6RL1Q")
@@ -100,9 +159,40 @@ func SetDefaults(conf *viper.Viper) {
 	conf.SetDefault(ConfigSubmitterSoftMax, 25)
 
 	conf.SetDefault(ConfigWebPort, 7070)
+	conf.SetDefault(ConfigWebStatsCache, time.Second*30)
+	conf.SetDefault(ConfigWebAPITokenRateLimit, 60)
+	conf.SetDefault(ConfigWebAdminTwoFactor, true)
+	conf.SetDefault(ConfigWebTwoFactorIssuer, "Prosper Pool")
 
 	conf.SetDefault(ConfigStratumCheckAllWork, true)
 	conf.SetDefault(ConfigStratumRequireAuth, true)
+	conf.SetDefault(ConfigStratumCheckPassword, false)
+	conf.SetDefault(ConfigStratumWorkerOfflineAfter, time.Minute*10)
+	conf.SetDefault(ConfigStratumAccountingBuffer, 10000)
+	conf.SetDefault(ConfigStratumAccountingTimeout, time.Second*5)
+	conf.SetDefault(ConfigStratumTrustedProxies, []string{})
+	conf.SetDefault(ConfigStratumProxyHeaderTimeout, time.Second*5)
 	conf.SetDefault(ConfigStratumPort, 1234)
+	conf.SetDefault(ConfigStratumWebSocketPort, 0)
 	conf.SetDefault(ConfigStratumWelcomeMessage, "Welcome to Prosper pool! Please visit http://my.pool.url:port for more information.")
+
+	conf.SetDefault(ConfigNotifyFrom, "Prosper Pool <pool@localhost>")
+	conf.SetDefault(ConfigNotifySMTPHost, "")
+	conf.SetDefault(ConfigNotifySMTPPort, 587)
+	conf.SetDefault(ConfigNotifySMTPUsername, "")
+	conf.SetDefault(ConfigNotifySMTPPassword, "")
+	conf.SetDefault(ConfigNotifyAdminWebhook, "")
+	conf.SetDefault(ConfigNotifyAdminWebhookSecret, "")
+	conf.SetDefault(ConfigNotifyAllowPrivateWebhooks, false)
+	conf.SetDefault(ConfigNotifyLowECBalance, 1000)
+	conf.SetDefault(ConfigNotifySyncStallAfter, time.Minute*30)
+
+	conf.SetDefault(ConfigAbuseMaxConnectionsPerIP, 100)
+	conf.SetDefault(ConfigAbuseMaxConnectionsPerUser, 1000)
+	conf.SetDefault(ConfigAbuseMaxMessagesPerMinute, 600)
+	conf.SetDefault(ConfigAbuseMaxBadMessages, 20)
+	conf.SetDefault(ConfigAbuseInvalidShareRatio, 0.5)
+	conf.SetDefault(ConfigAbuseInvalidShareMinimum, 20)
+	conf.SetDefault(ConfigAbuseBanDuration, time.Minute*10)
+	conf.SetDefault(ConfigAbuseMaxBanDuration, time.Hour*24)
 }
@@ -40,6 +40,7 @@ func (s SqlDatabase) FullAutoMigrate() {
 	s.DB.AutoMigrate(&PegnetGrade{})
 	s.DB.AutoMigrate(&PegnetPayout{})
 	s.DB.AutoMigrate(&BlockSync{})
+	s.DB.AutoMigrate(&JobPrice{})
 
 	// Add unique constraint for height and position for payouts
 	s.Model(&PegnetPayout{}).AddUniqueIndex("uidx_payouts", "height", "position")
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"math"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// JobPrice is the price of an asset that the pool put into the opr for a
+// job. Once the block for the job is graded, the price is compared against
+// the average of the winning oprs.
+type JobPrice struct {
+	JobID int32  `gorm:"primary_key;auto_increment:false" json:"jobid"`
+	Asset string `gorm:"primary_key" json:"asset"`
+
+	// Value is the price in the opr, which is the price * 1e8
+	Value int64 `json:"value"`
+	// Sources is the comma separated list of data sources the price came from
+	Sources string `json:"sources"`
+
+	// Graded is true once the winners for the job are known
+	Graded bool `json:"graded"`
+	// Winning is the average price of the winning oprs * 1e8
+	Winning int64 `json:"winning"`
+	// Deviation is how far our price is from the winning price as a ratio.
+	// 0.01 is 1% above, -0.01 is 1% below.
+	Deviation float64 `json:"deviation"`
+
+	CreatedAt time.Time `json:"created"`
+}
+
+// AssetDeviation is the summary of how far our prices for an asset have been
+// from the winning prices.
+type AssetDeviation struct {
+	Asset string `json:"asset"`
+	Jobs  int    `json:"jobs"`
+	// Mean is the average deviation, so a source that is always high or low
+	// will show here.
+	Mean float64 `json:"mean"`
+	// MeanAbs and MaxAbs are the average and largest distance from the
+	// winning price in either direction.
+	MeanAbs float64 `json:"meanabs"`
+	MaxAbs  float64 `json:"maxabs"`
+	// Last is the deviation for the most recent graded job
+	Last float64 `json:"last"`
+}
+
+// GradeJobPrices compares the prices we submitted for a job with the prices
+// in the winning oprs. The winning prices are by asset, * 1e8.
+func GradeJobPrices(db *gorm.DB, jobID int32, winning map[string]float64) error {
+	var prices []JobPrice
+	err := db.Where("job_id = ?", jobID).Find(&prices).Error
+	if err != nil {
+		return err
+	}
+
+	for _, p := range prices {
+		w, ok := winning[p.Asset]
+		if !ok {
+			continue
+		}
+
+		p.Graded = true
+		p.Winning = int64(math.Round(w))
+		if w != 0 {
+			p.Deviation = (float64(p.Value) - w) / w
+		}
+
+		err := db.Model(&p).Updates(map[string]interface{}{
+			"graded":    p.Graded,
+			"winning":   p.Winning,
+			"deviation": p.Deviation,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AssetDeviations summarizes the deviation for each asset over the last
+// number of graded jobs.
+func AssetDeviations(db *gorm.DB, jobs int) ([]AssetDeviation, error) {
+	var latest JobPrice
+	err := db.Where("graded = ?", true).Order("job_id desc").First(&latest).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var prices []JobPrice
+	err = db.Where("graded = ? AND job_id > ?", true, latest.JobID-int32(jobs)).
+		Order("job_id desc, asset").Find(&prices).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var deviations []AssetDeviation
+	index := make(map[string]int)
+	for _, p := range prices {
+		i, ok := index[p.Asset]
+		if !ok {
+			// Prices are newest first, so the first seen is the last
+			i = len(deviations)
+			index[p.Asset] = i
+			deviations = append(deviations, AssetDeviation{Asset: p.Asset, Last: p.Deviation})
+		}
+
+		d := &deviations[i]
+		d.Jobs++
+		d.Mean += p.Deviation
+		d.MeanAbs += math.Abs(p.Deviation)
+		d.MaxAbs = math.Max(d.MaxAbs, math.Abs(p.Deviation))
+	}
+
+	for i := range deviations {
+		deviations[i].Mean /= float64(deviations[i].Jobs)
+		deviations[i].MeanAbs /= float64(deviations[i].Jobs)
+	}
+	return deviations, nil
+}
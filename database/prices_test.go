@@ -0,0 +1,102 @@
+package database_test
+
+import (
+	"testing"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/database"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/stretchr/testify/require"
+)
+
+func pricesForTests(t *testing.T) *gorm.DB {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.AutoMigrate(&JobPrice{})
+	return db
+}
+
+func saveJobPrices(t *testing.T, db *gorm.DB, jobID int32, prices map[string]int64) {
+	for asset, value := range prices {
+		p := JobPrice{JobID: jobID, Asset: asset, Value: value, Sources: "UnitTest"}
+		require.NoError(t, db.Save(&p).Error)
+	}
+}
+
+func jobPrice(t *testing.T, db *gorm.DB, jobID int32, asset string) JobPrice {
+	var p JobPrice
+	require.NoError(t, db.Where("job_id = ? AND asset = ?", jobID, asset).First(&p).Error)
+	return p
+}
+
+func TestGradeJobPrices(t *testing.T) {
+	require := require.New(t)
+	db := pricesForTests(t)
+	defer db.Close()
+
+	saveJobPrices(t, db, 1, map[string]int64{"PEG": 102e8, "XBT": 99e8, "USD": 1e8})
+	// The winners do not have USD
+	require.NoError(GradeJobPrices(db, 1, map[string]float64{"PEG": 100e8, "XBT": 100e8}))
+
+	peg := jobPrice(t, db, 1, "PEG")
+	require.True(peg.Graded)
+	require.Equal(int64(100e8), peg.Winning)
+	require.InDelta(0.02, peg.Deviation, 1e-9)
+
+	xbt := jobPrice(t, db, 1, "XBT")
+	require.True(xbt.Graded)
+	require.InDelta(-0.01, xbt.Deviation, 1e-9)
+
+	usd := jobPrice(t, db, 1, "USD")
+	require.False(usd.Graded)
+	require.Zero(usd.Deviation)
+}
+
+func TestGradeJobPrices_NoWinners(t *testing.T) {
+	require := require.New(t)
+	db := pricesForTests(t)
+	defer db.Close()
+
+	saveJobPrices(t, db, 1, map[string]int64{"PEG": 102e8})
+	require.NoError(GradeJobPrices(db, 1, map[string]float64{}))
+	require.False(jobPrice(t, db, 1, "PEG").Graded)
+
+	// Nothing graded has nothing to summarize
+	deviations, err := AssetDeviations(db, 10)
+	require.NoError(err)
+	require.Empty(deviations)
+
+	// A job we have no prices for grades nothing
+	require.NoError(GradeJobPrices(db, 2, map[string]float64{"PEG": 100e8}))
+}
+
+func TestAssetDeviations(t *testing.T) {
+	require := require.New(t)
+	db := pricesForTests(t)
+	defer db.Close()
+
+	winning := map[string]float64{"PEG": 100e8}
+	for job, value := range []int64{110e8, 98e8, 101e8} {
+		saveJobPrices(t, db, int32(job+1), map[string]int64{"PEG": value})
+		require.NoError(GradeJobPrices(db, int32(job+1), winning))
+	}
+	// An ungraded job is not counted
+	saveJobPrices(t, db, 4, map[string]int64{"PEG": 150e8})
+
+	deviations, err := AssetDeviations(db, 10)
+	require.NoError(err)
+	require.Len(deviations, 1)
+	d := deviations[0]
+	require.Equal("PEG", d.Asset)
+	require.Equal(3, d.Jobs)
+	require.InDelta(0.03, d.Mean, 1e-9)
+	require.InDelta(13.0/300, d.MeanAbs, 1e-9)
+	require.InDelta(0.1, d.MaxAbs, 1e-9)
+	require.InDelta(0.01, d.Last, 1e-9)
+
+	// Only the last 2 graded jobs
+	deviations, err = AssetDeviations(db, 2)
+	require.NoError(err)
+	require.Equal(2, deviations[0].Jobs)
+	require.InDelta(-0.005, deviations[0].Mean, 1e-9)
+}
@@ -7,24 +7,30 @@ import (
 	"fmt"
 	"math"
 	"regexp"
+	"strings"
+	"time"
 
 	"github.com/pegnet/pegnet/modules/grader"
 
 	"github.com/pegnet/pegnet/modules/factoidaddress"
 
 	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/FactomWyomingEntity/prosper-pool/abuse"
 	"github.com/FactomWyomingEntity/prosper-pool/accounting"
 	"github.com/FactomWyomingEntity/prosper-pool/authentication"
 	"github.com/FactomWyomingEntity/prosper-pool/config"
 	"github.com/FactomWyomingEntity/prosper-pool/database"
+	"github.com/FactomWyomingEntity/prosper-pool/events"
 	"github.com/FactomWyomingEntity/prosper-pool/exit"
 	"github.com/FactomWyomingEntity/prosper-pool/factomclient"
 	"github.com/FactomWyomingEntity/prosper-pool/minutekeeper"
+	"github.com/FactomWyomingEntity/prosper-pool/notify"
 	"github.com/FactomWyomingEntity/prosper-pool/pegnet"
 	"github.com/FactomWyomingEntity/prosper-pool/polling"
 	"github.com/FactomWyomingEntity/prosper-pool/sharesubmit"
 	"github.com/FactomWyomingEntity/prosper-pool/stratum"
 	"github.com/FactomWyomingEntity/prosper-pool/web"
+	"github.com/FactomWyomingEntity/prosper-pool/workers"
 	"github.com/pegnet/pegnet/modules/opr"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -46,12 +52,28 @@ type PoolEngine struct {
 	Authenticator *authentication.Authenticator
 	Web           *web.HttpServices
 	MinuteKeeper  *minutekeeper.MinuteKeeper
+	PriceGuard    *PriceGuard
+	Workers       *workers.Registry
+	Notifier      *notify.Service
+	AbuseGuard    *abuse.Guard
+
+	// Bus passes the events between the modules
+	Bus *events.Bus
 
 	Identity IdentityInformation
 
 	// Engine hooks
 	// nodeHook listens for new pegnet blocks
 	nodeHook <-chan pegnet.PegnetdHook
+
+	// lastJob is the job we created for the previous block. The rewards for
+	// a block are for the job made before it.
+	lastJob *stratum.Job
+
+	// Subscriptions of the engine's own goroutines
+	notifySub  *events.Subscription
+	blocksSub  *events.Subscription
+	metricsSub *events.Subscription
 }
 
 // IdentityInformation contains all the info needed to make OPRs
@@ -126,8 +148,28 @@ func (e *PoolEngine) init() error {
 
 	srv := web.NewHttpServices(e.conf, db.DB)
 
+	reg, err := workers.NewRegistry(e.conf, db.DB)
+	if err != nil {
+		return err
+	}
+
+	notifier, err := notify.NewService(e.conf, db.DB)
+	if err != nil {
+		return err
+	}
+
+	abuseGuard, err := abuse.NewGuard(e.conf, db.DB)
+	if err != nil {
+		return err
+	}
+
 	mk := minutekeeper.NewMinuteKeeper(factomclient.FactomClientFromConfig(e.conf))
 
+	guard, err := NewPriceGuard(e.conf)
+	if err != nil {
+		return err
+	}
+
 	// Load our identity info for oprs
 	if id := e.conf.GetString(config.ConfigPoolIdentity); id == "" {
 		return fmt.Errorf("opr identity must be set")
@@ -165,6 +207,11 @@ func (e *PoolEngine) init() error {
 	e.Authenticator = auth
 	e.Web = srv
 	e.MinuteKeeper = mk
+	e.PriceGuard = guard
+	e.Workers = reg
+	e.Notifier = notifier
+	e.AbuseGuard = abuseGuard
+	e.Bus = events.NewBus()
 
 	// Add all closes
 	exit.GlobalExitHandler.AddExit(e.Database.Close)
@@ -177,19 +224,39 @@ func (e *PoolEngine) link() error {
 	e.nodeHook = e.PegnetNode.GetHook()
 
 	// Submissions is all stratum miner submissions
-	//	One for accounting
-	acctSubmissions := e.StratumServer.GetSubmissionExport()
+	//	One for accounting, which never drops a share
+	acctSubmissions := e.StratumServer.GetAccountingExport()
 	e.Accountant.SetSubmissions(acctSubmissions)
-	//	One for factom submit
-	subSubmissions := e.StratumServer.GetSubmissionExport()
+	//	One for factom submit, which can miss a share when it is busy
+	subSubmissions := e.StratumServer.GetSubmissionExport("submit")
 	e.Submitter.SetSubmissions(subSubmissions)
 
+	// Everything else listens on the bus. Subscribe before anything is
+	// published.
+	e.StratumServer.SetBus(e.Bus)
+	e.Accountant.SetBus(e.Bus)
+	e.Submitter.SetBus(e.Bus)
+	e.Workers.SetBus(e.Bus)
+	e.Web.SetBus(e.Bus)
+	e.notifySub = e.Bus.Subscribe("notify", 1000, events.DropNewest,
+		events.TopicWorkerStatus, events.TopicRewardCredited, events.TopicPayoutRecorded)
+	e.blocksSub = e.Bus.Subscribe("watch", 1, events.DropOldest, events.TopicBlockGraded)
+	e.metricsSub = e.Bus.Subscribe("metrics", 1000, events.DropNewest,
+		events.TopicShareAccepted, events.TopicShareRejected, events.TopicEntrySubmitted,
+		events.TopicMinerConnected, events.TopicMinerDisconnected)
+
 	e.Web.InitPrimary(e.Authenticator)
 	e.Web.SetStratumServer(e.StratumServer)
 	e.Web.SetMinuteKeeper(e.MinuteKeeper)
+	e.Web.SetPoller(e.Poller)
+	e.Web.SetAccountant(e.Accountant)
+	e.Web.SetWorkers(e.Workers)
+	e.Web.SetNotifier(e.Notifier)
+	e.Web.SetGuard(e.AbuseGuard)
 
 	e.StratumServer.SetAuthenticator(e.Authenticator)
 	e.StratumServer.SetShareCheck(e.MinuteKeeper)
+	e.StratumServer.SetGuard(e.AbuseGuard)
 
 	return nil
 }
@@ -201,6 +268,7 @@ func (e *PoolEngine) Run(ctx context.Context) {
 
 	// Stratum server listens to new jobs - spits out new shares
 	go e.StratumServer.Listen(ctx)
+	go e.StratumServer.ListenWebSocket(ctx)
 
 	// Accountant listens to new jobs, new rewards, and new shares
 	go e.Accountant.Listen(ctx)
@@ -211,6 +279,16 @@ func (e *PoolEngine) Run(ctx context.Context) {
 	// Submitter takes new blocks, new shares, and new jobs
 	go e.Submitter.Run(ctx)
 
+	// Worker registry records the workers coming and going, and their shares
+	go e.Workers.Run(ctx)
+
+	// Notifier emails and posts the events, and watches the pool's health
+	go e.Notifier.Run(ctx)
+	go e.notifyUsers(ctx, e.notifySub)
+	go e.watchPool(ctx, e.blocksSub)
+
+	go countEvents(ctx, e.metricsSub)
+
 	// Start api/web
 	go e.Web.Listen()
 
@@ -222,9 +300,27 @@ func (e *PoolEngine) listenBlocks(ctx context.Context) {
 	for {
 		select {
 		case hook := <-e.nodeHook:
+			rewards := e.findRewards(hook)
+			e.Bus.Publish(events.BlockGraded{
+				Height:     hook.Height,
+				Top:        hook.Top,
+				GradedSize: rewards.GradedSize,
+				Graded:     rewards.Graded,
+				Winning:    rewards.Winning,
+				PoolReward: rewards.PoolReward,
+				Time:       time.Now(),
+			})
+
+			// Compare the prices we used for the job to the winners. The
+			// winning prices are also used to check the next job.
+			e.gradePrices(hook)
+
 			job := e.createJob(hook)
 			if job == nil {
-				// This is a problem. createJob() will log the error
+				// This is a problem. createJob() will log the error.
+				// The price guard can also hold back a job. The rewards for
+				// the block are still ours.
+				e.Accountant.RewardChannel() <- rewards
 				continue
 			}
 
@@ -232,16 +328,17 @@ func (e *PoolEngine) listenBlocks(ctx context.Context) {
 			// The hook.Top let's processes know if this is the latest block
 			// or we are just syncing
 			if hook.Top {
-				// Update current job and notify the Miners
-				e.StratumServer.UpdateCurrentJob(job)
 				// Notify Accounting
-				//	Notify of the new job
+				//	Notify of the new job, before any of its shares
 				e.Accountant.JobChannel() <- job.JobID
+				// Update current job and notify the Miners
+				e.StratumServer.UpdateCurrentJob(job)
+				e.Bus.Publish(events.NewJob{JobID: job.JobID, OPRHash: job.OPRHash, Time: time.Now()})
 			}
 
 			// Rewards are always processed, even if they are not new.
 			//	Notify of the rewards
-			e.Accountant.RewardChannel() <- e.findRewards(hook)
+			e.Accountant.RewardChannel() <- rewards
 
 			// Notify Submissions
 			//	Submissions needs the new job to know what shares are valid
@@ -249,6 +346,7 @@ func (e *PoolEngine) listenBlocks(ctx context.Context) {
 				Block: hook,
 				Job:   job,
 			}
+			e.lastJob = job
 		case <-ctx.Done():
 			return
 		}
@@ -261,7 +359,15 @@ func (e *PoolEngine) findRewards(hook pegnet.PegnetdHook) *accounting.Reward {
 		JobID: stratum.JobIDFromHeight(hook.Height),
 	}
 
-	for _, graded := range hook.GradedBlock.Graded() {
+	// PEG is always the first asset. The price lets the accountant put the
+	// ec cost of the job in PEG.
+	if e.lastJob != nil && e.lastJob.JobID == r.JobID && len(e.lastJob.OPR.Assets) > 0 {
+		r.PEGPrice = float64(e.lastJob.OPR.Assets[0]) / 1e8
+	}
+
+	set := hook.GradedBlock.Graded()
+	r.GradedSize = len(set)
+	for _, graded := range set {
 		// Match on either. If someone mines with a new identity, but for us
 		// we will take it?
 		if graded.OPR.GetID() == e.Identity.Identity ||
@@ -276,6 +382,48 @@ func (e *PoolEngine) findRewards(hook pegnet.PegnetdHook) *accounting.Reward {
 	return &r
 }
 
+// gradePrices compares the prices in our opr for the graded block to the
+// average of the winning oprs.
+func (e *PoolEngine) gradePrices(hook pegnet.PegnetdHook) {
+	winners := hook.GradedBlock.Winners()
+	if len(winners) == 0 {
+		return
+	}
+
+	winning := make(map[string]float64)
+	for _, w := range winners {
+		for _, asset := range w.OPR.GetOrderedAssetsUint() {
+			winning[asset.Name] += float64(asset.Value)
+		}
+	}
+	for asset := range winning {
+		winning[asset] /= float64(len(winners))
+	}
+
+	e.PriceGuard.SetWinning(winning)
+
+	err := database.GradeJobPrices(e.Database.DB, stratum.JobIDFromHeight(hook.Height), winning)
+	if err != nil {
+		engLog.WithError(err).WithField("height", hook.Height).Errorf("failed to grade job prices")
+	}
+}
+
+// savePrices keeps the prices used in the opr for the job, and where they
+// came from.
+func (e *PoolEngine) savePrices(jobID int32, assetList []string, record opr.V2Content, assets polling.PegAssets) {
+	for i, name := range assetList {
+		price := database.JobPrice{
+			JobID:   jobID,
+			Asset:   name,
+			Value:   int64(record.Assets[i]),
+			Sources: strings.Join(assets[name].Sources, ","),
+		}
+		if err := e.Database.Save(&price).Error; err != nil {
+			engLog.WithError(err).WithFields(log.Fields{"job": jobID, "asset": name}).Errorf("failed to save job price")
+		}
+	}
+}
+
 // createJob returns the job to send to the stratum miners.
 func (e *PoolEngine) createJob(hook pegnet.PegnetdHook) *stratum.Job {
 	hLog := engLog.WithFields(log.Fields{"height": hook.Height})
@@ -341,6 +489,34 @@ func (e *PoolEngine) createJob(hook pegnet.PegnetdHook) *stratum.Job {
 		record.Assets[i] = uint64(math.Round(asset.Value * 1e8))
 	}
 
+	// Catch any prices that jumped too far
+	if held := e.PriceGuard.Check(assetList, record.Assets); len(held) > 0 {
+		for _, h := range held {
+			priceGuardHeld.WithLabelValues(h.Asset).Inc()
+			hLog.WithFields(log.Fields{
+				"asset":    h.Asset,
+				"price":    float64(h.Price) / 1e8,
+				"previous": float64(h.Fallback) / 1e8,
+				"change":   h.Change,
+				"sources":  strings.Join(assets[h.Asset].Sources, ","),
+				"action":   e.PriceGuard.Action,
+			}).Errorf("PRICE GUARD: asset price moved too far, check your datasources!")
+		}
+
+		if e.PriceGuard.Action == GuardReject {
+			hLog.WithField("held", len(held)).Errorf("PRICE GUARD: job not published, miners will stay on the last job")
+			return nil
+		}
+
+		for _, h := range held {
+			i := polling.FindIndexInStringArray(assetList, h.Asset)
+			record.Assets[i] = h.Fallback
+			price := assets[h.Asset]
+			price.Sources = []string{"held"}
+			assets[h.Asset] = price
+		}
+	}
+
 	// Get OPRHash
 	data, err := record.Marshal()
 	if version == 4 {
@@ -380,8 +556,12 @@ func (e *PoolEngine) createJob(hook pegnet.PegnetdHook) *stratum.Job {
 
 	// The job is for the height + 1. The synced block is wrapping up the last
 	// job
+	jobID := stratum.JobIDFromHeight(hook.Height + 1)
+	e.savePrices(jobID, assetList, record, assets)
+	e.PriceGuard.Accept(assetList, record.Assets)
+
 	return &stratum.Job{
-		JobID:   stratum.JobIDFromHeight(hook.Height + 1),
+		JobID:   jobID,
 		OPRHash: oprHashHex,
 		OPR:     record,
 		OPRv4:   opr.V4Content{record},
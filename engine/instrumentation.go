@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"context"
+	"sync"
+
+	"github.com/FactomWyomingEntity/prosper-pool/abuse"
+	"github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/events"
+	"github.com/FactomWyomingEntity/prosper-pool/stratum"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	priceGuardHeld = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pool_engine_price_guard_held_total",
+		Help: "Asset prices held back by the price guard",
+	}, []string{"asset"})
+	sharesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pool_engine_shares_total",
+		Help: "Shares from the miners, by if they were accepted, and why not",
+	}, []string{"result", "reason"})
+	entriesSubmitted = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pool_engine_entries_submitted_total",
+		Help: "Opr entries submitted to factomd",
+	})
+	entryCredits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pool_engine_entry_credits_spent_total",
+		Help: "Entry credits spent on opr entries",
+	})
+	minersConnected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pool_engine_miners_connected",
+		Help: "Authorized miner connections",
+	})
+)
+
+var prom sync.Once
+
+func RegisterPrometheus() {
+	prom.Do(func() {
+		prometheus.MustRegister(priceGuardHeld)
+		prometheus.MustRegister(sharesTotal)
+		prometheus.MustRegister(entriesSubmitted)
+		prometheus.MustRegister(entryCredits)
+		prometheus.MustRegister(minersConnected)
+		events.RegisterPrometheus()
+		stratum.RegisterPrometheus()
+		accounting.RegisterPrometheus()
+		abuse.RegisterPrometheus()
+	})
+}
+
+// countEvents keeps the metrics from the bus
+func countEvents(ctx context.Context, sub *events.Subscription) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-sub.Events():
+			switch ev := ev.(type) {
+			case events.ShareAccepted:
+				sharesTotal.WithLabelValues("accepted", "").Inc()
+			case events.ShareRejected:
+				sharesTotal.WithLabelValues("rejected", ev.Reason).Inc()
+			case events.EntrySubmitted:
+				entriesSubmitted.Inc()
+				entryCredits.Add(float64(ev.ECCost))
+			case events.MinerConnected:
+				minersConnected.Inc()
+			case events.MinerDisconnected:
+				minersConnected.Dec()
+			}
+		}
+	}
+}
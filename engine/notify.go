@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	"github.com/FactomWyomingEntity/prosper-pool/events"
+	"github.com/FactomWyomingEntity/prosper-pool/notify"
+	"github.com/FactomWyomingEntity/prosper-pool/web"
+)
+
+// ecBalanceInterval is how often the entry credit balance is checked
+const ecBalanceInterval = 10 * time.Minute
+
+// Notification returns the notification for a bus event, if the users should
+// hear about it
+func Notification(ev events.Event) (notify.Event, bool) {
+	switch ev := ev.(type) {
+	case events.WorkerStatus:
+		n := notify.Event{
+			Type:    notify.EventWorkerOffline,
+			UserID:  ev.UserID,
+			Subject: fmt.Sprintf("Worker %s is offline", ev.MinerID),
+			Message: fmt.Sprintf("Your worker %s has not sent a share since %s.", ev.MinerID, ev.LastSeen.UTC().Format(time.RFC1123)),
+			Data: map[string]interface{}{
+				"minerid":  ev.MinerID,
+				"lastseen": ev.LastSeen,
+			},
+		}
+		if ev.Online {
+			n.Type = notify.EventWorkerOnline
+			n.Subject = fmt.Sprintf("Worker %s is back online", ev.MinerID)
+			n.Message = fmt.Sprintf("Your worker %s is mining again.", ev.MinerID)
+		}
+		return n, true
+	case events.RewardCredited:
+		if ev.Payout <= 0 {
+			return notify.Event{}, false
+		}
+		amount := web.FactoshiToFactoid(uint64(ev.Payout))
+		return notify.Event{
+			Type:    notify.EventReward,
+			UserID:  ev.UserID,
+			Time:    ev.Time,
+			Subject: fmt.Sprintf("%s PEG credited for block %d", amount, ev.JobID),
+			Message: fmt.Sprintf("You were credited %s PEG for your shares in block %d.", amount, ev.JobID),
+			Data: map[string]interface{}{
+				"jobid":  ev.JobID,
+				"payout": ev.Payout,
+			},
+		}, true
+	case events.PayoutRecorded:
+		if ev.Amount <= 0 {
+			return notify.Event{}, false
+		}
+		amount := web.FactoshiToFactoid(uint64(ev.Amount))
+		return notify.Event{
+			Type:    notify.EventPayoutSent,
+			UserID:  ev.UserID,
+			Time:    ev.Time,
+			Subject: fmt.Sprintf("%s PEG paid out", amount),
+			Message: fmt.Sprintf("%s PEG was paid to %s in entry %s.", amount, ev.PayoutAddress, ev.EntryHash),
+			Data: map[string]interface{}{
+				"amount":    ev.Amount,
+				"address":   ev.PayoutAddress,
+				"entryhash": ev.EntryHash,
+			},
+		}, true
+	}
+	return notify.Event{}, false
+}
+
+// notifyUsers passes the users' events from the bus to the notifier
+func (e *PoolEngine) notifyUsers(ctx context.Context, sub *events.Subscription) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-sub.Events():
+			if n, ok := Notification(ev); ok {
+				e.Notifier.Publish(n)
+			}
+		}
+	}
+}
+
+// watchPool tells the admins when the pool stops syncing blocks, or runs low
+// on entry credits. Each is sent once, until it is fixed.
+func (e *PoolEngine) watchPool(ctx context.Context, blocks *events.Subscription) {
+	stallAfter := e.conf.GetDuration(config.ConfigNotifySyncStallAfter)
+	lowBalance := uint64(e.conf.GetInt64(config.ConfigNotifyLowECBalance))
+
+	var stalled, low bool
+	var lastBalance time.Time
+	lastBlock := time.Now()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-blocks.Events():
+			lastBlock = time.Now()
+			stalled = false
+		case now := <-ticker.C:
+			if stallAfter > 0 && now.Sub(lastBlock) > stallAfter && !stalled {
+				stalled = true
+				e.Notifier.Publish(notify.Event{
+					Type:    notify.EventSyncStalled,
+					Subject: "Pool sync stalled",
+					Message: fmt.Sprintf("The pool has not synced a block since %s. Check the factomd node.", lastBlock.UTC().Format(time.RFC1123)),
+					Data:    map[string]interface{}{"lastblock": lastBlock},
+				})
+			}
+
+			if lowBalance == 0 || now.Sub(lastBalance) < ecBalanceInterval {
+				continue
+			}
+			lastBalance = now
+			balance, err := e.Identity.ESAddress.GetBalance(ctx, e.PegnetNode.FactomClient)
+			if err != nil {
+				engLog.WithError(err).Warnf("failed to get entry credit balance")
+				continue
+			}
+			switch {
+			case balance < lowBalance && !low:
+				low = true
+				e.Notifier.Publish(notify.Event{
+					Type:    notify.EventLowECBalance,
+					Subject: "Pool entry credits are low",
+					Message: fmt.Sprintf("The pool's entry credit address %s has %d entry credits left.", e.Identity.ESAddress.ECAddress(), balance),
+					Data:    map[string]interface{}{"balance": balance},
+				})
+			case balance >= lowBalance:
+				low = false
+			}
+		}
+	}
+}
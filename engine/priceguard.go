@@ -0,0 +1,125 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	"github.com/spf13/viper"
+)
+
+// Price guard actions
+const (
+	// GuardFallback uses the previous job's price for any asset that moved
+	// too far.
+	GuardFallback = "fallback"
+	// GuardReject does not publish the job at all. Miners keep working on
+	// the last job.
+	GuardReject = "reject"
+)
+
+// PriceGuard catches asset prices that jump too far from the last prices we
+// trust. A single datasource glitch should not get all of the pool's records
+// graded out. A price is fine if it is close to either our last published
+// job, or the last winning prices on chain. Checking the winning prices as
+// well means a real market move is accepted once the network agrees with it.
+type PriceGuard struct {
+	// MaxChange is the largest move allowed as a ratio. 0.2 is 20%.
+	// 0 disables the guard.
+	MaxChange float64
+	// AssetMaxChange overrides the MaxChange for specific assets
+	AssetMaxChange map[string]float64
+	Action         string
+
+	lastJob     map[string]uint64
+	lastWinning map[string]float64
+}
+
+// HeldPrice is a price that moved too far
+type HeldPrice struct {
+	Asset    string
+	Price    uint64
+	Fallback uint64
+	// Change is the smallest move against the references
+	Change float64
+}
+
+func (h HeldPrice) String() string {
+	return fmt.Sprintf("%s moved %.2f%%, %.8f -> %.8f", h.Asset, h.Change*100,
+		float64(h.Fallback)/1e8, float64(h.Price)/1e8)
+}
+
+func NewPriceGuard(conf *viper.Viper) (*PriceGuard, error) {
+	g := new(PriceGuard)
+	g.MaxChange = conf.GetFloat64(config.ConfigPriceGuardMaxChange)
+	g.Action = strings.ToLower(conf.GetString(config.ConfigPriceGuardAction))
+	if g.Action != GuardFallback && g.Action != GuardReject {
+		return nil, fmt.Errorf("price guard action must be '%s' or '%s', found '%s'", GuardFallback, GuardReject, g.Action)
+	}
+
+	g.AssetMaxChange = make(map[string]float64)
+	for asset := range conf.GetStringMap("priceguardassets") {
+		g.AssetMaxChange[strings.ToUpper(asset)] = conf.GetFloat64("priceguardassets." + asset)
+	}
+	return g, nil
+}
+
+// Check returns the assets that moved too far, or have no price. The prices
+// are in the same order as the asset list.
+func (g *PriceGuard) Check(assetList []string, prices []uint64) []HeldPrice {
+	var held []HeldPrice
+	for i, asset := range assetList {
+		max := g.MaxChange
+		if m, ok := g.AssetMaxChange[asset]; ok {
+			max = m
+		}
+		// A price of 0 is missing, like an asset the sources did not agree
+		// on. It is always held if there is a price to fall back to.
+		missing := prices[i] == 0
+		if max <= 0 && !missing {
+			continue
+		}
+
+		var refs []float64
+		if p := g.lastJob[asset]; p != 0 {
+			refs = append(refs, float64(p))
+		}
+		if p := g.lastWinning[asset]; p != 0 {
+			refs = append(refs, p)
+		}
+		if len(refs) == 0 {
+			continue // Nothing to compare to
+		}
+
+		change := math.Inf(1)
+		for _, ref := range refs {
+			change = math.Min(change, math.Abs(float64(prices[i])-ref)/ref)
+		}
+		if change <= max && !missing {
+			continue
+		}
+
+		h := HeldPrice{Asset: asset, Price: prices[i], Change: change}
+		if p := g.lastJob[asset]; p != 0 {
+			h.Fallback = p
+		} else {
+			h.Fallback = uint64(math.Round(g.lastWinning[asset]))
+		}
+		held = append(held, h)
+	}
+	return held
+}
+
+// Accept records the prices of a published job
+func (g *PriceGuard) Accept(assetList []string, prices []uint64) {
+	g.lastJob = make(map[string]uint64)
+	for i, asset := range assetList {
+		g.lastJob[asset] = prices[i]
+	}
+}
+
+// SetWinning records the average prices of the last winning oprs, * 1e8
+func (g *PriceGuard) SetWinning(winning map[string]float64) {
+	g.lastWinning = winning
+}
@@ -0,0 +1,54 @@
+package engine_test
+
+import (
+	"testing"
+
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	. "github.com/FactomWyomingEntity/prosper-pool/engine"
+	"github.com/spf13/viper"
+)
+
+func TestPriceGuard(t *testing.T) {
+	conf := viper.New()
+	config.SetDefaults(conf)
+	conf.Set("priceguardassets.xbt", 0.05)
+
+	g, err := NewPriceGuard(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assets := []string{"USD", "XBT", "EUR"}
+	// Nothing to compare to yet
+	if held := g.Check(assets, []uint64{1e8, 8000e8, 0}); len(held) != 0 {
+		t.Errorf("exp nothing held on the first job, found %v", held)
+	}
+	g.Accept(assets, []uint64{1e8, 8000e8, 1.1e8})
+
+	// XBT moves 10%, over its 5% limit. USD moves 30%.
+	held := g.Check(assets, []uint64{1.3e8, 8800e8, 1.15e8})
+	if len(held) != 2 || held[0].Asset != "USD" || held[1].Asset != "XBT" {
+		t.Fatalf("exp USD and XBT held, found %v", held)
+	}
+	if held[1].Fallback != 8000e8 {
+		t.Errorf("exp fallback to the last job, found %d", held[1].Fallback)
+	}
+
+	// Once the network agrees, the move is accepted
+	g.SetWinning(map[string]float64{"XBT": 8790e8})
+	held = g.Check(assets, []uint64{1e8, 8800e8, 1.1e8})
+	if len(held) != 0 {
+		t.Errorf("exp the move to be accepted, found %v", held)
+	}
+
+	// An asset with no price, like a failed consensus, uses the last price
+	held = g.Check(assets, []uint64{1e8, 8800e8, 0})
+	if len(held) != 1 || held[0].Asset != "EUR" || held[0].Fallback != 1.1e8 {
+		t.Errorf("exp EUR held at the last price, found %v", held)
+	}
+
+	conf.Set(config.ConfigPriceGuardAction, "ignore")
+	if _, err := NewPriceGuard(conf); err == nil {
+		t.Errorf("exp an error for a bad action")
+	}
+}
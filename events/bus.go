@@ -0,0 +1,171 @@
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	busLog = log.WithFields(log.Fields{"mod": "events"})
+)
+
+// Policy is what a subscription does when its buffer is full
+type Policy int
+
+const (
+	// DropNewest drops the event being published. The publisher never
+	// waits.
+	DropNewest Policy = iota
+	// DropOldest drops the oldest buffered event to make room. The
+	// publisher never waits.
+	DropOldest
+	// Block makes the publisher wait for room. Only use it for subscribers
+	// that must see every event, and that keep up.
+	Block
+)
+
+func (p Policy) String() string {
+	switch p {
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	case Block:
+		return "block"
+	}
+	return "unknown"
+}
+
+// Bus passes events from the modules that publish them to the modules that
+// subscribe. A nil bus drops every event, so modules can publish without
+// checking if they were given one.
+type Bus struct {
+	sync.RWMutex
+	subs map[Topic][]*Subscription
+}
+
+func NewBus() *Bus {
+	b := new(Bus)
+	b.subs = make(map[Topic][]*Subscription)
+	return b
+}
+
+// Subscription is a subscriber's buffered queue of events
+type Subscription struct {
+	Name   string
+	Policy Policy
+
+	c       chan Event
+	done    chan struct{}
+	once    sync.Once
+	dropped uint64
+	topics  []Topic
+}
+
+// Events are the subscribed events, in the order they were published
+func (s *Subscription) Events() <-chan Event {
+	return s.c
+}
+
+// Dropped is how many events were lost because the buffer was full
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (s *Subscription) drop(e Event) {
+	atomic.AddUint64(&s.dropped, 1)
+	eventsDropped.WithLabelValues(s.Name, string(e.Topic())).Inc()
+	busLog.WithFields(log.Fields{"subscriber": s.Name, "topic": e.Topic()}).Debugf("event dropped")
+}
+
+func (s *Subscription) send(e Event) {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+
+	switch s.Policy {
+	case Block:
+		select {
+		case s.c <- e:
+		case <-s.done:
+		}
+	case DropOldest:
+		for {
+			select {
+			case s.c <- e:
+				return
+			default:
+			}
+			// Full, make room. The subscriber could have made room
+			// already, so this might not drop anything.
+			select {
+			case old := <-s.c:
+				s.drop(old)
+			default:
+			}
+		}
+	default:
+		select {
+		case s.c <- e:
+		default:
+			s.drop(e)
+		}
+	}
+}
+
+// Subscribe returns a subscription for the topics, with room for buffer
+// events. Subscribe in the init phase, so no events are missed.
+func (b *Bus) Subscribe(name string, buffer int, policy Policy, topics ...Topic) *Subscription {
+	s := &Subscription{
+		Name:   name,
+		Policy: policy,
+		c:      make(chan Event, buffer),
+		done:   make(chan struct{}),
+		topics: topics,
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	for _, t := range topics {
+		b.subs[t] = append(b.subs[t], s)
+	}
+	return s
+}
+
+// Unsubscribe stops the events to the subscription. A publisher blocked on
+// it is let go.
+func (b *Bus) Unsubscribe(s *Subscription) {
+	s.once.Do(func() { close(s.done) })
+
+	b.Lock()
+	defer b.Unlock()
+	for _, t := range s.topics {
+		subs := b.subs[t]
+		for i := range subs {
+			if subs[i] == s {
+				b.subs[t] = append(subs[:i:i], subs[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Publish sends the event to every subscriber of its topic
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+
+	eventsPublished.WithLabelValues(string(e.Topic())).Inc()
+	b.RLock()
+	subs := b.subs[e.Topic()]
+	b.RUnlock()
+
+	for _, s := range subs {
+		s.send(e)
+	}
+}
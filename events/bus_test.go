@@ -0,0 +1,94 @@
+package events_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/events"
+	"github.com/stretchr/testify/require"
+)
+
+func job(id int32) NewJob {
+	return NewJob{JobID: id}
+}
+
+func jobIDs(sub *Subscription) []int32 {
+	var ids []int32
+	for len(sub.Events()) > 0 {
+		ids = append(ids, (<-sub.Events()).(NewJob).JobID)
+	}
+	return ids
+}
+
+func TestBus_Topics(t *testing.T) {
+	require := require.New(t)
+	b := NewBus()
+	jobs := b.Subscribe("jobs", 10, DropNewest, TopicNewJob)
+	all := b.Subscribe("all", 10, DropNewest, TopicNewJob, TopicShareAccepted)
+
+	b.Publish(job(1))
+	b.Publish(ShareAccepted{Username: "alice"})
+	b.Publish(BlockGraded{Height: 1})
+
+	require.Len(jobs.Events(), 1)
+	require.Len(all.Events(), 2)
+	require.Equal(job(1), <-all.Events())
+	require.Equal(ShareAccepted{Username: "alice"}, <-all.Events())
+
+	// Nothing is sent after unsubscribing
+	b.Unsubscribe(jobs)
+	b.Publish(job(2))
+	require.Equal([]int32{1}, jobIDs(jobs))
+	require.Len(all.Events(), 1)
+
+	// A nil bus drops everything
+	var nilBus *Bus
+	nilBus.Publish(job(3))
+}
+
+func TestBus_Policies(t *testing.T) {
+	require := require.New(t)
+	b := NewBus()
+	newest := b.Subscribe("newest", 2, DropNewest, TopicNewJob)
+	oldest := b.Subscribe("oldest", 2, DropOldest, TopicNewJob)
+
+	for i := int32(1); i <= 4; i++ {
+		b.Publish(job(i))
+	}
+	require.Equal([]int32{1, 2}, jobIDs(newest))
+	require.EqualValues(2, newest.Dropped())
+	require.Equal([]int32{3, 4}, jobIDs(oldest))
+	require.EqualValues(2, oldest.Dropped())
+}
+
+func TestBus_Block(t *testing.T) {
+	require := require.New(t)
+	b := NewBus()
+	sub := b.Subscribe("block", 1, Block, TopicNewJob)
+
+	b.Publish(job(1))
+	published := make(chan struct{})
+	go func() {
+		b.Publish(job(2))
+		close(published)
+	}()
+
+	// The publisher waits for room
+	select {
+	case <-published:
+		t.Fatal("publish did not wait for the subscriber")
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.Equal(job(1), <-sub.Events())
+	<-published
+	require.Equal(job(2), <-sub.Events())
+	require.Zero(sub.Dropped())
+
+	// Unsubscribing lets a waiting publisher go
+	b.Publish(job(3))
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		b.Unsubscribe(sub)
+	}()
+	b.Publish(job(4))
+}
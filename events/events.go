@@ -0,0 +1,155 @@
+package events
+
+import (
+	"time"
+)
+
+// Topic is the kind of an event. Subscribers pick the topics they want.
+type Topic string
+
+const (
+	TopicNewJob            Topic = "new-job"
+	TopicShareAccepted     Topic = "share-accepted"
+	TopicShareRejected     Topic = "share-rejected"
+	TopicEntrySubmitted    Topic = "entry-submitted"
+	TopicBlockGraded       Topic = "block-graded"
+	TopicRewardCredited    Topic = "reward-credited"
+	TopicPayoutRecorded    Topic = "payout-recorded"
+	TopicMinerConnected    Topic = "miner-connected"
+	TopicMinerDisconnected Topic = "miner-disconnected"
+	TopicWorkerStatus      Topic = "worker-status"
+)
+
+// Event is anything published on the bus. The events are values, and are
+// shared by every subscriber, so they must not be changed.
+type Event interface {
+	Topic() Topic
+}
+
+// NewJob is a new job sent to the miners
+type NewJob struct {
+	JobID   int32
+	OPRHash string
+	Time    time.Time
+}
+
+func (NewJob) Topic() Topic { return TopicNewJob }
+
+// ShareAccepted is a share the stratum server took from a miner
+type ShareAccepted struct {
+	Username string
+	MinerID  string
+	JobID    int32
+	Target   uint64
+	Time     time.Time
+}
+
+func (ShareAccepted) Topic() Topic { return TopicShareAccepted }
+
+// Reasons a share is rejected
+const (
+	RejectNoJob     = "no-job"
+	RejectStale     = "stale"
+	RejectMalformed = "malformed"
+	RejectLowTarget = "low-target"
+	RejectDuplicate = "duplicate"
+	RejectInvalid   = "invalid"
+	RejectGate      = "gate"
+	// RejectBackpressure is a valid share the accountant was too busy to
+	// take
+	RejectBackpressure = "backpressure"
+)
+
+// ShareRejected is a share the stratum server refused
+type ShareRejected struct {
+	Username string
+	MinerID  string
+	JobID    string
+	Reason   string
+	Time     time.Time
+}
+
+func (ShareRejected) Topic() Topic { return TopicShareRejected }
+
+// EntrySubmitted is an opr entry the pool paid to put on chain
+type EntrySubmitted struct {
+	JobID     int32
+	EntryHash string
+	Target    uint64
+	ECCost    int
+	Time      time.Time
+}
+
+func (EntrySubmitted) Topic() Topic { return TopicEntrySubmitted }
+
+// BlockGraded is a synced block, and how the pool's oprs did in it
+type BlockGraded struct {
+	Height int32
+	// Top is false while the pool is catching up on old blocks
+	Top        bool
+	GradedSize int
+	Graded     int
+	Winning    int
+	PoolReward int64
+	Time       time.Time
+}
+
+func (BlockGraded) Topic() Topic { return TopicBlockGraded }
+
+// RewardCredited is what a user earned for a job, in PEG
+type RewardCredited struct {
+	JobID  int32
+	UserID string
+	Payout int64
+	Time   time.Time
+}
+
+func (RewardCredited) Topic() Topic { return TopicRewardCredited }
+
+// PayoutRecorded is a payment to a user, in PEG
+type PayoutRecorded struct {
+	UserID        string
+	PayoutAddress string
+	EntryHash     string
+	Amount        int64
+	Time          time.Time
+}
+
+func (PayoutRecorded) Topic() Topic { return TopicPayoutRecorded }
+
+// Miner is an authorized stratum connection
+type Miner struct {
+	SessionID string
+	Username  string
+	MinerID   string
+	// IP is the remote address, with the port
+	IP     string
+	Agent  string
+	Target uint64
+}
+
+// MinerConnected is a miner that authorized
+type MinerConnected struct {
+	Miner
+	Time time.Time
+}
+
+func (MinerConnected) Topic() Topic { return TopicMinerConnected }
+
+// MinerDisconnected is an authorized miner that went away
+type MinerDisconnected struct {
+	Miner
+	Time time.Time
+}
+
+func (MinerDisconnected) Topic() Topic { return TopicMinerDisconnected }
+
+// WorkerStatus is a worker going offline, or coming back online
+type WorkerStatus struct {
+	UserID   string
+	MinerID  string
+	Online   bool
+	LastSeen time.Time
+}
+
+func (WorkerStatus) Topic() Topic { return TopicWorkerStatus }
@@ -0,0 +1,27 @@
+package events
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	eventsPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pool_events_published_total",
+		Help: "Events published on the bus",
+	}, []string{"topic"})
+	eventsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pool_events_dropped_total",
+		Help: "Events dropped because a subscriber's buffer was full",
+	}, []string{"subscriber", "topic"})
+)
+
+var prom sync.Once
+
+func RegisterPrometheus() {
+	prom.Do(func() {
+		prometheus.MustRegister(eventsPublished)
+		prometheus.MustRegister(eventsDropped)
+	})
+}
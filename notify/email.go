@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/qor/mailer"
+)
+
+// SMTPSender sends the mailer's emails through an smtp server
+type SMTPSender struct {
+	Addr string
+	Auth smtp.Auth
+	// From is used if the email does not have a sender
+	From mail.Address
+}
+
+func (s *SMTPSender) Send(email mailer.Email) error {
+	from := s.From
+	if email.From != nil {
+		from = *email.From
+	}
+
+	var to []string
+	var headerTo []string
+	for _, list := range [][]mail.Address{email.TO, email.CC, email.BCC} {
+		for _, addr := range list {
+			to = append(to, addr.Address)
+		}
+	}
+	for _, addr := range email.TO {
+		headerTo = append(headerTo, addr.String())
+	}
+	if len(to) == 0 {
+		return fmt.Errorf("email has no recipients")
+	}
+
+	var msg bytes.Buffer
+	msg.WriteString(fmt.Sprintf("From: %s\r\n", from.String()))
+	msg.WriteString(fmt.Sprintf("To: %s\r\n", strings.Join(headerTo, ", ")))
+	msg.WriteString(fmt.Sprintf("Subject: %s\r\n", mime.QEncoding.Encode("utf-8", email.Subject)))
+	msg.WriteString(fmt.Sprintf("Date: %s\r\n", time.Now().Format(time.RFC1123Z)))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.Replace(email.Text, "\n", "\r\n", -1))
+
+	return smtp.SendMail(s.Addr, s.Auth, from.Address, to, msg.Bytes())
+}
+
+// LogSender only logs the emails. It is used when no smtp server is set.
+type LogSender struct{}
+
+func (LogSender) Send(email mailer.Email) error {
+	var to []string
+	for _, addr := range email.TO {
+		to = append(to, addr.Address)
+	}
+	nLog.WithField("to", strings.Join(to, ",")).Infof("no smtp server set, not sending email '%s'", email.Subject)
+	return nil
+}
@@ -0,0 +1,375 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FactomWyomingEntity/prosper-pool/authentication"
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	"github.com/jinzhu/gorm"
+	"github.com/qor/mailer"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var (
+	nLog = log.WithFields(log.Fields{"mod": "notify"})
+)
+
+// Event types
+const (
+	EventWorkerOffline = "worker-offline"
+	EventWorkerOnline  = "worker-online"
+	EventPayoutSent    = "payout-sent"
+	EventReward        = "reward-credited"
+	// The pool events go to the admins
+	EventLowECBalance = "low-ec-balance"
+	EventSyncStalled  = "sync-stalled"
+)
+
+// UserEvents are the events about a single user
+var UserEvents = []string{EventWorkerOffline, EventWorkerOnline, EventPayoutSent, EventReward}
+
+// PoolEvents are the events about the pool, sent to the admins
+var PoolEvents = []string{EventLowECBalance, EventSyncStalled}
+
+// defaultEmail are the events emailed to users that have not set a
+// preference. Rewards are credited every block, so they are not emailed
+// unless asked for.
+var defaultEmail = map[string]bool{
+	EventWorkerOffline: true,
+	EventPayoutSent:    true,
+	EventLowECBalance:  true,
+	EventSyncStalled:   true,
+}
+
+// Event is something a user, or the admins, should hear about
+type Event struct {
+	Type string `json:"type"`
+	// UserID is who the event is for. Pool events have no user.
+	UserID  string                 `json:"userid,omitempty"`
+	Time    time.Time              `json:"time"`
+	Subject string                 `json:"subject"`
+	Message string                 `json:"message"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+}
+
+// IsPoolEvent returns if the event is for the admins
+func (e Event) IsPoolEvent() bool {
+	return e.UserID == ""
+}
+
+// Settings is how a user is reached. Users are emailed at their uid.
+type Settings struct {
+	ID            uint   `gorm:"primary_key" json:"-"`
+	UserID        string `gorm:"unique_index" json:"userid"`
+	WebhookURL    string `gorm:"default:''" json:"webhookurl"`
+	WebhookSecret string `gorm:"default:''" json:"-"`
+}
+
+func (Settings) TableName() string { return "notification_settings" }
+
+// Preference is if a user wants an event emailed, or sent to their webhook
+type Preference struct {
+	ID      uint   `gorm:"primary_key" json:"-"`
+	UserID  string `gorm:"unique_index:notify_pref_user_event" json:"-"`
+	Event   string `gorm:"unique_index:notify_pref_user_event" json:"event"`
+	Email   bool   `json:"email"`
+	Webhook bool   `json:"webhook"`
+}
+
+func (Preference) TableName() string { return "notification_preferences" }
+
+// Service delivers the events by email and webhook. Events are published
+// without waiting, and delivered in Run.
+type Service struct {
+	DB     *gorm.DB
+	Mailer *mailer.Mailer
+	From   mail.Address
+
+	// AdminWebhook gets every pool event, on top of the admins' own
+	// preferences
+	AdminWebhook       string
+	AdminWebhookSecret string
+
+	Webhooks *WebhookClient
+
+	events chan Event
+}
+
+func NewService(conf *viper.Viper, db *gorm.DB) (*Service, error) {
+	s := new(Service)
+	s.DB = db
+	s.events = make(chan Event, 1000)
+
+	from, err := mail.ParseAddress(conf.GetString(config.ConfigNotifyFrom))
+	if err != nil {
+		return nil, fmt.Errorf("notify from address: %s", err.Error())
+	}
+	s.From = *from
+
+	var sender mailer.SenderInterface = LogSender{}
+	if host := conf.GetString(config.ConfigNotifySMTPHost); host != "" {
+		smtpSender := &SMTPSender{
+			Addr: fmt.Sprintf("%s:%d", host, conf.GetInt(config.ConfigNotifySMTPPort)),
+			From: s.From,
+		}
+		if user := conf.GetString(config.ConfigNotifySMTPUsername); user != "" {
+			smtpSender.Auth = smtp.PlainAuth("", user, conf.GetString(config.ConfigNotifySMTPPassword), host)
+		}
+		sender = smtpSender
+	}
+	s.Mailer = mailer.New(&mailer.Config{Sender: sender})
+
+	s.AdminWebhook = conf.GetString(config.ConfigNotifyAdminWebhook)
+	s.AdminWebhookSecret = conf.GetString(config.ConfigNotifyAdminWebhookSecret)
+	s.Webhooks = NewWebhookClient(conf.GetBool(config.ConfigNotifyAllowPrivateWebhooks))
+
+	s.DB.AutoMigrate(&Settings{})
+	s.DB.AutoMigrate(&Preference{})
+	return s, nil
+}
+
+// Publish queues the event. It never blocks, the event is dropped if the
+// queue is full.
+func (s *Service) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	select {
+	case s.events <- e:
+	default:
+		nLog.WithFields(log.Fields{"event": e.Type, "user": e.UserID}).Warnf("notification dropped")
+	}
+}
+
+// Run delivers the published events until the context is done
+func (s *Service) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-s.events:
+			if err := s.Deliver(e); err != nil {
+				nLog.WithError(err).WithFields(log.Fields{"event": e.Type, "user": e.UserID}).
+					Warnf("notification failed")
+			}
+		}
+	}
+}
+
+// Deliver sends the event to everyone that wants it, and waits for it to be
+// sent. Pool events go to the admins.
+func (s *Service) Deliver(e Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	users := []string{e.UserID}
+	if e.IsPoolEvent() {
+		var err error
+		users, err = s.admins()
+		if err != nil {
+			return err
+		}
+	}
+
+	var wg sync.WaitGroup
+	var errLock sync.Mutex
+	var errs []string
+	fail := func(err error) {
+		errLock.Lock()
+		errs = append(errs, err.Error())
+		errLock.Unlock()
+	}
+
+	for _, uid := range users {
+		settings, pref, err := s.preference(uid, e.Type)
+		if err != nil {
+			fail(err)
+			continue
+		}
+		if pref.Email {
+			if err := s.email(uid, e); err != nil {
+				fail(fmt.Errorf("email %s: %s", uid, err.Error()))
+			}
+		}
+		if pref.Webhook && settings.WebhookURL != "" {
+			wg.Add(1)
+			go func(uid string, settings Settings) {
+				defer wg.Done()
+				if err := s.Webhooks.Send(settings.WebhookURL, settings.WebhookSecret, e); err != nil {
+					fail(fmt.Errorf("webhook %s: %s", uid, err.Error()))
+				}
+			}(uid, settings)
+		}
+	}
+
+	if e.IsPoolEvent() && s.AdminWebhook != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Webhooks.Send(s.AdminWebhook, s.AdminWebhookSecret, e); err != nil {
+				fail(fmt.Errorf("admin webhook: %s", err.Error()))
+			}
+		}()
+	}
+
+	wg.Wait()
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (s *Service) email(uid string, e Event) error {
+	to, err := mail.ParseAddress(uid)
+	if err != nil {
+		return fmt.Errorf("'%s' is not an email", uid)
+	}
+	return s.Mailer.Send(mailer.Email{
+		TO:      []mail.Address{*to},
+		From:    &s.From,
+		Subject: e.Subject,
+		Text:    e.Message + "\n",
+	})
+}
+
+// admins are the active admins, who get the pool events
+func (s *Service) admins() ([]string, error) {
+	var users []authentication.User
+	err := s.DB.Where("role = ? AND status = ?", authentication.RoleAdmin, authentication.StatusActive).
+		Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	uids := make([]string, len(users))
+	for i, u := range users {
+		uids[i] = u.UID
+	}
+	return uids, nil
+}
+
+// settings returns the user's settings, empty if they have none
+func (s *Service) settings(uid string) (Settings, error) {
+	var settings Settings
+	err := s.DB.Where("user_id = ?", uid).First(&settings).Error
+	if err == gorm.ErrRecordNotFound {
+		return Settings{UserID: uid}, nil
+	}
+	return settings, err
+}
+
+// preference returns the user's settings and their preference for the event
+func (s *Service) preference(uid, event string) (Settings, Preference, error) {
+	settings, err := s.settings(uid)
+	if err != nil {
+		return settings, Preference{}, err
+	}
+
+	var pref Preference
+	err = s.DB.Where("user_id = ? AND event = ?", uid, event).First(&pref).Error
+	if err == gorm.ErrRecordNotFound {
+		return settings, defaultPreference(uid, event), nil
+	}
+	return settings, pref, err
+}
+
+func defaultPreference(uid, event string) Preference {
+	return Preference{UserID: uid, Event: event, Email: defaultEmail[event], Webhook: true}
+}
+
+// IsPoolEvent returns if the event type is one of the pool events
+func IsPoolEvent(event string) bool {
+	for _, e := range PoolEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func validEvent(event string) bool {
+	for _, events := range [][]string{UserEvents, PoolEvents} {
+		for _, e := range events {
+			if e == event {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Preferences returns the user's preference for every event they can get.
+// Only admins get the pool events.
+func (s *Service) Preferences(u authentication.User) (Settings, []Preference, error) {
+	settings, err := s.settings(u.UID)
+	if err != nil {
+		return settings, nil, err
+	}
+
+	events := UserEvents
+	if u.Role == authentication.RoleAdmin {
+		events = append(append([]string{}, UserEvents...), PoolEvents...)
+	}
+
+	prefs := make([]Preference, len(events))
+	for i, event := range events {
+		_, prefs[i], err = s.preference(u.UID, event)
+		if err != nil {
+			return settings, nil, err
+		}
+	}
+	return settings, prefs, nil
+}
+
+// SetPreference sets if the user wants the event emailed, or sent to their
+// webhook
+func (s *Service) SetPreference(uid, event string, email, webhook bool) error {
+	if !validEvent(event) {
+		return fmt.Errorf("unknown event '%s'", event)
+	}
+
+	var pref Preference
+	err := s.DB.Where("user_id = ? AND event = ?", uid, event).
+		Attrs(Preference{UserID: uid, Event: event}).
+		FirstOrCreate(&pref).Error
+	if err != nil {
+		return err
+	}
+	return s.DB.Model(&pref).Updates(map[string]interface{}{
+		"email":   email,
+		"webhook": webhook,
+	}).Error
+}
+
+// SetWebhook sets the user's webhook, and returns the new secret the
+// deliveries are signed with. An empty url removes the webhook.
+func (s *Service) SetWebhook(uid, url string) (string, error) {
+	secret := ""
+	if url != "" {
+		if err := ValidWebhookURL(url); err != nil {
+			return "", err
+		}
+		var err error
+		if secret, err = NewWebhookSecret(); err != nil {
+			return "", err
+		}
+	}
+
+	var settings Settings
+	err := s.DB.Where("user_id = ?", uid).Attrs(Settings{UserID: uid}).FirstOrCreate(&settings).Error
+	if err != nil {
+		return "", err
+	}
+	err = s.DB.Model(&settings).Updates(map[string]interface{}{
+		"webhook_url":    url,
+		"webhook_secret": secret,
+	}).Error
+	return secret, err
+}
@@ -0,0 +1,289 @@
+package notify_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/FactomWyomingEntity/prosper-pool/authentication"
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	. "github.com/FactomWyomingEntity/prosper-pool/notify"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/qor/mailer"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+// smtpStandIn is a local smtp server that keeps the messages it is sent
+type smtpStandIn struct {
+	net.Listener
+	sync.Mutex
+	messages []smtpMessage
+}
+
+type smtpMessage struct {
+	From string
+	To   []string
+	Data string
+}
+
+func newSMTPStandIn(t *testing.T) *smtpStandIn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &smtpStandIn{Listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+
+	var msg smtpMessage
+	reply("220 localhost ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			msg.From = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			reply("250 ok")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			msg.To = append(msg.To, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			reply("250 ok")
+		case cmd == "DATA":
+			reply("354 end with .")
+			var data []string
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				l = strings.TrimRight(l, "\r\n")
+				if l == "." {
+					break
+				}
+				data = append(data, l)
+			}
+			msg.Data = strings.Join(data, "\n")
+			s.Lock()
+			s.messages = append(s.messages, msg)
+			s.Unlock()
+			msg = smtpMessage{}
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (s *smtpStandIn) Messages() []smtpMessage {
+	s.Lock()
+	defer s.Unlock()
+	return append([]smtpMessage{}, s.messages...)
+}
+
+// webhookReceiver keeps the events posted to it, if they are signed
+type webhookReceiver struct {
+	*httptest.Server
+	sync.Mutex
+	secret string
+	events []Event
+	fails  int
+}
+
+func newWebhookReceiver(t *testing.T, secret string) *webhookReceiver {
+	w := &webhookReceiver{secret: secret}
+	w.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		w.Lock()
+		defer w.Unlock()
+		if w.fails > 0 {
+			w.fails--
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		ts, _ := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+		if r.Header.Get(HeaderSignature) != Sign(w.secret, ts, body) {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var e Event
+		if err := json.Unmarshal(body, &e); err != nil || e.Type != r.Header.Get(HeaderEvent) {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.events = append(w.events, e)
+	}))
+	return w
+}
+
+func (w *webhookReceiver) Events() []Event {
+	w.Lock()
+	defer w.Unlock()
+	return append([]Event{}, w.events...)
+}
+
+func serviceForTests(t *testing.T, smtp *smtpStandIn) *Service {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.AutoMigrate(&authentication.User{})
+
+	conf := viper.New()
+	config.SetDefaults(conf)
+	s, err := NewService(conf, db)
+	require.NoError(t, err)
+
+	s.Mailer = mailer.New(&mailer.Config{Sender: &SMTPSender{Addr: smtp.Addr().String(), From: s.From}})
+	s.Webhooks = NewWebhookClient(true)
+	s.Webhooks.Backoff = time.Millisecond
+	return s
+}
+
+func TestService_Deliver(t *testing.T) {
+	require := require.New(t)
+	smtp := newSMTPStandIn(t)
+	defer smtp.Close()
+	s := serviceForTests(t, smtp)
+	defer s.DB.Close()
+
+	// No preferences, the defaults are used
+	offline := Event{Type: EventWorkerOffline, UserID: "alice@example.com", Subject: "Worker rig is offline", Message: "rig stopped"}
+	require.NoError(s.Deliver(offline))
+	msgs := smtp.Messages()
+	require.Len(msgs, 1)
+	require.Equal([]string{"alice@example.com"}, msgs[0].To)
+	require.Contains(msgs[0].Data, "Subject: Worker rig is offline")
+	require.Contains(msgs[0].Data, "rig stopped")
+
+	// Rewards are not emailed by default
+	require.NoError(s.Deliver(Event{Type: EventReward, UserID: "alice@example.com", Subject: "credited"}))
+	require.Len(smtp.Messages(), 1)
+
+	// Turn off the email, and post to a webhook instead. The first post
+	// fails, and is retried.
+	secret, err := s.SetWebhook("alice@example.com", "http://127.0.0.1/hook")
+	require.NoError(err)
+	hook := newWebhookReceiver(t, secret)
+	defer hook.Close()
+	_, err = s.SetWebhook("alice@example.com", "ftp://example.com")
+	require.Error(err)
+
+	secret, err = s.SetWebhook("alice@example.com", hook.URL)
+	require.NoError(err)
+	hook.secret = secret
+	hook.fails = 1
+	require.NoError(s.SetPreference("alice@example.com", EventWorkerOffline, false, true))
+	require.Error(s.SetPreference("alice@example.com", "not-an-event", true, true))
+
+	require.NoError(s.Deliver(offline))
+	require.Len(smtp.Messages(), 1)
+	events := hook.Events()
+	require.Len(events, 1)
+	require.Equal(EventWorkerOffline, events[0].Type)
+	require.Equal("alice@example.com", events[0].UserID)
+
+	// A bad signature is refused
+	hook.secret = "wrong"
+	require.Error(s.Deliver(offline))
+
+	settings, prefs, err := s.Preferences(authentication.User{UID: "alice@example.com"})
+	require.NoError(err)
+	require.Equal(hook.URL, settings.WebhookURL)
+	require.Len(prefs, len(UserEvents))
+	for _, p := range prefs {
+		switch p.Event {
+		case EventWorkerOffline:
+			require.False(p.Email)
+			require.True(p.Webhook)
+		case EventReward:
+			require.False(p.Email)
+		default:
+			require.Equal(p.Event != EventWorkerOnline, p.Email, p.Event)
+		}
+	}
+}
+
+func TestService_PoolEvents(t *testing.T) {
+	require := require.New(t)
+	smtp := newSMTPStandIn(t)
+	defer smtp.Close()
+	s := serviceForTests(t, smtp)
+	defer s.DB.Close()
+
+	require.NoError(s.DB.Create(&authentication.User{UID: "admin@example.com", Role: authentication.RoleAdmin, Status: authentication.StatusActive}).Error)
+	require.NoError(s.DB.Create(&authentication.User{UID: "banned@example.com", Role: authentication.RoleAdmin, Status: authentication.StatusBanned}).Error)
+	require.NoError(s.DB.Create(&authentication.User{UID: "bob@example.com", Role: authentication.RoleUser, Status: authentication.StatusActive}).Error)
+
+	hook := newWebhookReceiver(t, "admin-secret")
+	defer hook.Close()
+	s.AdminWebhook = hook.URL
+	s.AdminWebhookSecret = "admin-secret"
+
+	e := Event{Type: EventSyncStalled, Subject: "Pool sync stalled", Message: "no blocks"}
+	require.True(e.IsPoolEvent())
+	require.NoError(s.Deliver(e))
+
+	msgs := smtp.Messages()
+	require.Len(msgs, 1)
+	require.Equal([]string{"admin@example.com"}, msgs[0].To)
+	from, err := mail.ParseAddress(msgs[0].From)
+	require.NoError(err)
+	require.Equal(s.From.Address, from.Address)
+	require.Len(hook.Events(), 1)
+
+	// Only admins see the pool events
+	_, prefs, err := s.Preferences(authentication.User{UID: "admin@example.com", Role: authentication.RoleAdmin})
+	require.NoError(err)
+	require.Len(prefs, len(UserEvents)+len(PoolEvents))
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"type":"payout-sent"}`)
+	sig := Sign("secret", 1600000000, body)
+	require.True(t, strings.HasPrefix(sig, "sha256="))
+	require.Len(t, sig, len("sha256=")+64)
+	require.NotEqual(t, sig, Sign("secret", 1600000001, body))
+	require.NotEqual(t, sig, Sign("other", 1600000000, body))
+}
+
+func TestWebhookClient_Private(t *testing.T) {
+	hook := newWebhookReceiver(t, "secret")
+	defer hook.Close()
+
+	c := NewWebhookClient(false)
+	c.Backoff = time.Millisecond
+	err := c.Send(hook.URL, "secret", Event{Type: EventPayoutSent})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not public")
+	require.Len(t, hook.Events(), 0)
+}
@@ -0,0 +1,175 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Webhook headers. The signature is the hex hmac-sha256 of
+// '<timestamp>.<body>' with the webhook secret, so a receiver can check the
+// delivery came from the pool, and is not an old one sent again.
+const (
+	HeaderEvent     = "X-Prosper-Event"
+	HeaderTimestamp = "X-Prosper-Timestamp"
+	HeaderSignature = "X-Prosper-Signature"
+)
+
+// webhookAttempts is the number of tries for each delivery
+const webhookAttempts = 3
+
+// Sign returns the signature of a webhook delivery
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewWebhookSecret makes a random secret to sign deliveries with
+func NewWebhookSecret() (string, error) {
+	data := make([]byte, 32)
+	if _, err := crand.Read(data); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(data), nil
+}
+
+// ValidWebhookURL checks the url is http or https
+func ValidWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid webhook url: %s", err.Error())
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("webhook url must be http or https")
+	}
+	if u.Host == "" {
+		return fmt.Errorf("webhook url has no host")
+	}
+	return nil
+}
+
+// WebhookClient posts the events to the webhooks
+type WebhookClient struct {
+	Client *http.Client
+	// Backoff is the wait before the first retry. It doubles each retry.
+	Backoff time.Duration
+}
+
+// NewWebhookClient makes a client for the users' webhooks. Users pick the
+// urls, so unless private addresses are allowed, the client refuses to
+// connect to the pool's own network.
+func NewWebhookClient(allowPrivate bool) *WebhookClient {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || privateIP(ip) {
+				return fmt.Errorf("webhook address %s is not public", host)
+			}
+			return nil
+		}
+	}
+
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	return &WebhookClient{
+		Client: &http.Client{
+			Transport: transport,
+			Timeout:   15 * time.Second,
+			// A redirect could point anywhere, so do not follow them
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		Backoff: time.Second,
+	}
+}
+
+var privateNets []*net.IPNet
+
+func init() {
+	for _, cidr := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+		"172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7", "fe80::/10",
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		privateNets = append(privateNets, n)
+	}
+}
+
+func privateIP(ip net.IP) bool {
+	if ip.IsUnspecified() || ip.IsMulticast() {
+		return true
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Send posts the event to the url, signed with the secret. Failed deliveries
+// are retried.
+func (c *WebhookClient) Send(url, secret string, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	backoff := c.Backoff
+	for attempt := 1; ; attempt++ {
+		err = c.post(url, secret, e.Type, body)
+		if err == nil || attempt == webhookAttempts {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (c *WebhookClient) post(url, secret, event string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Client.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, event)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(secret, timestamp, body))
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -7,7 +7,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"time"
 
 	"github.com/FactomWyomingEntity/prosper-pool/config"
@@ -92,7 +91,7 @@ func (d *APILayerDataSource) CallAPILayer() (response APILayerResponse, err erro
 	var apiLayerResponse APILayerResponse
 	var emptyResponse APILayerResponse
 
-	resp, err := http.Get("http://www.apilayer.net/api/live?access_key=" + d.apikey)
+	resp, err := NewHTTPClient().Get("http://www.apilayer.net/api/live?access_key=" + d.apikey)
 	if err != nil {
 		log.WithError(err).Warning("Failed to get response from API Layer")
 	}
@@ -1,6 +1,7 @@
 package polling
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
 	"sort"
@@ -34,6 +35,7 @@ var AllDataSources = map[string]IDataSource{
 	"AlternativeMe":     new(AlternativeMeDataSource),
 	"PegnetMarketCap":   new(PegnetMarketCapDataSource),
 	"CoinGecko":         new(CoinGeckoDataSource),
+	"File":              new(FileDataSource),
 	//"Factoshiio":        new(FactoshiioDataSource), // This will be deprecated
 }
 
@@ -102,10 +104,18 @@ func NewDataSource(source string, conf *viper.Viper) (IDataSource, error) {
 		ds, err = NewFactoshiioDataSource()
 	case "CoinGecko":
 		ds, err = NewCoinGeckoDataSource()
+	case "File":
+		// The file is local, and should be reloaded as soon as it changes,
+		// so it does not need the cache.
+		return NewFileDataSource(conf)
 	case "UnitTest": // This will fail outside a unit test
 		ds, err = NewTestingDataSource(conf, source)
 	default:
-		return nil, fmt.Errorf("%s is not a supported data source", source)
+		// Sources can be defined in the config
+		if !IsJSONDataSource(conf, source) {
+			return nil, fmt.Errorf("%s is not a supported data source", source)
+		}
+		ds, err = NewJSONDataSource(conf, source)
 	}
 
 	if err != nil {
@@ -115,7 +125,9 @@ func NewDataSource(source string, conf *viper.Viper) (IDataSource, error) {
 	// Have 8min cache on all sources. Any more frequent queries will return the last
 	// cached query. This is mainly for local testing on short blocks. We don't want to blow our
 	// rate limits because we want to test on 30s blocks.
-	return NewTimedDataSourceCache(ds, time.Minute*8), nil
+	// The breaker is under the cache, so only real api calls count against
+	// the quota.
+	return NewTimedDataSourceCache(NewBreakerDataSource(ds, conf), time.Minute*8), nil
 }
 
 // DataSources will initialize all data sources and handle pulling of all the assets.
@@ -135,10 +147,20 @@ type DataSources struct {
 	// The list of data sources by priority.
 	PriorityList []DataSourceWithPriority
 
+	// AssetPricing is how each asset is priced. Either by priority or by
+	// consensus.
+	//	Key -> CurrencyISO
+	//	Value -> Pricing mode
+	AssetPricing map[string]string
+
 	viperConfig *viper.Viper
 
 	// Some configuration variables read in from the config
 	staleDuration time.Duration
+	sourceTimeout time.Duration
+
+	consensusDeviation float64
+	consensusAverage   string
 }
 
 type DataSourceWithPriority struct {
@@ -152,6 +174,7 @@ func NewDataSources(conf *viper.Viper, enforce bool) *DataSources {
 	d := new(DataSources)
 	d.AssetSources = make(map[string][]string)
 	d.DataSources = make(map[string]IDataSource)
+	d.AssetPricing = make(map[string]string)
 	d.viperConfig = conf
 
 	// Load some specific config settings
@@ -162,6 +185,26 @@ func NewDataSources(conf *viper.Viper, enforce bool) *DataSources {
 
 	d.staleDuration = staleDuration
 
+	sourceTimeout := d.viperConfig.GetDuration(config.ConfigPollingSourceTimeout)
+	if sourceTimeout == 0 {
+		sourceTimeout = time.Second * 30
+	}
+	d.sourceTimeout = sourceTimeout
+
+	if path := d.viperConfig.GetString(config.ConfigPollingMarketCalendar); path != "" {
+		CheckAndPanic(LoadMarketCalendar(path))
+	}
+
+	d.consensusDeviation = d.viperConfig.GetFloat64(config.ConfigPollingConsensusDeviation)
+	if d.consensusDeviation <= 0 {
+		d.consensusDeviation = 0.05
+	}
+	d.consensusAverage = strings.ToLower(d.viperConfig.GetString(config.ConfigPollingConsensusAverage))
+	if d.consensusAverage != ConsensusMedian && d.consensusAverage != ConsensusTrimmedMean {
+		CheckAndPanic(fmt.Errorf("consensus average must be '%s' or '%s', found '%s'",
+			ConsensusMedian, ConsensusTrimmedMean, d.consensusAverage))
+	}
+
 	// All the config settings
 	allSettings := conf.AllKeys()
 
@@ -226,6 +269,24 @@ func NewDataSources(conf *viper.Viper, enforce bool) *DataSources {
 		}
 	}
 
+	// The pricing mode can be set for all assets, and overridden for
+	// specific assets.
+	defaultPricing := strings.ToLower(conf.GetString(config.ConfigPollingPricingMode))
+	if defaultPricing == "" {
+		defaultPricing = PricingPriority
+	}
+	for _, asset := range AllAssets {
+		pricing := defaultPricing
+		if mode := conf.GetString("oracleassetpricing." + strings.ToLower(asset)); mode != "" {
+			pricing = strings.ToLower(mode)
+		}
+		if pricing != PricingPriority && pricing != PricingConsensus {
+			CheckAndPanic(fmt.Errorf("pricing for %s must be '%s' or '%s', found '%s'",
+				asset, PricingPriority, PricingConsensus, pricing))
+		}
+		d.AssetPricing[asset] = pricing
+	}
+
 	if enforce {
 		// Verify all assets are covered
 		for _, asset := range opr.V2Assets {
@@ -259,11 +320,15 @@ func (ds *DataSources) PriorityListString() string {
 }
 
 // AssetPriorityString will print all the data sources for it in the priority order.
+// Assets priced by consensus list all the sources that are averaged.
 func (ds *DataSources) AssetPriorityString(asset string) string {
 	str := ds.AssetSources[asset]
 	if len(str) == 0 {
 		return "NO DATASOURCE!"
 	}
+	if ds.IsConsensusAsset(asset) {
+		return "consensus of " + strings.Join(str, ", ")
+	}
 	return strings.Join(str, " -> ")
 }
 
@@ -274,9 +339,10 @@ func (ds *DataSources) AssetPriorityString(asset string) string {
 // 	Params:
 //		oprversion is passed in. Once we get past version 2, we can drop that from the
 //					params and default to the version 2 behavior
-// TODO: Currently we lazy eval prices, so we make the API call when we
-//		first need a price from that source. These calls should be quick,
-//		but it might be faster to eager eval all the data sources concurrently.
+// All the data sources needed for the assets are fetched concurrently up
+// front, so the pull takes as long as the slowest source, rather than the sum
+// of all of them. A source that does not respond within the source timeout is
+// treated as a failed source.
 func (d *DataSources) PullAllPEGAssets(oprversion uint8) (pa PegAssets, err error) {
 	assets := AssetsV2 // All the assets we are tracking.
 	if oprversion == 4 {
@@ -287,22 +353,31 @@ func (d *DataSources) PullAllPEGAssets(oprversion uint8) (pa PegAssets, err erro
 	}
 	start := time.Now()
 
-	// Wrap all the data sources with a quick caching layer for
-	// this loop. We only want to make 1 api call per source per Pull.
-	cacheWrap := make(map[string]IDataSource)
-
-	for _, source := range d.DataSources {
-		cacheWrap[source.Name()] = NewCachedDataSource(source)
+	// Only fetch the sources we might need for this set of assets.
+	var needed []string
+	for _, asset := range assets {
+		for _, source := range d.AssetSources[asset] {
+			if FindIndexInStringArray(needed, source) == -1 {
+				needed = append(needed, source)
+			}
+		}
 	}
 
-	// TODO: You would eager eval here, and block the for loop on the first data source that has
-	// 		not completed. Or block the for loop until they all completed... but I'd prefer the former.
+	// We only want to make 1 api call per source per Pull.
+	fetched := d.PrefetchSources(needed, d.sourceTimeout)
 
 	pa = make(PegAssets)
 	for _, asset := range assets {
 		var price PegItem
 		// For each asset we try and find the best price quote we can.
-		price, err := d.PullBestPrice(asset, start, cacheWrap, oprversion)
+		price, err := d.PullBestPrice(asset, start, fetched, oprversion)
+		if errors.Is(err, ErrNoConsensus) {
+			// Leave the price at 0, so the price guard falls back to the
+			// last price we trusted
+			dLog.WithError(err).Warnf("no consensus price, using the last price")
+			pa[asset] = PegItem{}
+			continue
+		}
 		if err != nil { // This will only be the last err in the data source list.
 			// No prices found for a peg, this pull failed
 			return nil, fmt.Errorf("no price found for %s : %s", asset, err.Error())
@@ -338,19 +413,20 @@ type DataSourcePull struct {
 }
 
 func (d *DataSources) PullAllSources() []DataSourcePull {
-	sources := make([]DataSourcePull, len(d.DataSources))
-	i := 0
-	for source, ds := range d.DataSources {
-		prices, err := ds.FetchPegPrices()
-		if err != nil {
-			sources[i] = DataSourcePull{Name: source, Error: err}
-		}
-		sources[i] = DataSourcePull{Name: source}
+	var names []string
+	for source := range d.DataSources {
+		names = append(names, source)
+	}
+	fetched := d.PrefetchSources(names, d.sourceTimeout)
+
+	sources := make([]DataSourcePull, len(names))
+	for i, source := range names {
+		prices, err := fetched[source].FetchPegPrices()
+		sources[i] = DataSourcePull{Name: source, Error: err}
 		sources[i].Prices = make(map[string]float64)
 		for a, p := range prices {
 			sources[i].Prices[a] = p.Value
 		}
-		i++
 	}
 	return sources
 }
@@ -374,6 +450,33 @@ func TrimmedMean(data []PegItem, p int) float64 {
 	return sum / float64(length-2*p)
 }
 
+// IsStale returns if a quote is older than the stale duration from the
+// reference time. If the asset's market is closed, there is no newer quote to
+// find, so the quote is not stale.
+func (d *DataSources) IsStale(asset string, price PegItem, reference time.Time) bool {
+	if price.When.IsZero() {
+		return false
+	}
+	return reference.Sub(price.When) > d.staleDuration && IsMarketOpen(asset, reference)
+}
+
+// useStale returns the stale quotes when there are no fresh quotes
+func (d *DataSources) useStale(asset string, fresh, stale []PegItem) []PegItem {
+	for _, p := range stale {
+		dLog.WithFields(log.Fields{
+			"asset":  asset,
+			"source": strings.Join(p.Sources, ","),
+			"when":   p.When,
+			"used":   len(fresh) == 0,
+		}).Warnf("stale quote")
+	}
+
+	if len(fresh) == 0 {
+		return stale
+	}
+	return fresh
+}
+
 // PullBestPrice pulls the best asset price we can find for a given asset.
 // Params:
 //		asset		Asset to pull pricing data
@@ -386,25 +489,43 @@ func (d *DataSources) PullBestPrice(asset string, reference time.Time, sources m
 		sources = d.DataSources
 	}
 
+	if d.IsConsensusAsset(asset) {
+		return d.PullConsensusPrice(asset, reference, sources)
+	}
+
 	// All the given data sources for the asset
 	sourceList := d.AssetSources[asset]
 
-	var prices []PegItem
+	var prices, stale []PegItem
 
 	// Eval all datasources from the reference time
 	for i := 0; i < len(sourceList); i++ {
 		source := sourceList[i]
+		s, ok := sources[source]
+		if !ok {
+			err = fmt.Errorf("%s is not a configured datasource", source)
+			continue
+		}
+
 		var price PegItem
-		price, err = sources[source].FetchPegPrice(asset)
+		price, err = s.FetchPegPrice(asset)
 		if err != nil {
 			continue
 		}
 
 		if price.Value != 0 {
+			price.Sources = []string{source}
+			if d.IsStale(asset, price, reference) {
+				stale = append(stale, price)
+				continue
+			}
 			prices = append(prices, price)
 		}
 	}
 
+	// Only use stale quotes if that is all we have
+	prices = d.useStale(asset, prices, stale)
+
 	if oprversion == 5 {
 		pricesClone := prices
 		if len(pricesClone) > 0 {
@@ -464,6 +585,75 @@ func (d *DataSources) PullBestPrice(asset string, reference time.Time, sources m
 		"please check your config file to ensure a datasource exists for this asset", asset)
 }
 
+// PrefetchSources fetches all the named data sources concurrently. Each
+// source has until the timeout to respond, otherwise the source returns an
+// error for this fetch. The returned data sources only return the result
+// of this fetch, and make no new api calls.
+func (d *DataSources) PrefetchSources(names []string, timeout time.Duration) map[string]IDataSource {
+	type result struct {
+		name   string
+		prices PegAssets
+		err    error
+	}
+
+	results := make(chan result, len(names))
+	var launched []string
+	for _, name := range names {
+		source, ok := d.DataSources[name]
+		if !ok {
+			continue
+		}
+		launched = append(launched, name)
+		go func(name string, source IDataSource) {
+			prices, err := source.FetchPegPrices()
+			results <- result{name: name, prices: prices, err: err}
+		}(name, source)
+	}
+
+	fetched := make(map[string]IDataSource)
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+CollectLoop:
+	for range launched {
+		select {
+		case r := <-results:
+			fetched[r.name] = &PrefetchedDataSource{IDataSource: d.DataSources[r.name], Prices: r.prices, Err: r.err}
+		case <-deadline.C:
+			break CollectLoop
+		}
+	}
+
+	// Anything that did not finish has timed out
+	for _, name := range launched {
+		if _, ok := fetched[name]; !ok {
+			dLog.WithField("source", name).Warnf("datasource timed out after %s", timeout)
+			fetched[name] = &PrefetchedDataSource{
+				IDataSource: d.DataSources[name],
+				Err:         fmt.Errorf("%s timed out after %s", name, timeout),
+			}
+		}
+	}
+
+	return fetched
+}
+
+// PrefetchedDataSource holds the result of a single fetch of a data source.
+// Like the OneTimeUseCache, it is only to be used for 1 moment.
+type PrefetchedDataSource struct {
+	IDataSource
+	Prices PegAssets
+	Err    error
+}
+
+func (d *PrefetchedDataSource) FetchPegPrices() (peg PegAssets, err error) {
+	return d.Prices, d.Err
+}
+
+func (d *PrefetchedDataSource) FetchPegPrice(peg string) (i PegItem, err error) {
+	return FetchPegPrice(peg, d.FetchPegPrices)
+}
+
 // OneTimeUseCache will cache the data source response so we can query for
 // prices individually without making a new api call. This cache is only to be used
 // for 1 moment, rather than being used as a cache across multiple actions/rountines.
@@ -127,6 +127,57 @@ func TestBasicPollingSources(t *testing.T) {
 	})
 }
 
+// TestPullAllPEGAssetsConcurrent has 3 slow sources. The 2 that respond in
+// time should be fetched at the same time, and the one that does not should
+// fall back to the next priority.
+func TestPullAllPEGAssetsConcurrent(t *testing.T) {
+	NewTestingDataSource = func(conf *viper.Viper, source string) (IDataSource, error) {
+		s := new(UnitTestDataSource)
+		v, err := strconv.Atoi(string(source[8]))
+		if err != nil {
+			panic(err)
+		}
+		s.Value = float64(v)
+		s.Assets = AllAssets
+		s.SourceName = fmt.Sprintf("UnitTest%d", v)
+		s.Delay = time.Millisecond * 200
+		if v == 1 {
+			// Slower than the timeout
+			s.Delay = time.Second
+		}
+		return s, nil
+	}
+
+	conf := GetConfig(`
+[polling]
+  sourcetimeout = "500ms"
+[oracledatasources]
+  UnitTest1 = 1
+  UnitTest2 = 2
+  UnitTest3 = 3
+`)
+
+	s := NewDataSources(conf, false)
+	start := time.Now()
+	pa, err := s.PullAllPEGAssets(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Serially this would take 1.4s
+	if took := time.Since(start); took > time.Millisecond*900 {
+		t.Errorf("pull took %s, the sources were not fetched concurrently", took)
+	}
+
+	for _, asset := range AssetsV5 {
+		if v, ok := pa[asset]; !ok {
+			t.Errorf("%s is missing", asset)
+		} else if v.Value != 2 {
+			t.Errorf("exp %s to fall back to source 2, found %.2f", asset, v.Value)
+		}
+	}
+}
+
 func TestTruncate(t *testing.T) {
 	type Vector struct {
 		Vector float64
@@ -179,6 +230,8 @@ type UnitTestDataSource struct {
 	Value      float64
 	Assets     []string
 	SourceName string
+	// Delay simulates a slow api
+	Delay time.Duration
 }
 
 func NewUnitTestDataSource(_ *viper.Viper) (*UnitTestDataSource, error) {
@@ -199,6 +252,7 @@ func (d *UnitTestDataSource) SupportedPegs() []string {
 }
 
 func (d *UnitTestDataSource) FetchPegPrices() (peg PegAssets, err error) {
+	time.Sleep(d.Delay)
 	peg = make(map[string]PegItem)
 
 	timestamp := time.Now()
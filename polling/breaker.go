@@ -0,0 +1,252 @@
+package polling
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Circuit breaker states
+const (
+	// BreakerClosed means the source is healthy and is called
+	BreakerClosed = "closed"
+	// BreakerOpen means the source failed too many times in a row, and is
+	// not called until the cooldown passes.
+	BreakerOpen = "open"
+	// BreakerHalfOpen means the cooldown passed, and the next call is a probe.
+	// If the probe works, the breaker closes. If it fails, it opens again.
+	BreakerHalfOpen = "half-open"
+)
+
+// Quota periods
+const (
+	QuotaHour  = "hour"
+	QuotaDay   = "day"
+	QuotaMonth = "month"
+)
+
+// SourceHealth is the health of a single datasource
+type SourceHealth struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+
+	ConsecutiveErrors int       `json:"consecutiveerrors"`
+	TotalCalls        int64     `json:"totalcalls"`
+	TotalErrors       int64     `json:"totalerrors"`
+	LastError         string    `json:"lasterror,omitempty"`
+	LastErrorTime     time.Time `json:"lasterrortime,omitempty"`
+	LastSuccess       time.Time `json:"lastsuccess,omitempty"`
+	OpenedAt          time.Time `json:"openedat,omitempty"`
+
+	// Quota is 0 if the source has no quota
+	Quota       int64     `json:"quota"`
+	QuotaUsed   int64     `json:"quotaused"`
+	QuotaPeriod string    `json:"quotaperiod,omitempty"`
+	QuotaResets time.Time `json:"quotaresets,omitempty"`
+}
+
+// BreakerDataSource wraps a datasource to count the calls made against its
+// quota, and to stop calling it after too many errors in a row. The counts are
+// only the real api calls, so it should be wrapped by the cache, not the
+// other way around. The counts are only kept in memory, so a restart starts
+// the quota over.
+type BreakerDataSource struct {
+	IDataSource
+
+	threshold int
+	cooldown  time.Duration
+
+	sync.Mutex
+	health  SourceHealth
+	probing bool
+}
+
+// NewBreakerDataSource reads the breaker settings and the quota for the
+// source from the config.
+func NewBreakerDataSource(s IDataSource, conf *viper.Viper) *BreakerDataSource {
+	d := new(BreakerDataSource)
+	d.IDataSource = s
+	d.threshold = conf.GetInt(config.ConfigPollingBreakerThreshold)
+	d.cooldown = conf.GetDuration(config.ConfigPollingBreakerCooldown)
+
+	d.health.Name = s.Name()
+	d.health.State = BreakerClosed
+
+	// Quotas are either just the limit, which is monthly, or a limit and
+	// a period.
+	key := "datasourcequotas." + strings.ToLower(s.Name())
+	if conf.IsSet(key + ".limit") {
+		d.health.Quota = conf.GetInt64(key + ".limit")
+		d.health.QuotaPeriod = strings.ToLower(conf.GetString(key + ".period"))
+	} else {
+		d.health.Quota = conf.GetInt64(key)
+	}
+	if d.health.Quota > 0 {
+		switch d.health.QuotaPeriod {
+		case QuotaHour, QuotaDay, QuotaMonth:
+		case "":
+			d.health.QuotaPeriod = QuotaMonth
+		default:
+			CheckAndPanic(fmt.Errorf("quota period for %s must be '%s', '%s' or '%s', found '%s'",
+				s.Name(), QuotaHour, QuotaDay, QuotaMonth, d.health.QuotaPeriod))
+		}
+		d.health.QuotaResets = nextQuotaPeriod(d.health.QuotaPeriod, time.Now())
+	}
+
+	return d
+}
+
+func (d *BreakerDataSource) FetchPegPrices() (peg PegAssets, err error) {
+	if err := d.allow(time.Now()); err != nil {
+		return nil, err
+	}
+
+	peg, err = d.IDataSource.FetchPegPrices()
+	d.record(time.Now(), err)
+	return peg, err
+}
+
+func (d *BreakerDataSource) FetchPegPrice(peg string) (i PegItem, err error) {
+	return FetchPegPrice(peg, d.FetchPegPrices)
+}
+
+// Health returns a copy of the source's health
+func (d *BreakerDataSource) Health() SourceHealth {
+	d.Lock()
+	defer d.Unlock()
+	d.rollQuota(time.Now())
+	return d.health
+}
+
+// allow returns an error if the source should not be called
+func (d *BreakerDataSource) allow(now time.Time) error {
+	d.Lock()
+	defer d.Unlock()
+
+	d.rollQuota(now)
+	if d.health.Quota > 0 && d.health.QuotaUsed >= d.health.Quota {
+		return fmt.Errorf("%s is over its quota of %d calls per %s, resets at %s",
+			d.health.Name, d.health.Quota, d.health.QuotaPeriod, d.health.QuotaResets.Format(time.RFC3339))
+	}
+
+	switch d.health.State {
+	case BreakerOpen:
+		if now.Sub(d.health.OpenedAt) < d.cooldown {
+			return fmt.Errorf("%s is not called, it failed %d times in a row", d.health.Name, d.health.ConsecutiveErrors)
+		}
+		d.health.State = BreakerHalfOpen
+		d.probing = true
+	case BreakerHalfOpen:
+		// Only 1 probe at a time
+		if d.probing {
+			return fmt.Errorf("%s is being probed", d.health.Name)
+		}
+		d.probing = true
+	}
+
+	d.health.TotalCalls++
+	d.health.QuotaUsed++
+	return nil
+}
+
+// record tracks the result of a call, and trips the breaker if needed
+func (d *BreakerDataSource) record(now time.Time, err error) {
+	d.Lock()
+	defer d.Unlock()
+	d.probing = false
+
+	if err == nil {
+		if d.health.State != BreakerClosed {
+			dLog.WithField("source", d.health.Name).Infof("datasource recovered")
+		}
+		d.health.State = BreakerClosed
+		d.health.ConsecutiveErrors = 0
+		d.health.LastSuccess = now
+		return
+	}
+
+	d.health.ConsecutiveErrors++
+	d.health.TotalErrors++
+	d.health.LastError = RedactError(err)
+	d.health.LastErrorTime = now
+
+	if d.health.State == BreakerHalfOpen ||
+		(d.threshold > 0 && d.health.ConsecutiveErrors >= d.threshold) {
+		if d.health.State != BreakerOpen {
+			dLog.WithError(err).WithFields(log.Fields{
+				"source":   d.health.Name,
+				"errors":   d.health.ConsecutiveErrors,
+				"cooldown": d.cooldown,
+			}).Warnf("datasource circuit breaker opened")
+		}
+		d.health.State = BreakerOpen
+		d.health.OpenedAt = now
+	}
+}
+
+var urlPattern = regexp.MustCompile(`https?://[^\s"']+`)
+
+// RedactError returns the error's text with the api keys removed from any
+// urls in it. Http client errors include the request url, and some sources
+// put their key in the query.
+func RedactError(err error) string {
+	return urlPattern.ReplaceAllStringFunc(err.Error(), func(raw string) string {
+		u, perr := url.Parse(raw)
+		if perr != nil {
+			return "<url>"
+		}
+		return RedactUrl(u)
+	})
+}
+
+func (d *BreakerDataSource) rollQuota(now time.Time) {
+	if d.health.Quota > 0 && !now.Before(d.health.QuotaResets) {
+		d.health.QuotaUsed = 0
+		d.health.QuotaResets = nextQuotaPeriod(d.health.QuotaPeriod, now)
+	}
+}
+
+// nextQuotaPeriod returns the start of the next quota period in UTC
+func nextQuotaPeriod(period string, now time.Time) time.Time {
+	utc := now.UTC()
+	switch period {
+	case QuotaHour:
+		return utc.Truncate(time.Hour).Add(time.Hour)
+	case QuotaDay:
+		return time.Date(utc.Year(), utc.Month(), utc.Day()+1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(utc.Year(), utc.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// HealthOf finds the health of a datasource through any wrappers
+func HealthOf(s IDataSource) (SourceHealth, bool) {
+	switch w := s.(type) {
+	case *BreakerDataSource:
+		return w.Health(), true
+	case *TimedDataSourceCache:
+		return HealthOf(w.IDataSource)
+	}
+	return SourceHealth{}, false
+}
+
+// Health returns the health of all the datasources in priority order
+func (d *DataSources) Health() []SourceHealth {
+	var health []SourceHealth
+	for _, s := range d.PriorityList {
+		h, ok := HealthOf(s.DataSource)
+		if !ok {
+			// Local sources have no breaker
+			h = SourceHealth{Name: s.DataSource.Name(), State: BreakerClosed}
+		}
+		health = append(health, h)
+	}
+	return health
+}
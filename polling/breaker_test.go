@@ -0,0 +1,132 @@
+package polling_test
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/polling"
+)
+
+// failingDataSource fails while fail is set
+type failingDataSource struct {
+	UnitTestDataSource
+	fail  bool
+	err   error
+	calls int
+}
+
+func (d *failingDataSource) FetchPegPrices() (PegAssets, error) {
+	d.calls++
+	if d.err != nil {
+		return nil, d.err
+	}
+	if d.fail {
+		return nil, fmt.Errorf("api down")
+	}
+	return d.UnitTestDataSource.FetchPegPrices()
+}
+
+func (d *failingDataSource) FetchPegPrice(peg string) (PegItem, error) {
+	return FetchPegPrice(peg, d.FetchPegPrices)
+}
+
+func TestBreakerDataSource(t *testing.T) {
+	s := &failingDataSource{fail: true}
+	s.SourceName = "Flaky"
+	s.Value = 1
+	s.Assets = []string{"XBT"}
+
+	b := NewBreakerDataSource(s, GetConfig(`
+[polling]
+  breakerthreshold = 2
+  breakercooldown = "50ms"
+`))
+
+	// 2 errors trips the breaker
+	for i := 0; i < 2; i++ {
+		if _, err := b.FetchPegPrices(); err == nil {
+			t.Fatal("exp an error")
+		}
+	}
+	if h := b.Health(); h.State != BreakerOpen || h.ConsecutiveErrors != 2 {
+		t.Fatalf("exp breaker open after 2 errors, found %s with %d", h.State, h.ConsecutiveErrors)
+	}
+
+	// Open breakers do not call the source
+	if _, err := b.FetchPegPrices(); err == nil || s.calls != 2 {
+		t.Errorf("exp the source to not be called, called %d times", s.calls)
+	}
+
+	// A failed probe opens it again
+	time.Sleep(time.Millisecond * 60)
+	if _, err := b.FetchPegPrices(); err == nil || s.calls != 3 {
+		t.Errorf("exp a probe, called %d times", s.calls)
+	}
+	if h := b.Health(); h.State != BreakerOpen {
+		t.Errorf("exp breaker open after a failed probe, found %s", h.State)
+	}
+
+	// A good probe closes it
+	s.fail = false
+	time.Sleep(time.Millisecond * 60)
+	if _, err := b.FetchPegPrices(); err != nil {
+		t.Fatal(err)
+	}
+	if h := b.Health(); h.State != BreakerClosed || h.ConsecutiveErrors != 0 || h.TotalCalls != 4 || h.TotalErrors != 3 {
+		t.Errorf("exp breaker closed, found %+v", h)
+	}
+}
+
+func TestBreakerQuota(t *testing.T) {
+	s := &failingDataSource{}
+	s.SourceName = "Limited"
+	s.Value = 1
+	s.Assets = []string{"XBT"}
+
+	b := NewBreakerDataSource(s, GetConfig(`
+[datasourcequotas]
+  limited = { limit = 2, period = "day" }
+`))
+
+	for i := 0; i < 3; i++ {
+		_, err := b.FetchPegPrices()
+		if i < 2 && err != nil {
+			t.Error(err)
+		}
+		if i == 2 && err == nil {
+			t.Errorf("exp the quota to be used up")
+		}
+	}
+
+	h := b.Health()
+	if s.calls != 2 || h.QuotaUsed != 2 || h.Quota != 2 || h.QuotaPeriod != QuotaDay {
+		t.Errorf("exp 2 calls against the quota, found %+v", h)
+	}
+	if h.State != BreakerClosed {
+		t.Errorf("exp the quota to not trip the breaker")
+	}
+}
+
+func TestBreakerDataSource_RedactsErrors(t *testing.T) {
+	s := &failingDataSource{err: &url.Error{
+		Op:  "Get",
+		URL: "http://www.apilayer.net/api/live?access_key=secret",
+		Err: fmt.Errorf("connection refused"),
+	}}
+	s.SourceName = "Leaky"
+
+	b := NewBreakerDataSource(s, GetConfig(""))
+	if _, err := b.FetchPegPrices(); err == nil {
+		t.Fatal("exp an error")
+	}
+	h := b.Health()
+	if strings.Contains(h.LastError, "secret") || !strings.Contains(h.LastError, "access_key=REDACTED") {
+		t.Errorf("exp the key to be redacted, found '%s'", h.LastError)
+	}
+	if !strings.Contains(h.LastError, "connection refused") {
+		t.Errorf("exp the cause to be kept, found '%s'", h.LastError)
+	}
+}
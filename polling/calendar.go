@@ -0,0 +1,252 @@
+package polling
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// MarketCalendar knows when the forex and commodity markets are open. The
+// hours are in the market's local time, so daylight saving shifts are
+// handled by the timezone. The calendar is loaded from a toml file, the
+// default is DefaultMarketCalendar.
+type MarketCalendar struct {
+	Location  *time.Location
+	Forex     MarketHours
+	Commodity MarketHours
+
+	// warnedYear is the last year we warned the calendar is out of date
+	warnMutex  sync.Mutex
+	warnedYear int
+}
+
+// MarketHours is the weekly schedule of a market
+type MarketHours struct {
+	// The market opens and closes once a week. Minutes since Sunday 00:00
+	Open  int
+	Close int
+
+	// The daily break, in minutes since 00:00. No break if they are equal.
+	BreakStart int
+	BreakEnd   int
+
+	// Holidays are the full days the market is closed. "2006-01-02"
+	Holidays map[string]bool
+	// LastYear is the last year with a holiday
+	LastYear int
+}
+
+var (
+	calendarMutex  sync.RWMutex
+	marketCalendar *MarketCalendar
+)
+
+func init() {
+	c, err := ParseMarketCalendar([]byte(DefaultMarketCalendar))
+	if err != nil {
+		panic(fmt.Sprintf("default market calendar: %s", err.Error()))
+	}
+	marketCalendar = c
+}
+
+// GetMarketCalendar returns the calendar in use
+func GetMarketCalendar() *MarketCalendar {
+	calendarMutex.RLock()
+	defer calendarMutex.RUnlock()
+	return marketCalendar
+}
+
+// SetMarketCalendar replaces the calendar in use
+func SetMarketCalendar(c *MarketCalendar) {
+	calendarMutex.Lock()
+	defer calendarMutex.Unlock()
+	marketCalendar = c
+}
+
+// LoadMarketCalendar reads the calendar from a toml file and uses it
+func LoadMarketCalendar(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	c, err := ParseMarketCalendar(data)
+	if err != nil {
+		return fmt.Errorf("market calendar %s: %s", path, err.Error())
+	}
+	SetMarketCalendar(c)
+	return nil
+}
+
+// ParseMarketCalendar reads a toml calendar. See DefaultMarketCalendar for
+// the format.
+func ParseMarketCalendar(data []byte) (*MarketCalendar, error) {
+	v := viper.New()
+	v.SetConfigType("toml")
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	c := new(MarketCalendar)
+	tz := v.GetString("timezone")
+	if tz == "" {
+		return nil, fmt.Errorf("timezone must be set")
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		// Without the timezone database, fall back to a fixed offset. The
+		// daylight saving shifts will be off by an hour.
+		offset := v.GetString("utcoffset")
+		if offset == "" {
+			return nil, err
+		}
+		t, perr := time.Parse("-07:00", offset)
+		if perr != nil {
+			return nil, fmt.Errorf("utcoffset '%s' must be like '-05:00'", offset)
+		}
+		_, secs := t.Zone()
+		dLog.WithError(err).Warnf("market calendar using a fixed utc offset of %s", offset)
+		loc = time.FixedZone(tz, secs)
+	}
+	c.Location = loc
+
+	if c.Forex, err = parseMarketHours(v, "forex"); err != nil {
+		return nil, err
+	}
+	if c.Commodity, err = parseMarketHours(v, "commodity"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func parseMarketHours(v *viper.Viper, market string) (h MarketHours, err error) {
+	if h.Open, err = parseWeekTime(v.GetString(market + ".open")); err != nil {
+		return h, fmt.Errorf("%s open: %s", market, err.Error())
+	}
+	if h.Close, err = parseWeekTime(v.GetString(market + ".close")); err != nil {
+		return h, fmt.Errorf("%s close: %s", market, err.Error())
+	}
+
+	if start := v.GetString(market + ".breakstart"); start != "" {
+		if h.BreakStart, err = parseClock(start); err != nil {
+			return h, fmt.Errorf("%s break start: %s", market, err.Error())
+		}
+		if h.BreakEnd, err = parseClock(v.GetString(market + ".breakend")); err != nil {
+			return h, fmt.Errorf("%s break end: %s", market, err.Error())
+		}
+	}
+
+	h.Holidays = make(map[string]bool)
+	for _, day := range v.GetStringSlice(market + ".holidays") {
+		t, err := time.Parse("2006-01-02", day)
+		if err != nil {
+			return h, fmt.Errorf("%s holiday '%s' must be YYYY-MM-DD", market, day)
+		}
+		h.Holidays[day] = true
+		if t.Year() > h.LastYear {
+			h.LastYear = t.Year()
+		}
+	}
+	return h, nil
+}
+
+// parseWeekTime parses "Sunday 17:00" into minutes since Sunday 00:00
+func parseWeekTime(s string) (int, error) {
+	parts := strings.Fields(s)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("'%s' must be like 'Sunday 17:00'", s)
+	}
+
+	day := -1
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(d.String(), parts[0]) {
+			day = int(d)
+		}
+	}
+	if day == -1 {
+		return 0, fmt.Errorf("'%s' is not a weekday", parts[0])
+	}
+
+	clock, err := parseClock(parts[1])
+	if err != nil {
+		return 0, err
+	}
+	return day*24*60 + clock, nil
+}
+
+// parseClock parses "17:00" into minutes since 00:00
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' must be like '17:00'", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// IsOpen returns if the market is open at the time. The time should already
+// be in the market's timezone.
+func (h MarketHours) IsOpen(local time.Time) bool {
+	if h.Holidays[local.Format("2006-01-02")] {
+		return false
+	}
+
+	clock := local.Hour()*60 + local.Minute()
+	if h.BreakStart != h.BreakEnd && clock >= h.BreakStart && clock < h.BreakEnd {
+		return false
+	}
+
+	week := int(local.Weekday())*24*60 + clock
+	if h.Open < h.Close {
+		return week >= h.Open && week < h.Close
+	}
+	// The week wraps past Saturday
+	return week >= h.Open || week < h.Close
+}
+
+// ForexOpen returns if the forex markets are open
+func (c *MarketCalendar) ForexOpen(reference time.Time) bool {
+	local := reference.In(c.Location)
+	c.checkYear(local.Year())
+	return c.Forex.IsOpen(local)
+}
+
+// CommodityOpen returns if the commodity markets are open
+func (c *MarketCalendar) CommodityOpen(reference time.Time) bool {
+	local := reference.In(c.Location)
+	c.checkYear(local.Year())
+	return c.Commodity.IsOpen(local)
+}
+
+// Outdated returns the markets that list holidays, but none for the year.
+// The holidays are added by hand, so a calendar past its last year will
+// think the markets are open on them.
+func (c *MarketCalendar) Outdated(year int) []string {
+	var markets []string
+	if len(c.Forex.Holidays) > 0 && c.Forex.LastYear < year {
+		markets = append(markets, "forex")
+	}
+	if len(c.Commodity.Holidays) > 0 && c.Commodity.LastYear < year {
+		markets = append(markets, "commodity")
+	}
+	return markets
+}
+
+// checkYear warns once a year if the calendar has no holidays for the year
+func (c *MarketCalendar) checkYear(year int) {
+	c.warnMutex.Lock()
+	defer c.warnMutex.Unlock()
+	if c.warnedYear == year {
+		return
+	}
+	c.warnedYear = year
+
+	if markets := c.Outdated(year); len(markets) > 0 {
+		dLog.WithFields(log.Fields{"year": year, "markets": strings.Join(markets, ",")}).
+			Warnf("market calendar has no holidays for this year, set Polling.MarketCalendar to an updated calendar")
+	}
+}
@@ -0,0 +1,48 @@
+package polling
+
+// DefaultMarketCalendar is used unless a calendar file is set in the config.
+// To change it, copy it into a file and set Polling.MarketCalendar.
+//
+// All times are local to the timezone, so they follow daylight saving. The
+// utcoffset is only used if the timezone database is missing.
+// Markets open once a week, and can have a daily break. Holidays close the
+// market for the whole local day.
+const DefaultMarketCalendar = `
+timezone = "America/New_York"
+utcoffset = "-05:00"
+
+# https://www.forex.com/en-us/support/trading-hours/
+[forex]
+  open = "Sunday 17:00"
+  close = "Friday 17:00"
+  holidays = [
+    "2019-12-25", "2020-01-01",
+    "2020-12-25", "2021-01-01",
+    "2021-12-24", "2021-12-31",
+    "2022-12-26", "2023-01-02",
+    "2023-12-25", "2024-01-01",
+    "2024-12-25", "2025-01-01",
+    "2025-12-25", "2026-01-01",
+    "2026-12-25", "2027-01-01",
+    "2027-12-24", "2027-12-31",
+  ]
+
+# Metals on the CME Globex have a daily maintenance break
+[commodity]
+  open = "Sunday 18:00"
+  close = "Friday 17:00"
+  breakstart = "17:00"
+  breakend = "18:00"
+  # New Years, Good Friday, and Christmas
+  holidays = [
+    "2019-12-25",
+    "2020-01-01", "2020-04-10", "2020-12-25",
+    "2021-01-01", "2021-04-02", "2021-12-24",
+    "2022-04-15", "2022-12-26",
+    "2023-01-02", "2023-04-07", "2023-12-25",
+    "2024-01-01", "2024-03-29", "2024-12-25",
+    "2025-01-01", "2025-04-18", "2025-12-25",
+    "2026-01-01", "2026-04-03", "2026-12-25",
+    "2027-01-01", "2027-03-26", "2027-12-24",
+  ]
+`
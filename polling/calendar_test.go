@@ -0,0 +1,100 @@
+package polling_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/polling"
+)
+
+func TestMarketCalendar(t *testing.T) {
+	type IsOpenVec struct {
+		Asset     string
+		Reference string
+		Exp       bool
+	}
+
+	vecs := []IsOpenVec{
+		// Daylight saving. 5pm ET is 21:00 UTC in September, 22:00 in January
+		{Asset: "EUR", Reference: "20 Sep 19 21:30 UTC", Exp: false},
+		{Asset: "EUR", Reference: "17 Jan 20 21:30 UTC", Exp: true},
+		{Asset: "EUR", Reference: "17 Jan 20 22:00 UTC", Exp: false},
+		{Asset: "EUR", Reference: "19 Jan 20 21:30 UTC", Exp: false},
+		{Asset: "EUR", Reference: "19 Jan 20 22:00 UTC", Exp: true},
+
+		// Daily commodity break, 5pm to 6pm ET
+		{Asset: "XAU", Reference: "16 Sep 19 20:59 UTC", Exp: true},
+		{Asset: "XAU", Reference: "16 Sep 19 21:30 UTC", Exp: false},
+		{Asset: "XAU", Reference: "16 Sep 19 22:00 UTC", Exp: true},
+		{Asset: "EUR", Reference: "16 Sep 19 21:30 UTC", Exp: true},
+
+		// Holidays
+		{Asset: "EUR", Reference: "25 Dec 19 15:00 UTC", Exp: false},
+		{Asset: "XAU", Reference: "10 Apr 20 15:00 UTC", Exp: false},
+		{Asset: "EUR", Reference: "10 Apr 20 15:00 UTC", Exp: true},
+		{Asset: "XBT", Reference: "25 Dec 19 15:00 UTC", Exp: true},
+	}
+
+	for _, vec := range vecs {
+		open := IsMarketOpen(vec.Asset, silenceParse(vec.Reference))
+		if open != vec.Exp {
+			t.Errorf("%s %s: exp %t,found %t", vec.Asset, vec.Reference, vec.Exp, open)
+		}
+	}
+}
+
+func TestLoadMarketCalendar(t *testing.T) {
+	defer func() {
+		c, _ := ParseMarketCalendar([]byte(DefaultMarketCalendar))
+		SetMarketCalendar(c)
+	}()
+
+	dir, err := ioutil.TempDir("", "calendar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "calendar.toml")
+	err = ioutil.WriteFile(path, []byte(`
+timezone = "UTC"
+[forex]
+  open = "Monday 00:00"
+  close = "Friday 00:00"
+  holidays = ["2019-09-17"]
+[commodity]
+  open = "Sunday 00:00"
+  close = "Saturday 00:00"
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := LoadMarketCalendar(path); err != nil {
+		t.Fatal(err)
+	}
+	if ForexOpen(silenceParse("17 Sep 19 12:00 UTC")) {
+		t.Errorf("exp closed on the holiday")
+	}
+	if ForexOpen(silenceParse("20 Sep 19 12:00 UTC")) {
+		t.Errorf("exp closed on friday")
+	}
+	if !CommodityOpen(silenceParse("20 Sep 19 21:30 UTC")) {
+		t.Errorf("exp open with no break")
+	}
+
+	c := GetMarketCalendar()
+	if out := c.Outdated(2019); len(out) != 0 {
+		t.Errorf("exp the calendar to cover 2019, found %v", out)
+	}
+	// The commodity market has no holidays to miss
+	if out := c.Outdated(2020); len(out) != 1 || out[0] != "forex" {
+		t.Errorf("exp forex to be out of date in 2020, found %v", out)
+	}
+
+	if _, err := ParseMarketCalendar([]byte(`timezone = "UTC"`)); err == nil {
+		t.Errorf("exp an error for missing hours")
+	}
+}
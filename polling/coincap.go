@@ -6,7 +6,6 @@ package polling
 import (
 	"encoding/json"
 	"io/ioutil"
-	"net/http"
 	"strconv"
 	"strings"
 	"time"
@@ -157,7 +156,7 @@ func (d CoinCapDataSource) CallCoinCap() (CoinCapResponse, error) {
 	}
 
 	url := "http://api.coincap.io/v2/assets?ids=" + strings.Join(ids, ",")
-	resp, err := http.Get(url)
+	resp, err := NewHTTPClient().Get(url)
 	if err != nil {
 		log.WithError(err).Warning("Failed to get response from CoinCap")
 		return emptyResponse, err
@@ -0,0 +1,178 @@
+package polling
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Pricing modes for an asset
+const (
+	// PricingPriority takes the first good quote in the data source priority
+	// order.
+	PricingPriority = "priority"
+	// PricingConsensus queries all sources for the asset, drops the outliers,
+	// and averages the rest.
+	PricingConsensus = "consensus"
+)
+
+// Averages to use for the consensus price
+const (
+	ConsensusMedian      = "median"
+	ConsensusTrimmedMean = "trimmedmean"
+)
+
+// ErrNoConsensus is returned when the sources for a consensus asset do not
+// agree on a price
+var ErrNoConsensus = errors.New("no consensus found")
+
+// IsConsensusAsset returns if the asset is priced by consensus
+func (d *DataSources) IsConsensusAsset(asset string) bool {
+	return d.AssetPricing[asset] == PricingConsensus
+}
+
+// PullConsensusPrice queries every source for the asset, and rejects any
+// quote further than the max deviation from the median of all the quotes.
+// The remaining quotes are averaged, and the sources that contributed are
+// recorded on the price.
+func (d *DataSources) PullConsensusPrice(asset string, reference time.Time, sources map[string]IDataSource) (pa PegItem, err error) {
+	if sources == nil {
+		sources = d.DataSources
+	}
+
+	var quotes, stale []PegItem
+	for _, source := range d.AssetSources[asset] {
+		s, ok := sources[source]
+		if !ok {
+			err = fmt.Errorf("%s is not a configured datasource", source)
+			continue
+		}
+
+		var price PegItem
+		price, err = s.FetchPegPrice(asset)
+		if err != nil {
+			continue
+		}
+
+		if price.Value != 0 {
+			price.Sources = []string{source}
+			if d.IsStale(asset, price, reference) {
+				stale = append(stale, price)
+				continue
+			}
+			quotes = append(quotes, price)
+		}
+	}
+
+	// Only use stale quotes if that is all we have
+	quotes = d.useStale(asset, quotes, stale)
+
+	if len(quotes) == 0 {
+		if err != nil {
+			return pa, err
+		}
+		if asset == "PEG" {
+			return pa, nil
+		}
+		return pa, fmt.Errorf("'%s' doesn't seem to have any datasources configured. "+
+			"please check your config file to ensure a datasource exists for this asset", asset)
+	}
+
+	price, rejected, err := ConsensusPrice(quotes, d.consensusDeviation, d.consensusAverage)
+	for _, r := range rejected {
+		dLog.WithFields(log.Fields{
+			"asset":  asset,
+			"source": strings.Join(r.Sources, ","),
+			"value":  r.Value,
+			"price":  price.Value,
+		}).Warnf("datasource quote rejected as an outlier")
+	}
+	if err != nil {
+		return pa, fmt.Errorf("%w for %s", err, asset)
+	}
+	return price, nil
+}
+
+// ConsensusPrice takes the quotes from many sources and drops any quote further
+// than the deviation from the median. The deviation is a ratio, so 0.05 allows
+// quotes within 5% of the median. The price is either the median or the trimmed
+// mean of the quotes that are left. Each quote should have its source set, and
+// the quotes should be in priority order.
+//
+// Only the quotes used in the average are in the price's sources. If every
+// quote is dropped, like 2 sources that disagree, ErrNoConsensus is returned.
+// The quotes rejected are returned.
+func ConsensusPrice(quotes []PegItem, deviation float64, average string) (price PegItem, rejected []PegItem, err error) {
+	if len(quotes) == 0 {
+		return price, nil, ErrNoConsensus
+	}
+
+	med := mean(middle(quotes, ConsensusMedian))
+	var kept []PegItem
+	for _, q := range quotes {
+		if med != 0 && math.Abs(q.Value-med)/med > deviation {
+			rejected = append(rejected, q)
+			continue
+		}
+		kept = append(kept, q)
+	}
+	if len(kept) == 0 {
+		return price, rejected, ErrNoConsensus
+	}
+
+	used := middle(kept, average)
+	price.Value = mean(used)
+
+	// The price is only as fresh as the oldest quote in it
+	price.When = used[0].When
+	for _, q := range used {
+		if q.When.Before(price.When) {
+			price.When = q.When
+		}
+		price.Sources = append(price.Sources, q.Sources...)
+	}
+	price.WhenUnix = price.When.Unix()
+	return price, rejected, nil
+}
+
+// middle returns the quotes the average is made from, in priority order. The
+// median is the middle quote, or the middle 2 for an even number. The trimmed
+// mean drops the highest and lowest quote once there are at least 3. Unlike
+// TrimmedMean, 2 quotes are averaged rather than using the first, so one bad
+// source can not set the price.
+func middle(quotes []PegItem, average string) []PegItem {
+	order := make([]int, len(quotes))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return quotes[order[i]].Value < quotes[order[j]].Value })
+
+	trim := (len(quotes) - 1) / 2
+	if average == ConsensusTrimmedMean {
+		trim = 0
+		if len(quotes) >= 3 {
+			trim = 1
+		}
+	}
+	order = order[trim : len(order)-trim]
+
+	sort.Ints(order)
+	used := make([]PegItem, len(order))
+	for i, o := range order {
+		used[i] = quotes[o]
+	}
+	return used
+}
+
+func mean(quotes []PegItem) float64 {
+	var sum float64
+	for _, q := range quotes {
+		sum += q.Value
+	}
+	return sum / float64(len(quotes))
+}
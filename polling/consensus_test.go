@@ -0,0 +1,145 @@
+package polling_test
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/polling"
+	"github.com/spf13/viper"
+)
+
+func TestConsensusPrice(t *testing.T) {
+	now := time.Now()
+	quote := func(source string, v float64, age time.Duration) PegItem {
+		return PegItem{Value: v, When: now.Add(-age), Sources: []string{source}}
+	}
+
+	quotes := []PegItem{
+		quote("a", 100, 0),
+		quote("b", 101, time.Minute),
+		quote("c", 99, 0),
+		quote("d", 102, 0),
+		quote("e", 150, 0), // Outlier
+	}
+
+	t.Run("median", func(t *testing.T) {
+		p, rejected, err := ConsensusPrice(quotes, 0.05, ConsensusMedian)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rejected) != 1 || rejected[0].Sources[0] != "e" {
+			t.Errorf("exp e to be rejected, found %v", rejected)
+		}
+		// The middle 2 of 99, 100, 101, 102
+		if p.Value != 100.5 {
+			t.Errorf("exp price 100.5, found %.4f", p.Value)
+		}
+		if fmt.Sprintf("%v", p.Sources) != "[a b]" {
+			t.Errorf("exp sources [a b], found %v", p.Sources)
+		}
+		if !p.When.Equal(now.Add(-time.Minute)) {
+			t.Errorf("exp the time of the oldest quote")
+		}
+	})
+
+	t.Run("trimmed mean", func(t *testing.T) {
+		p, _, err := ConsensusPrice(quotes, 0.05, ConsensusTrimmedMean)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Value != 100.5 {
+			t.Errorf("exp price 100.5, found %.4f", p.Value)
+		}
+		if fmt.Sprintf("%v", p.Sources) != "[a b]" {
+			t.Errorf("exp sources [a b], found %v", p.Sources)
+		}
+	})
+
+	t.Run("two quotes", func(t *testing.T) {
+		p, rejected, err := ConsensusPrice([]PegItem{quote("a", 10, 0), quote("b", 10.4, 0)}, 0.05, ConsensusTrimmedMean)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rejected) != 0 || p.Value != 10.2 {
+			t.Errorf("exp the mean of 10.2 with none rejected, found %.4f and %v", p.Value, rejected)
+		}
+		if fmt.Sprintf("%v", p.Sources) != "[a b]" {
+			t.Errorf("exp sources [a b], found %v", p.Sources)
+		}
+	})
+
+	t.Run("bad priority source", func(t *testing.T) {
+		// Neither can be trusted over the other
+		_, rejected, err := ConsensusPrice([]PegItem{quote("a", 1000, 0), quote("b", 10, 0)}, 0.05, ConsensusMedian)
+		if err != ErrNoConsensus {
+			t.Errorf("exp no consensus, found %v", err)
+		}
+		if len(rejected) != 2 {
+			t.Errorf("exp both quotes to be rejected, found %v", rejected)
+		}
+	})
+
+	t.Run("no consensus", func(t *testing.T) {
+		split := []PegItem{quote("a", 1, 0), quote("b", 1, 0), quote("c", 3, 0), quote("d", 3, 0)}
+		_, rejected, err := ConsensusPrice(split, 0.05, ConsensusMedian)
+		if err != ErrNoConsensus {
+			t.Errorf("exp no consensus, found %v", err)
+		}
+		if len(rejected) != 4 {
+			t.Errorf("exp every quote to be rejected, found %v", rejected)
+		}
+	})
+}
+
+func TestPullConsensusPrice(t *testing.T) {
+	values := map[int]float64{1: 1, 2: 1.02, 3: 2}
+	NewTestingDataSource = func(conf *viper.Viper, source string) (IDataSource, error) {
+		s := new(UnitTestDataSource)
+		v, err := strconv.Atoi(string(source[8]))
+		if err != nil {
+			panic(err)
+		}
+		s.Value = values[v]
+		s.Assets = AllAssets
+		s.SourceName = fmt.Sprintf("UnitTest%d", v)
+		return s, nil
+	}
+
+	conf := GetConfig(`
+[polling]
+  pricingmode = "consensus"
+[oracleassetpricing]
+  usd = "priority"
+[oracledatasources]
+  UnitTest1 = 1
+  UnitTest2 = 2
+  UnitTest3 = 3
+`)
+
+	s := NewDataSources(conf, false)
+	if !s.IsConsensusAsset("XBT") || s.IsConsensusAsset("USD") {
+		t.Fatalf("pricing modes not loaded")
+	}
+
+	p, err := s.PullBestPrice("XBT", time.Now(), nil, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(p.Value-1.01) > 1e-9 {
+		t.Errorf("exp consensus of 1.01, found %.4f", p.Value)
+	}
+	if fmt.Sprintf("%v", p.Sources) != "[UnitTest1 UnitTest2]" {
+		t.Errorf("exp UnitTest3 to be left out, found %v", p.Sources)
+	}
+
+	p, err = s.PullBestPrice("USD", time.Now(), nil, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Value != 1 || fmt.Sprintf("%v", p.Sources) != "[UnitTest1]" {
+		t.Errorf("exp USD priced by priority, found %.4f from %v", p.Value, p.Sources)
+	}
+}
@@ -3,6 +3,7 @@ package polling
 import (
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -53,6 +54,9 @@ type TimedDataSourceCache struct {
 	LastCall      time.Time
 	CacheDuration time.Duration
 	Cache         PegAssets
+
+	// A fetch that timed out can still be running when the next pull starts
+	sync.Mutex
 }
 
 func NewTimedDataSourceCache(s IDataSource, cacheLength time.Duration) IDataSource {
@@ -64,6 +68,8 @@ func NewTimedDataSourceCache(s IDataSource, cacheLength time.Duration) IDataSour
 }
 
 func (d *TimedDataSourceCache) FetchPegPrices() (peg PegAssets, err error) {
+	d.Lock()
+	defer d.Unlock()
 	if d.Cache != nil {
 		// If the cache is set, check the time passed
 		if time.Since(d.LastCall) < d.CacheDuration {
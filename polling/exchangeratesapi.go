@@ -6,7 +6,6 @@ package polling
 import (
 	"encoding/json"
 	"io/ioutil"
-	"net/http"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -72,7 +71,7 @@ func CallExchangeRatesAPI() (ExchangeRatesAPIResponse, error) {
 	var exchangeRatesAPIResponse ExchangeRatesAPIResponse
 	var emptyAPIResponse ExchangeRatesAPIResponse
 
-	resp, err := http.Get("https://api.exchangeratesapi.io/latest?base=USD")
+	resp, err := NewHTTPClient().Get("https://api.exchangeratesapi.io/latest?base=USD")
 	if err != nil {
 		log.WithError(err).Warning("Failed to get response from ExchangeRatesAPI")
 		return emptyAPIResponse, err
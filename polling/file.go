@@ -0,0 +1,172 @@
+package polling
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	"github.com/spf13/viper"
+)
+
+// FileDataSource reads prices from a local json or toml file. It is for
+// private testnets without internet access, and for manual overrides when a
+// datasource is giving bad prices. Only the assets in the file are returned,
+// so at a high priority it overrides just those assets, and the rest fall
+// through to the next datasource.
+//
+// The file is reloaded whenever it changes. Each asset is either just the
+// price, or the price with the time it was set. Without a time, the file's
+// modified time is used, so an old file will be treated as stale.
+//	{
+//	  "XBT": 8122.16,
+//	  "EUR": {"value": 1.11, "when": "2020-01-14T00:32:39Z"}
+//	}
+type FileDataSource struct {
+	path string
+
+	sync.Mutex
+	modTime time.Time
+	size    int64
+	prices  PegAssets
+}
+
+func NewFileDataSource(conf *viper.Viper) (*FileDataSource, error) {
+	s := new(FileDataSource)
+	s.path = conf.GetString(config.ConfigOraclePriceFile)
+	if s.path == "" {
+		return nil, fmt.Errorf("the File datasource needs a price file set in the config")
+	}
+
+	// Make sure the file is good on boot
+	if _, err := s.FetchPegPrices(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (d *FileDataSource) Name() string {
+	return "File"
+}
+
+func (d *FileDataSource) Url() string {
+	return d.path
+}
+
+// SupportedPegs is all assets, as the file can be changed while running.
+// Assets not in the file are not found.
+func (d *FileDataSource) SupportedPegs() []string {
+	return AllAssets
+}
+
+func (d *FileDataSource) FetchPegPrices() (peg PegAssets, err error) {
+	d.Lock()
+	defer d.Unlock()
+
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only reload if the file changed
+	if d.prices == nil || !info.ModTime().Equal(d.modTime) || info.Size() != d.size {
+		prices, err := ParsePriceFile(d.path, info.ModTime())
+		if err != nil {
+			return nil, err
+		}
+		d.prices = prices
+		d.modTime = info.ModTime()
+		d.size = info.Size()
+	}
+
+	peg = make(PegAssets)
+	for k, v := range d.prices {
+		peg[k] = v
+	}
+	return peg, nil
+}
+
+func (d *FileDataSource) FetchPegPrice(peg string) (i PegItem, err error) {
+	return FetchPegPrice(peg, d.FetchPegPrices)
+}
+
+// ParsePriceFile reads the prices from a json or toml file. Any price without
+// a time is given the default time.
+func ParsePriceFile(path string, defaultTime time.Time) (PegAssets, error) {
+	v := viper.New()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		v.SetConfigType("json")
+	case ".toml":
+		v.SetConfigType("toml")
+	default:
+		return nil, fmt.Errorf("price file must be .json or .toml, found %s", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := v.ReadConfig(f); err != nil {
+		return nil, fmt.Errorf("price file %s: %s", path, err.Error())
+	}
+
+	prices := make(PegAssets)
+	for key := range v.AllSettings() {
+		asset := correctAssetCasing(key)
+		if asset == "" {
+			return nil, fmt.Errorf("price file %s has unknown asset %s", path, key)
+		}
+
+		item := PegItem{When: defaultTime}
+		if v.IsSet(key + ".value") {
+			item.Value = v.GetFloat64(key + ".value")
+			if when := v.Get(key + ".when"); when != nil {
+				item.When, err = priceFileTime(when)
+				if err != nil {
+					return nil, fmt.Errorf("price file %s, %s: %s", path, asset, err.Error())
+				}
+			}
+		} else {
+			item.Value = v.GetFloat64(key)
+		}
+
+		if item.Value <= 0 {
+			return nil, fmt.Errorf("price file %s, %s must have a price above 0", path, asset)
+		}
+		item.WhenUnix = item.When.Unix()
+		prices[asset] = item
+	}
+
+	return prices, nil
+}
+
+// priceFileTime handles toml dates, RFC3339 strings, and unix seconds
+func priceFileTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case string:
+		if parsed, err := time.Parse(time.RFC3339, t); err == nil {
+			return parsed, nil
+		}
+		unix, err := strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("'%s' is not a time", t)
+		}
+		return time.Unix(unix, 0), nil
+	case int64:
+		return time.Unix(t, 0), nil
+	case int:
+		return time.Unix(int64(t), 0), nil
+	case float64:
+		return time.Unix(int64(t), 0), nil
+	}
+	return time.Time{}, fmt.Errorf("%v is not a time", v)
+}
@@ -0,0 +1,157 @@
+package polling_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/polling"
+	"github.com/spf13/viper"
+)
+
+func TestFileDataSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "prices.json")
+	write := func(content string, mod time.Time) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mod := time.Now().Add(-time.Minute).Truncate(time.Second)
+	write(`{"XBT": 8000, "eur": {"value": 1.11, "when": "2020-01-14T00:32:39Z"}}`, mod)
+
+	s, err := NewDataSource("file", GetConfig(fmt.Sprintf(`
+[oracle]
+  pricefile = "%s"
+`, path)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pegs, err := s.FetchPegPrices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pegs["XBT"].Value != 8000 || !pegs["XBT"].When.Equal(mod) {
+		t.Errorf("XBT exp 8000 at the file time, found %.2f at %s", pegs["XBT"].Value, pegs["XBT"].When)
+	}
+	if pegs["EUR"].Value != 1.11 || pegs["EUR"].WhenUnix != 1578961959 {
+		t.Errorf("EUR exp 1.11 at its own time, found %.2f at %d", pegs["EUR"].Value, pegs["EUR"].WhenUnix)
+	}
+
+	// Changing the file reloads it
+	write(`{"XBT": 9000}`, mod.Add(time.Second))
+	if p, err := s.FetchPegPrice("XBT"); err != nil || p.Value != 9000 {
+		t.Errorf("exp reloaded price 9000, found %.2f, %v", p.Value, err)
+	}
+	if _, err := s.FetchPegPrice("EUR"); err == nil {
+		t.Errorf("exp EUR to be removed")
+	}
+}
+
+func TestParsePriceFileToml(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "prices.toml")
+	err = ioutil.WriteFile(path, []byte(`
+usd = 1
+[xau]
+  value = 1550.5
+  when = 2020-01-14T00:32:39Z
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pegs, err := ParsePriceFile(path, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pegs["USD"].Value != 1 || pegs["XAU"].Value != 1550.5 || pegs["XAU"].WhenUnix != 1578961959 {
+		t.Errorf("toml prices not parsed, %v", pegs)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`bad = 1`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParsePriceFile(path, time.Now()); err == nil {
+		t.Errorf("exp an error for an unknown asset")
+	}
+}
+
+// TestStaleQuotes has a stale source at priority 1. The fresh source at
+// priority 2 should be used, unless it does not have the asset.
+func TestStaleQuotes(t *testing.T) {
+	NewTestingDataSource = func(conf *viper.Viper, source string) (IDataSource, error) {
+		s := new(UnitTestDataSource)
+		s.SourceName = source
+		s.Value = 1
+		s.Assets = AllAssets
+		if source == "unittest2" {
+			s.Value = 2
+			s.Assets = []string{"XBT"}
+		}
+		return &staleDataSource{UnitTestDataSource: s, stale: source == "unittest1"}, nil
+	}
+
+	conf := GetConfig(`
+[polling]
+  staleduration = "10m"
+[oracledatasources]
+  UnitTest1 = 1
+  UnitTest2 = 2
+`)
+	s := NewDataSources(conf, false)
+
+	p, err := s.PullBestPrice("XBT", time.Now(), nil, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Value != 2 {
+		t.Errorf("exp the fresh quote, found %.2f", p.Value)
+	}
+
+	p, err = s.PullBestPrice("ETH", time.Now(), nil, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Value != 1 {
+		t.Errorf("exp the stale quote as the only quote, found %.2f", p.Value)
+	}
+}
+
+type staleDataSource struct {
+	*UnitTestDataSource
+	stale bool
+}
+
+func (d *staleDataSource) FetchPegPrices() (PegAssets, error) {
+	peg, err := d.UnitTestDataSource.FetchPegPrices()
+	if d.stale {
+		for k, v := range peg {
+			v.When = v.When.Add(-time.Hour)
+			peg[k] = v
+		}
+	}
+	return peg, err
+}
+
+func (d *staleDataSource) FetchPegPrice(peg string) (PegItem, error) {
+	return FetchPegPrice(peg, d.FetchPegPrices)
+}
@@ -0,0 +1,368 @@
+package polling
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// JSONDataSource is a datasource configured entirely in the config file. It
+// can be pointed at any http api that returns json. An example config:
+//
+//	[oracledatasources]
+//	  myexchange = 2
+//
+//	[jsondatasources.myexchange]
+//	  url = "https://api.example.com/ticker/{{.Symbol}}-USD"
+//	  timestamp = "data.time"
+//	  [jsondatasources.myexchange.headers]
+//	    X-Api-Key = "CHANGEME"
+//	  [jsondatasources.myexchange.assets]
+//	    xbt = { symbol = "BTC", path = "data.price" }
+//	    eur = { symbol = "EUR", path = "data.rate", inverse = true }
+//
+// Assets that render to the same url share a single request.
+type JSONDataSource struct {
+	name    string
+	url     string
+	urlTmpl *template.Template
+	headers map[string]string
+
+	// timestamp is the path to the time of the quote. If it is not set, the
+	// time of the request is used.
+	timestamp string
+
+	assets []JSONAsset
+}
+
+// JSONAsset is how to find a single asset's price in the json response
+type JSONAsset struct {
+	Asset string
+	// Symbol is passed into the url template. Defaults to the asset.
+	Symbol string
+	// Path is the selector for the price, like "data.prices[0].last"
+	Path string
+	// Inverse is for apis that quote USD in the asset, rather than the asset
+	// in USD.
+	Inverse bool
+	// Timestamp overrides the datasource timestamp path for the asset
+	Timestamp string
+}
+
+// IsJSONDataSource returns if the source is configured as a json datasource
+func IsJSONDataSource(conf *viper.Viper, source string) bool {
+	return conf.Sub("jsondatasources."+strings.ToLower(source)) != nil
+}
+
+func NewJSONDataSource(conf *viper.Viper, source string) (*JSONDataSource, error) {
+	s := new(JSONDataSource)
+	sub := conf.Sub("jsondatasources." + strings.ToLower(source))
+	if sub == nil {
+		return nil, fmt.Errorf("no json datasource config found for %s", source)
+	}
+
+	s.name = source
+	if name := sub.GetString("name"); name != "" {
+		s.name = name
+	}
+
+	s.url = sub.GetString("url")
+	if s.url == "" {
+		return nil, fmt.Errorf("json datasource %s has no url", source)
+	}
+	tmpl, err := template.New(source).Option("missingkey=error").Parse(s.url)
+	if err != nil {
+		return nil, fmt.Errorf("json datasource %s url template: %s", source, err.Error())
+	}
+	s.urlTmpl = tmpl
+	s.headers = sub.GetStringMapString("headers")
+	s.timestamp = sub.GetString("timestamp")
+
+	for key := range sub.GetStringMap("assets") {
+		asset := correctAssetCasing(key)
+		if asset == "" {
+			return nil, fmt.Errorf("json datasource %s has unknown asset %s", source, key)
+		}
+
+		a := JSONAsset{Asset: asset, Symbol: asset}
+		if path := sub.GetString("assets." + key); path != "" {
+			// Shorthand, the asset is just the path
+			a.Path = path
+		} else {
+			a.Path = sub.GetString("assets." + key + ".path")
+			a.Inverse = sub.GetBool("assets." + key + ".inverse")
+			a.Timestamp = sub.GetString("assets." + key + ".timestamp")
+			if symbol := sub.GetString("assets." + key + ".symbol"); symbol != "" {
+				a.Symbol = symbol
+			}
+		}
+
+		if a.Path == "" {
+			return nil, fmt.Errorf("json datasource %s has no path for %s", source, asset)
+		}
+		s.assets = append(s.assets, a)
+	}
+
+	if len(s.assets) == 0 {
+		return nil, fmt.Errorf("json datasource %s has no assets", source)
+	}
+
+	// Keep the assets in a consistent order
+	var ordered []JSONAsset
+	for _, asset := range AllAssets {
+		for _, a := range s.assets {
+			if a.Asset == asset {
+				ordered = append(ordered, a)
+			}
+		}
+	}
+	s.assets = ordered
+
+	return s, nil
+}
+
+func (d *JSONDataSource) Name() string {
+	return d.name
+}
+
+func (d *JSONDataSource) Url() string {
+	return d.url
+}
+
+func (d *JSONDataSource) SupportedPegs() []string {
+	pegs := make([]string, len(d.assets))
+	for i, a := range d.assets {
+		pegs[i] = a.Asset
+	}
+	return pegs
+}
+
+func (d *JSONDataSource) FetchPegPrices() (peg PegAssets, err error) {
+	peg = make(map[string]PegItem)
+
+	// Only make 1 call per url
+	responses := make(map[string]interface{})
+	for _, a := range d.assets {
+		u, err := d.AssetUrl(a)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, ok := responses[u]
+		if !ok {
+			resp, err = d.Call(u)
+			if err != nil {
+				return nil, err
+			}
+			responses[u] = resp
+		}
+
+		item, err := d.ParseAsset(a, resp)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", a.Asset, err.Error())
+		}
+		peg[a.Asset] = item
+	}
+
+	return
+}
+
+func (d *JSONDataSource) FetchPegPrice(peg string) (i PegItem, err error) {
+	return FetchPegPrice(peg, d.FetchPegPrices)
+}
+
+// AssetUrl fills in the url template for the asset
+func (d *JSONDataSource) AssetUrl(a JSONAsset) (string, error) {
+	var buf bytes.Buffer
+	err := d.urlTmpl.Execute(&buf, a)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Call makes the request and decodes the json
+func (d *JSONDataSource) Call(u string) (interface{}, error) {
+	client := NewHTTPClient()
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range d.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d", d.name, resp.StatusCode)
+	}
+
+	return ParseJSONResponse(data)
+}
+
+// ParseAsset pulls the price and time for the asset out of the response
+func (d *JSONDataSource) ParseAsset(a JSONAsset, resp interface{}) (PegItem, error) {
+	var item PegItem
+	v, err := SelectJSON(resp, a.Path)
+	if err != nil {
+		return item, err
+	}
+
+	item.Value, err = jsonFloat(v)
+	if err != nil {
+		return item, err
+	}
+	if a.Inverse {
+		if item.Value == 0 {
+			return item, fmt.Errorf("cannot inverse a price of 0")
+		}
+		item.Value = 1 / item.Value
+	}
+
+	timestamp := time.Now()
+	tsPath := d.timestamp
+	if a.Timestamp != "" {
+		tsPath = a.Timestamp
+	}
+	if tsPath != "" {
+		ts, err := SelectJSON(resp, tsPath)
+		if err != nil {
+			return item, err
+		}
+		timestamp, err = jsonTime(ts)
+		if err != nil {
+			return item, err
+		}
+	}
+
+	item.When = timestamp
+	item.WhenUnix = timestamp.Unix()
+	return item, nil
+}
+
+// ParseJSONResponse decodes the json keeping the numbers exact
+func ParseJSONResponse(data []byte) (interface{}, error) {
+	var resp interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// SelectJSON walks the decoded json with a simple JSONPath style selector.
+// Objects are walked with '.', arrays with '[n]'. A leading '$' is optional.
+//	Eg: "$.data.prices[0].last"
+func SelectJSON(data interface{}, path string) (interface{}, error) {
+	path = strings.TrimPrefix(path, "$")
+	path = strings.TrimPrefix(path, ".")
+	if path == "" {
+		return data, nil
+	}
+
+	current := data
+	for _, segment := range strings.Split(path, ".") {
+		// Pull off any indexes. "prices[0][1]" -> "prices", [0, 1]
+		key := segment
+		var indexes []int
+		if i := strings.Index(segment, "["); i != -1 {
+			key = segment[:i]
+			for _, idx := range strings.Split(segment[i+1:], "[") {
+				if !strings.HasSuffix(idx, "]") {
+					return nil, fmt.Errorf("bad index in '%s'", segment)
+				}
+				n, err := strconv.Atoi(strings.TrimSuffix(idx, "]"))
+				if err != nil {
+					return nil, fmt.Errorf("bad index in '%s'", segment)
+				}
+				indexes = append(indexes, n)
+			}
+		}
+
+		if key != "" {
+			obj, ok := current.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("'%s' is not an object", key)
+			}
+			current, ok = obj[key]
+			if !ok {
+				return nil, fmt.Errorf("'%s' not found", key)
+			}
+		}
+
+		for _, n := range indexes {
+			arr, ok := current.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("'%s' is not an array", segment)
+			}
+			if n < 0 || n >= len(arr) {
+				return nil, fmt.Errorf("'%s' index out of range", segment)
+			}
+			current = arr[n]
+		}
+	}
+
+	return current, nil
+}
+
+func jsonFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case json.Number:
+		return n.Float64()
+	case float64:
+		return n, nil
+	case string:
+		// Some apis quote their numbers
+		return strconv.ParseFloat(n, 64)
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+// jsonTime handles unix seconds, unix milliseconds, and RFC3339 strings
+func jsonTime(v interface{}) (time.Time, error) {
+	if s, ok := v.(string); ok {
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			return t, nil
+		}
+	}
+
+	f, err := jsonFloat(v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%v is not a timestamp", v)
+	}
+	if f > 1e12 {
+		// Milliseconds
+		return time.Unix(0, int64(f)*int64(time.Millisecond)), nil
+	}
+	return time.Unix(int64(f), 0), nil
+}
+
+// correctAssetCasing returns the asset as we know it, or "" if it is not
+// an asset.
+func correctAssetCasing(asset string) string {
+	for _, a := range AllAssets {
+		if strings.EqualFold(a, asset) {
+			return a
+		}
+	}
+	return ""
+}
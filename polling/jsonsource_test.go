@@ -0,0 +1,107 @@
+package polling_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/polling"
+)
+
+func TestJSONDataSource(t *testing.T) {
+	defer func() { NewHTTPClient = func() *http.Client { return &http.Client{} } }()
+
+	var calls int
+	responses := map[string]string{
+		"/ticker/BTC-USD": `{"data":{"price":"8122.16","time":1578961554}}`,
+		"/ticker/ETH-USD": `{"data":{"price":143.88,"time":"2020-01-14T00:32:39Z"}}`,
+		"/rates":          `{"rates":[{"EUR":0.9}],"ts":1578961554000}`,
+	}
+	NewHTTPClient = func() *http.Client {
+		return NewTestClient(func(req *http.Request) *http.Response {
+			calls++
+			if req.Header.Get("X-Api-Key") != "secret" {
+				t.Errorf("header not set")
+			}
+			return &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(responses[req.URL.Path])),
+				Header:     make(http.Header),
+			}
+		})
+	}
+
+	conf := GetConfig(`
+[oracledatasources]
+  myexchange = 1
+
+[jsondatasources.myexchange]
+  url = "https://api.example.com/{{if eq .Asset \"EUR\"}}rates{{else}}ticker/{{.Symbol}}-USD{{end}}"
+  timestamp = "data.time"
+  [jsondatasources.myexchange.headers]
+    X-Api-Key = "secret"
+  [jsondatasources.myexchange.assets]
+    xbt = { symbol = "BTC", path = "data.price" }
+    eth = "$.data.price"
+    eur = { path = "rates[0].EUR", inverse = true, timestamp = "ts" }
+`)
+
+	s, err := NewDataSource("myexchange", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pegs, err := s.FetchPegPrices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("exp 3 calls, found %d", calls)
+	}
+
+	exp := map[string]float64{"XBT": 8122.16, "ETH": 143.88, "EUR": 1 / 0.9}
+	for asset, v := range exp {
+		if pegs[asset].Value != v {
+			t.Errorf("%s exp %f, found %f", asset, v, pegs[asset].Value)
+		}
+	}
+	if pegs["XBT"].WhenUnix != 1578961554 || pegs["EUR"].WhenUnix != 1578961554 || pegs["ETH"].WhenUnix != 1578961959 {
+		t.Errorf("timestamps not parsed, %d %d %d", pegs["XBT"].WhenUnix, pegs["EUR"].WhenUnix, pegs["ETH"].WhenUnix)
+	}
+}
+
+func TestSelectJSON(t *testing.T) {
+	data, err := ParseJSONResponse([]byte(`{"a":{"b":[1,[2,3]]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type Vector struct {
+		Path  string
+		Exp   string
+		Error bool
+	}
+	vects := []Vector{
+		{Path: "a.b[0]", Exp: "1"},
+		{Path: "$.a.b[1][1]", Exp: "3"},
+		{Path: "a.c", Error: true},
+		{Path: "a.b[5]", Error: true},
+		{Path: "a[0]", Error: true},
+	}
+
+	for _, v := range vects {
+		r, err := SelectJSON(data, v.Path)
+		if v.Error {
+			if err == nil {
+				t.Errorf("%s exp an error", v.Path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", v.Path, err)
+		} else if s, _ := r.(interface{ String() string }); s == nil || s.String() != v.Exp {
+			t.Errorf("%s exp %s, found %v", v.Path, v.Exp, r)
+		}
+	}
+}
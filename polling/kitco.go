@@ -6,7 +6,6 @@ package polling
 import (
 	"errors"
 	"io/ioutil"
-	"net/http"
 	"strconv"
 	"strings"
 	"time"
@@ -116,7 +115,7 @@ func CallKitcoWeb() (KitcoData, error) {
 	var kData KitcoData
 	var emptyData KitcoData
 
-	resp, err := http.Get("https://www.kitco.com/market/")
+	resp, err := NewHTTPClient().Get("https://www.kitco.com/market/")
 	if err != nil {
 		log.WithError(err).Warning("Failed to get response from Kitco")
 		return emptyData, err
@@ -7,7 +7,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"time"
 
 	"github.com/FactomWyomingEntity/prosper-pool/config"
@@ -90,7 +89,7 @@ func (d *OpenExchangeRatesDataSource) CallOpenExchangeRates() (response OpenExch
 	var openExchangeRatesResponse OpenExchangeRatesResponse
 	var emptyResponse OpenExchangeRatesResponse
 
-	resp, err := http.Get("https://openexchangerates.org/api/latest.json?app_id=" + d.apikey)
+	resp, err := NewHTTPClient().Get("https://openexchangerates.org/api/latest.json?app_id=" + d.apikey)
 	if err != nil {
 		log.WithError(err).Warning("Failed to get response from OpenExchangeRates")
 		return emptyResponse, err
@@ -25,6 +25,8 @@ type PegItem struct {
 	Value    float64
 	WhenUnix int64 // unix timestamp
 	When     time.Time
+	// Sources are the data sources that the price came from
+	Sources []string
 }
 
 func (p PegItem) Clone(randomize float64) PegItem {
@@ -32,5 +34,6 @@ func (p PegItem) Clone(randomize float64) PegItem {
 	np.Value = p.Value + p.Value*(randomize/2*rand.Float64()) - p.Value*(randomize/2*rand.Float64())
 	np.Value = TruncateTo8(np.Value)
 	np.WhenUnix = p.WhenUnix
+	np.Sources = p.Sources
 	return *np
 }
@@ -0,0 +1,77 @@
+package polling_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/polling"
+)
+
+func TestRecordReplay(t *testing.T) {
+	defer func() { NewHTTPClient = func() *http.Client { return &http.Client{} } }()
+
+	dir, err := ioutil.TempDir("", "recordings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"path":"%s"}`, r.URL.Path)
+	}))
+
+	get := func(path string) (string, error) {
+		resp, err := NewHTTPClient().Get(srv.URL + path)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(resp.Body)
+		return string(data), err
+	}
+
+	if err := SetHTTPMode(HTTPRecord, dir); err != nil {
+		t.Fatal(err)
+	}
+	body, err := get("/prices?apikey=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != `{"path":"/prices"}` {
+		t.Errorf("recording changed the body: %s", body)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("exp 1 recording, found %d", len(files))
+	}
+	data, _ := ioutil.ReadFile(dir + "/" + files[0].Name())
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("api key was saved in the recording")
+	}
+
+	// Replay does not touch the network, and does not care about the key
+	srv.Close()
+	if err := SetHTTPMode(HTTPReplay, dir); err != nil {
+		t.Fatal(err)
+	}
+	body, err = get("/prices?apikey=different")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != `{"path":"/prices"}` || hits != 1 {
+		t.Errorf("exp the recorded body without a call, found %s with %d calls", body, hits)
+	}
+
+	if _, err := get("/missing"); err == nil {
+		t.Errorf("exp an error for a request never recorded")
+	}
+}
@@ -56,7 +56,7 @@ func init() {
 	rootCmd.Flags().BoolP("password", "p", false, "Enable password prompt for user registration")
 
 	// Defaults
-	rootCmd.Flags().StringP("poolhost", "s", "localhost:1234", "URL to connect to the pool")
+	rootCmd.Flags().StringP("poolhost", "s", "localhost:1234", "URL to connect to the pool, as host:port, or a ws:// or wss:// url for websockets")
 	rootCmd.Flags().IntP("miners", "t", runtime.NumCPU(), "Number of mining threads")
 
 	rootCmd.AddCommand(properties)
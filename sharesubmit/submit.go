@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math/big"
+	"time"
 
 	"github.com/FactomWyomingEntity/prosper-pool/database"
 
@@ -16,6 +17,7 @@ import (
 
 	"github.com/FactomWyomingEntity/prosper-pool/config"
 	"github.com/FactomWyomingEntity/prosper-pool/difficulty"
+	"github.com/FactomWyomingEntity/prosper-pool/events"
 	"github.com/FactomWyomingEntity/prosper-pool/pegnet"
 	"github.com/FactomWyomingEntity/prosper-pool/stratum"
 	"github.com/jinzhu/gorm"
@@ -43,6 +45,8 @@ type Submitter struct {
 	blocks chan SubmissionJob
 
 	FactomClient *factom.Client
+	// Bus is told about each entry submitted
+	Bus *events.Bus
 
 	currentJob *stratum.Job
 
@@ -121,6 +125,10 @@ func (s *Submitter) SetSubmissions(shares <-chan *stratum.ShareSubmission) {
 	s.shares = shares
 }
 
+func (s *Submitter) SetBus(b *events.Bus) {
+	s.Bus = b
+}
+
 func (s Submitter) GetBlocksChannel() chan<- SubmissionJob {
 	return s.blocks
 }
@@ -248,6 +256,13 @@ func (s *Submitter) Run(ctx context.Context) {
 					Content: content,
 				}
 
+				// The cost is tracked so we can compare what we spend on
+				// submissions against what we earn in rewards
+				cost, err := entry.Cost()
+				if err != nil {
+					sLog.WithError(err).WithField("job", share.JobID).Errorf("failed to compute entry cost")
+				}
+
 				txid, err := entry.ComposeCreate(nil, s.FactomClient, s.configuration.ESAddress)
 				if err != nil {
 					sLog.WithError(err).WithField("job", share.JobID).Errorf("failed to submit opr")
@@ -256,10 +271,18 @@ func (s *Submitter) Run(ctx context.Context) {
 						ShareSubmission: *share,
 						EntryHash:       entry.Hash.String(),
 						CommitTxID:      txid.String(),
+						ECCost:          int(cost),
 					})
 					if err != nil {
 						sLog.WithError(err).WithField("jobid", share.JobID).Errorf("failed to save entry submission")
 					} else {
+						s.Bus.Publish(events.EntrySubmitted{
+							JobID:     share.JobID,
+							EntryHash: entry.Hash.String(),
+							Target:    share.Target,
+							ECCost:    int(cost),
+							Time:      time.Now(),
+						})
 						sLog.WithFields(log.Fields{
 							"job":       share.JobID,
 							"entryhash": fmt.Sprintf("%s", entry.Hash.String()),
@@ -290,6 +313,7 @@ type PublicEntrySubmission struct {
 	EntryHash  string `json:"entryhash"`
 	CommitTxID string `json:"committxid"`
 	Blocked    int    `json:"blocked"`
+	ECCost     int    `json:"eccost"`
 }
 
 // EntrySubmission is a record that we submitted an entry
@@ -300,6 +324,8 @@ type EntrySubmission struct {
 	CommitTxID string `json:"committxid"`
 	// We might block some submissions for limiting reasons
 	Blocked int `json:"blocked",gorm:"default:0"`
+	// ECCost is the entry credits spent on the entry. Blocked entries cost 0
+	ECCost int `gorm:"default:0" json:"eccost"`
 }
 
 // BeforeCreate
@@ -133,7 +133,18 @@ func (c *Client) Encode(x interface{}) (err error) {
 	return
 }
 
+// Connect connects to the pool at the host and port, or at a ws:// or wss://
+// url over a websocket
 func (c *Client) Connect(address string) error {
+	if IsWebSocketAddress(address) {
+		conn, err := DialWebSocket(address)
+		if err != nil {
+			return err
+		}
+		c.InitConn(conn)
+		return nil
+	}
+
 	addr, err := net.ResolveTCPAddr("tcp", address)
 	if err != nil {
 		return err
@@ -0,0 +1,32 @@
+package stratum
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	exportDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pool_stratum_share_export_dropped_total",
+		Help: "Accepted shares a lossy export was too busy to take",
+	}, []string{"export"})
+	accountingRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pool_stratum_accounting_backpressure_total",
+		Help: "Shares rejected because the accountant's queue stayed full",
+	})
+	accountingQueue = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pool_stratum_accounting_queue",
+		Help: "Accepted shares waiting for the accountant",
+	})
+)
+
+var prom sync.Once
+
+func RegisterPrometheus() {
+	prom.Do(func() {
+		prometheus.MustRegister(exportDropped)
+		prometheus.MustRegister(accountingRejected)
+		prometheus.MustRegister(accountingQueue)
+	})
+}
@@ -84,6 +84,23 @@ func (m *MinerMap) DisconnectMiner(u *Miner) {
 	u.conn.Close()
 }
 
+// DisconnectUser closes every connection authorized as the user, and returns
+// how many were closed. The read loops remove the miners once they see the
+// connection is closed.
+func (m *MinerMap) DisconnectUser(username string) int {
+	m.RLock()
+	defer m.RUnlock()
+
+	var closed int
+	for _, u := range m.miners {
+		if name, ok := u.authorizedAs(); ok && name == username {
+			_ = u.conn.Close()
+			closed++
+		}
+	}
+	return closed
+}
+
 // GetMiner returns a pointer to the miner in the MinerMap under the 'name' key
 func (m *MinerMap) GetMiner(name string) (*Miner, error) {
 	m.Lock()
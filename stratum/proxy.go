@@ -0,0 +1,206 @@
+package stratum
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The PROXY protocol lets a tcp load balancer tell us the client's address.
+// The spec is https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+
+// proxyV2Signature starts every v2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyV1MaxLength is the longest a v1 header can be, with the CRLF
+const proxyV1MaxLength = 107
+
+// ParseTrustedProxies reads the trusted proxies from the config. Each is a
+// cidr, or a single ip.
+func ParseTrustedProxies(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted proxy '%s' is not an ip or cidr", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy '%s': %s", s, err.Error())
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// trustedProxy returns if the connection is from a proxy that sends us
+// PROXY headers
+func (s *Server) trustedProxy(addr net.Addr) bool {
+	if len(s.trustedProxies) == 0 {
+		return false
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range s.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn is a connection with the client address from the PROXY header
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+}
+
+// Read reads through the buffer, which may hold data past the header
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// ReadProxyHeader reads the v1 or v2 PROXY header at the start of the
+// connection, and returns the connection with the client's address. Health
+// checks from the proxy itself keep the proxy's address.
+func ReadProxyHeader(conn net.Conn, timeout time.Duration) (net.Conn, error) {
+	if timeout > 0 {
+		if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+	}
+
+	r := bufio.NewReaderSize(conn, 256)
+	// Every header is at least as long as the v2 signature
+	start, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+
+	var remote net.Addr
+	switch {
+	case bytes.HasPrefix(start, []byte("PROXY ")):
+		remote, err = readProxyV1(r)
+	case bytes.Equal(start, proxyV2Signature):
+		remote, err = readProxyV2(r)
+	default:
+		return nil, fmt.Errorf("connection did not start with a PROXY header")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if timeout > 0 {
+		if err := conn.SetReadDeadline(time.Time{}); err != nil {
+			return nil, err
+		}
+	}
+
+	if remote == nil {
+		remote = conn.RemoteAddr()
+	}
+	return &proxyConn{Conn: conn, r: r, remote: remote}, nil
+}
+
+// readProxyV1 reads the text header:
+//
+//	PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return nil, fmt.Errorf("bad PROXY v1 header: %s", err.Error())
+	}
+	if len(line) > proxyV1MaxLength || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("bad PROXY v1 header")
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("bad PROXY v1 header '%s'", strings.TrimSpace(string(line)))
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("bad PROXY v1 source address '%s'", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("bad PROXY v1 source port '%s'", fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 reads the binary header. The addresses come after the 16 byte
+// header: the source and destination ips, then the source and destination
+// ports.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("bad PROXY v2 header: %s", err.Error())
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("bad PROXY v2 version %d", header[12]>>4)
+	}
+	command := header[12] & 0x0f
+	family := header[13]
+
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("bad PROXY v2 header: %s", err.Error())
+	}
+
+	switch command {
+	case 0x0: // LOCAL, the proxy's own connection
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("bad PROXY v2 command %d", command)
+	}
+
+	var size int
+	switch family {
+	case 0x11, 0x12: // TCP or UDP over IPv4
+		size = net.IPv4len
+	case 0x21, 0x22: // TCP or UDP over IPv6
+		size = net.IPv6len
+	default: // Unix sockets, or unspecified
+		return nil, nil
+	}
+	if len(body) < 2*size+4 {
+		return nil, fmt.Errorf("PROXY v2 addresses too short")
+	}
+
+	ip := make(net.IP, size)
+	copy(ip, body[:size])
+	port := binary.BigEndian.Uint16(body[2*size:])
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
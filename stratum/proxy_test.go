@@ -0,0 +1,127 @@
+package stratum_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	. "github.com/FactomWyomingEntity/prosper-pool/stratum"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func proxyV2(command, family byte, addrs []byte) []byte {
+	header := append([]byte("\r\n\r\n\x00\r\nQUIT\n"), 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(addrs)))
+	return append(header, addrs...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	v4 := []byte{192, 168, 0, 1, 10, 0, 0, 1, 0xdc, 0x04, 0x04, 0xd2}
+	v6 := make([]byte, 36)
+	copy(v6, net.ParseIP("2001:db8::1"))
+	binary.BigEndian.PutUint16(v6[32:], 56324)
+
+	for _, c := range []struct {
+		Name   string
+		Header []byte
+		Remote string // "" is the proxy's address
+		Err    bool
+	}{
+		{Name: "v1 tcp4", Header: []byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324 1234\r\n"), Remote: "192.168.0.1:56324"},
+		{Name: "v1 tcp6", Header: []byte("PROXY TCP6 2001:db8::1 ::1 56324 1234\r\n"), Remote: "[2001:db8::1]:56324"},
+		{Name: "v1 unknown", Header: []byte("PROXY UNKNOWN\r\n")},
+		{Name: "v1 family mismatch", Header: []byte("PROXY TCP4 2001:db8::1 ::1 56324 1234\r\n"), Err: true},
+		{Name: "v1 bad port", Header: []byte("PROXY TCP4 192.168.0.1 10.0.0.1 99999 1234\r\n"), Err: true},
+		{Name: "v1 no crlf", Header: []byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324 1234\n"), Err: true},
+		{Name: "v2 tcp4", Header: proxyV2(0x1, 0x11, v4), Remote: "192.168.0.1:56324"},
+		{Name: "v2 tcp6", Header: proxyV2(0x1, 0x21, v6), Remote: "[2001:db8::1]:56324"},
+		{Name: "v2 tlvs", Header: proxyV2(0x1, 0x11, append(v4, 0x04, 0x00, 0x01, 0xff)), Remote: "192.168.0.1:56324"},
+		{Name: "v2 local", Header: proxyV2(0x0, 0x00, nil)},
+		{Name: "v2 short", Header: proxyV2(0x1, 0x21, v4), Err: true},
+		{Name: "no header", Header: []byte(`{"id":1,"method":"mining.subscribe"}` + "\n"), Err: true},
+	} {
+		t.Run(c.Name, func(t *testing.T) {
+			require := require.New(t)
+			srv, cli := net.Pipe()
+			defer srv.Close()
+			defer cli.Close()
+
+			// The miner's first message can come in the same packet
+			go func() { _, _ = cli.Write(append(c.Header, []byte("hello\n")...)) }()
+			conn, err := ReadProxyHeader(srv, time.Second)
+			if c.Err {
+				require.Error(err)
+				return
+			}
+			require.NoError(err)
+
+			if c.Remote == "" {
+				require.Equal(srv.RemoteAddr(), conn.RemoteAddr())
+			} else {
+				require.Equal(c.Remote, conn.RemoteAddr().String())
+			}
+			line, _, err := bufio.NewReader(conn).ReadLine()
+			require.NoError(err)
+			require.Equal("hello", string(line))
+		})
+	}
+}
+
+func TestReadProxyHeader_Timeout(t *testing.T) {
+	srv, cli := net.Pipe()
+	defer srv.Close()
+	defer cli.Close()
+
+	_, err := ReadProxyHeader(srv, 20*time.Millisecond)
+	require.Error(t, err)
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	require := require.New(t)
+	nets, err := ParseTrustedProxies([]string{"10.0.0.5", "10.1.0.0/16", "::1"})
+	require.NoError(err)
+	require.Len(nets, 3)
+	require.True(nets[0].Contains(net.ParseIP("10.0.0.5")))
+	require.False(nets[0].Contains(net.ParseIP("10.0.0.6")))
+	require.True(nets[1].Contains(net.ParseIP("10.1.2.3")))
+	require.True(nets[2].Contains(net.ParseIP("::1")))
+
+	_, err = ParseTrustedProxies([]string{"proxy.local"})
+	require.Error(err)
+	_, err = ParseTrustedProxies([]string{"10.0.0.0/33"})
+	require.Error(err)
+}
+
+func TestServer_TrustedProxy(t *testing.T) {
+	require := require.New(t)
+	conf := viper.New()
+	config.SetDefaults(conf)
+	conf.Set(config.ConfigStratumCheckAllWork, false)
+	conf.Set(config.ConfigStratumTrustedProxies, []string{"127.0.0.1"})
+	s, err := NewServer(conf)
+	require.NoError(err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	defer l.Close()
+
+	cli, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(err)
+	defer cli.Close()
+	srv, err := l.Accept()
+	require.NoError(err)
+
+	_, err = cli.Write([]byte("PROXY TCP4 203.0.113.7 127.0.0.1 40000 1234\r\n"))
+	require.NoError(err)
+	s.NewConn(srv)
+
+	for s.Miners.Len() == 0 { // Wait for the header to be read
+		time.Sleep(20 * time.Millisecond)
+	}
+	snaps := s.Miners.SnapShot()
+	require.Equal("203.0.113.7:40000", snaps[0].IP)
+}
@@ -13,9 +13,11 @@ import (
 	"sync"
 	"time"
 
+	"github.com/FactomWyomingEntity/prosper-pool/abuse"
 	"github.com/FactomWyomingEntity/prosper-pool/authentication"
 	"github.com/FactomWyomingEntity/prosper-pool/config"
 	"github.com/FactomWyomingEntity/prosper-pool/difficulty"
+	"github.com/FactomWyomingEntity/prosper-pool/events"
 	"github.com/pegnet/pegnet/modules/opr"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -35,16 +37,39 @@ type Server struct {
 
 	configuration struct {
 		RequireAuth    bool // Require actual username from miners
+		CheckPassword  bool // Require the password or a worker token from existing users
 		ValidateShares bool
 	}
 
-	// We forward submissions to any listeners
-	submissionExports []chan<- *ShareSubmission
+	// Accepted shares always reach the accountant. A share is rejected
+	// rather than dropped if the accountant cannot keep up.
+	accountingExport  chan *ShareSubmission
+	accountingTimeout time.Duration
+	// We forward submissions to any other listeners. These drop shares when
+	// they are busy.
+	submissionExports []submissionExport
+
+	// Bus is told about the shares, and the miners coming and going
+	Bus *events.Bus
+
+	// Guard limits the connections, and bans abusive ips. Nil is no limits.
+	Guard *abuse.Guard
+
+	// Connections from these proxies start with a PROXY header, which has
+	// the client's real address
+	trustedProxies []*net.IPNet
+	proxyTimeout   time.Duration
 
 	stratumPort    int
+	webSocketPort  int
 	welcomeMessage string
 }
 
+type submissionExport struct {
+	name string
+	c    chan<- *ShareSubmission
+}
+
 type ShareSubmission struct {
 	Username string `json:"username,omitempty"`
 	MinerID  string `json:"minerid,omitempty"`
@@ -79,10 +104,19 @@ func NewServer(conf *viper.Viper) (*Server, error) {
 	s.Miners = NewMinerMap()
 
 	s.configuration.RequireAuth = conf.GetBool(config.ConfigStratumRequireAuth)
+	s.configuration.CheckPassword = conf.GetBool(config.ConfigStratumCheckPassword)
 	// Stub this out so we don't get a nil dereference
 	s.ShareGate = new(AlwaysYesShareCheck)
 	s.stratumPort = conf.GetInt(config.ConfigStratumPort)
+	s.webSocketPort = conf.GetInt(config.ConfigStratumWebSocketPort)
 	s.welcomeMessage = conf.GetString(config.ConfigStratumWelcomeMessage)
+	s.accountingTimeout = conf.GetDuration(config.ConfigStratumAccountingTimeout)
+	s.proxyTimeout = conf.GetDuration(config.ConfigStratumProxyHeaderTimeout)
+	proxies, err := ParseTrustedProxies(conf.GetStringSlice(config.ConfigStratumTrustedProxies))
+	if err != nil {
+		return nil, err
+	}
+	s.trustedProxies = proxies
 	s.configuration.ValidateShares = conf.GetBool(config.ConfigStratumCheckAllWork)
 	if s.configuration.ValidateShares {
 		InitLX()
@@ -99,6 +133,14 @@ func (s *Server) SetAuthenticator(auth *authentication.Authenticator) {
 	s.Auth = auth
 }
 
+func (s *Server) SetBus(b *events.Bus) {
+	s.Bus = b
+}
+
+func (s *Server) SetGuard(g *abuse.Guard) {
+	s.Guard = g
+}
+
 // UpdateCurrentJob sets currently-active job details on the stratum server
 // and automatically pushes a notification to all connected miners
 func (s *Server) UpdateCurrentJob(job *Job) {
@@ -157,7 +199,34 @@ func (s *Server) Listen(ctx context.Context) {
 // NewConn handles all new conns from the listen. By factoring this out, we
 // can create unit tests using net.Pipe()
 func (s *Server) NewConn(conn net.Conn) {
+	if s.trustedProxy(conn.RemoteAddr()) {
+		// Reading the header waits on the proxy, so it cannot hold up the
+		// listener
+		go func() {
+			pc, err := ReadProxyHeader(conn, s.proxyTimeout)
+			if err != nil {
+				log.WithError(err).WithField("proxy", conn.RemoteAddr().String()).Warnf("bad proxy connection")
+				_ = conn.Close()
+				return
+			}
+			s.newMiner(pc)
+		}()
+		return
+	}
+	s.newMiner(conn)
+}
+
+// newMiner starts a miner on the connection, once we know its real address
+func (s *Server) newMiner(conn net.Conn) {
 	m := InitMiner(conn)
+	if s.Guard != nil {
+		if err := s.Guard.Connect(m.host(), time.Now()); err != nil {
+			m.log.WithError(err).Warnf("connection refused")
+			_ = conn.Close()
+			return
+		}
+		m.abuse = s.Guard.NewConn()
+	}
 	go s.HandleClient(m)
 	go s.HandleBroadcasts(m)
 }
@@ -183,6 +252,15 @@ type Miner struct {
 	username   string
 	minerid    string
 	authorized bool
+	// authLock guards the username and authorized for readers outside the
+	// miner's own goroutine
+	authLock sync.RWMutex
+
+	// abuse counts the messages and shares for the guard. It is nil if the
+	// server has no guard.
+	abuse *abuse.Conn
+	// guardedUser is the user counted by the guard
+	guardedUser string
 
 	joined time.Time
 
@@ -207,6 +285,22 @@ func InitMiner(conn net.Conn) *Miner {
 	return m
 }
 
+// authorizedAs returns the user the miner authorized as, if it has
+func (m *Miner) authorizedAs() (string, bool) {
+	m.authLock.RLock()
+	defer m.authLock.RUnlock()
+	return m.username, m.authorized
+}
+
+// host is the ip of the miner, without the port
+func (m *Miner) host() string {
+	host, _, err := net.SplitHostPort(m.ip)
+	if err != nil {
+		return m.ip
+	}
+	return host
+}
+
 func (m *Miner) NewNonce(nonce string) bool {
 	m.nonceLock.Lock()
 	_, ok := m.nonceHistory[nonce]
@@ -277,6 +371,19 @@ func (s *Server) HandleClient(client *Miner) {
 	// Register this new miner
 	s.Miners.AddMiner(client)
 	defer s.Miners.DisconnectMiner(client)
+	defer func() {
+		if client.authorized {
+			s.Bus.Publish(events.MinerDisconnected{Miner: client.busMiner(), Time: time.Now()})
+		}
+	}()
+	if s.Guard != nil {
+		defer func() {
+			s.Guard.Disconnect(client.host())
+			if client.guardedUser != "" {
+				s.Guard.Deauthorize(client.guardedUser)
+			}
+		}()
+	}
 
 	reader := bufio.NewReader(client.conn)
 	for {
@@ -293,6 +400,13 @@ func (s *Server) HandleClient(client *Miner) {
 			break
 		}
 
+		if client.abuse != nil {
+			if reason := client.abuse.Message(json.Valid(data), time.Now()); reason != "" {
+				s.ban(client, reason)
+				break
+			}
+		}
+
 		client.encSync.Lock()
 		s.HandleMessage(client, data)
 		client.encSync.Unlock()
@@ -340,31 +454,75 @@ func (s *Server) HandleRequest(client *Miner, req Request) {
 			return
 		}
 
-		client.username = arr[0]
-		client.minerid = arr[1]
-		client.log = client.log.WithFields(log.Fields{"minerid": client.minerid, "username": client.username})
+		// The miner is only given the names once every check passes. A
+		// failed authorize must not let the shares through as the user.
+		username, minerid := arr[0], arr[1]
+		mLog := client.log.WithFields(log.Fields{"minerid": minerid, "username": username})
 
 		if s.Auth != nil && s.configuration.RequireAuth {
-			if !s.Auth.Exists(client.username) {
+			if !s.Auth.Exists(username) {
 				// Did they provide a password, code, and payout addr?
-				if len(params) >= 4 && s.Auth.RegisterUser(client.username, params[1], params[2], params[3]) {
+				if len(params) >= 4 && s.Auth.RegisterUser(username, params[1], params[2], params[3]) {
 					// User registered! Let them through by falling out of this if statement
 				} else {
 					// User rejected
 					// TODO: Provide a reason?
 					// TODO: Disconnect them?
 					if err := client.enc.Encode(AuthorizeResponse(req.ID, false, nil)); err != nil {
-						client.log.WithField("method", req.Method).WithError(err).Error("failed to send message")
+						mLog.WithField("method", req.Method).WithError(err).Error("failed to send message")
+					}
+					return
+				}
+			} else if s.configuration.CheckPassword {
+				password := ""
+				if len(params) >= 2 {
+					password = params[1]
+				}
+				if err := s.Auth.CheckMinerCredential(username, minerid, password); err != nil {
+					mLog.WithError(err).Warnf("miner failed to authorize")
+					if err := client.enc.Encode(AuthorizeResponse(req.ID, false, nil)); err != nil {
+						mLog.WithField("method", req.Method).WithError(err).Error("failed to send message")
 					}
 					return
 				}
 			}
+
+			if !s.Auth.Active(username) {
+				mLog.Warnf("inactive user tried to authorize")
+				if err := client.enc.Encode(AuthorizeResponse(req.ID, false, nil)); err != nil {
+					mLog.WithField("method", req.Method).WithError(err).Error("failed to send message")
+				}
+				return
+			}
 		}
 
+		if s.Guard != nil && client.guardedUser != username {
+			if err := s.Guard.Authorize(username); err != nil {
+				mLog.WithError(err).Warnf("miner refused")
+				if err := client.enc.Encode(AuthorizeResponse(req.ID, false, nil)); err != nil {
+					mLog.WithField("method", req.Method).WithError(err).Error("failed to send message")
+				}
+				return
+			}
+			// A miner can authorize again as someone else
+			if client.guardedUser != "" {
+				s.Guard.Deauthorize(client.guardedUser)
+			}
+			client.guardedUser = username
+		}
+
+		client.authLock.Lock()
+		client.username = username
+		client.minerid = minerid
+		client.authLock.Unlock()
+		client.log = mLog
 		if err := client.enc.Encode(AuthorizeResponse(req.ID, true, nil)); err != nil {
 			client.log.WithField("method", req.Method).WithError(err).Error("failed to send message")
 		} else {
+			client.authLock.Lock()
 			client.authorized = true
+			client.authLock.Unlock()
+			s.Bus.Publish(events.MinerConnected{Miner: client.busMiner(), Time: time.Now()})
 			s.ShowMessage(client.sessionID, s.welcomeMessage)
 		}
 	case "mining.get_oprhash":
@@ -386,6 +544,11 @@ func (s *Server) HandleRequest(client *Miner, req Request) {
 			return
 		}
 
+		if !client.authorized {
+			_ = client.enc.Encode(HelpfulRPCError(req.ID, ErrorInvalidRequest, "miner is not authorized"))
+			return
+		}
+
 		if params[0] != client.username {
 			_ = client.enc.Encode(HelpfulRPCError(req.ID, ErrorInvalidParams, "username not as expected"))
 			return
@@ -447,52 +610,127 @@ func (s *Server) HandleRequest(client *Miner, req Request) {
 
 // ProcessSubmission will forward the shares and return if the share was accepted
 func (s *Server) ProcessSubmission(miner *Miner, jobID, nonce, oprHash, target string) bool {
+	submit, reason := s.checkSubmission(miner, jobID, nonce, oprHash, target)
+	if miner.abuse != nil {
+		if ban := miner.abuse.Share(invalidShare(reason)); ban != "" {
+			s.ban(miner, ban)
+			// The read loop stops once the connection is closed
+			_ = miner.conn.Close()
+		}
+	}
+	if submit == nil {
+		s.Bus.Publish(events.ShareRejected{
+			Username: miner.username,
+			MinerID:  miner.minerid,
+			JobID:    jobID,
+			Reason:   reason,
+			Time:     time.Now(),
+		})
+		return false
+	}
+
+	// The accountant must take the share before the miner is told it was
+	// accepted
+	if !s.exportAccounting(submit) {
+		log.WithFields(log.Fields{"user": miner.username, "miner": miner.minerid, "job": jobID}).
+			Errorf("accountant did not take the share in %s, share rejected", s.accountingTimeout)
+		accountingRejected.Inc()
+		s.Bus.Publish(events.ShareRejected{
+			Username: miner.username,
+			MinerID:  miner.minerid,
+			JobID:    jobID,
+			Reason:   events.RejectBackpressure,
+			Time:     time.Now(),
+		})
+		return false
+	}
+
+	for _, export := range s.submissionExports {
+		select { // Non blocking
+		case export.c <- submit:
+		default:
+			exportDropped.WithLabelValues(export.name).Inc()
+			log.WithFields(log.Fields{"user": miner.username, "miner": miner.minerid, "job": jobID, "export": export.name}).
+				Warnf("failed to export share")
+		}
+	}
+	s.Bus.Publish(events.ShareAccepted{
+		Username: submit.Username,
+		MinerID:  submit.MinerID,
+		JobID:    submit.JobID,
+		Target:   submit.Target,
+		Time:     time.Now(),
+	})
+
+	return true
+}
+
+// invalidShare returns if a share rejected for the reason was bad work, rather
+// than just late
+func invalidShare(reason string) bool {
+	switch reason {
+	case events.RejectMalformed, events.RejectLowTarget, events.RejectDuplicate, events.RejectInvalid:
+		return true
+	}
+	return false
+}
+
+// ban bans the miner's ip. The caller closes the connection.
+func (s *Server) ban(miner *Miner, reason string) {
+	miner.log.WithField("counts", miner.abuse.String()).Warnf("banning miner for %s", reason)
+	if _, err := s.Guard.Ban(miner.host(), reason, time.Now()); err != nil {
+		miner.log.WithError(err).Errorf("failed to ban miner")
+	}
+}
+
+// checkSubmission returns the share to forward, or why it was rejected
+func (s *Server) checkSubmission(miner *Miner, jobID, nonce, oprHash, target string) (*ShareSubmission, string) {
 	sLog := log.WithFields(log.Fields{"user": miner.username, "miner": miner.minerid, "job": jobID})
 	if s.currentJob == nil {
-		return false // No current job
+		return nil, events.RejectNoJob // No current job
 	}
 
 	if jobID != s.currentJob.JobIDString() || oprHash != s.currentJob.OPRHash {
-		return false // Only accepts current job
+		return nil, events.RejectStale // Only accepts current job
 	}
 
 	// Double check the fields
 	oB, err := hex.DecodeString(oprHash)
 	if err != nil {
 		sLog.WithError(err).Errorf("miner provided bad oprhash")
-		return false
+		return nil, events.RejectMalformed
 	}
 
 	nB, err := hex.DecodeString(nonce)
 	if err != nil {
 		sLog.WithError(err).Errorf("miner provided bad nonce")
-		return false
+		return nil, events.RejectMalformed
 	}
 
 	tU, err := strconv.ParseUint(target, 16, 64)
 	if err != nil {
 		sLog.WithError(err).Errorf("miner provided bad target")
-		return false
+		return nil, events.RejectMalformed
 	}
 
 	if tU < miner.preferredTarget {
-		return false
+		return nil, events.RejectLowTarget
 	}
 
 	// Check if this is a duplicate nonce
 	if miner.NewNonce(nonce) {
-		return false
+		return nil, events.RejectDuplicate
 	}
 
 	jobHeight, err := strconv.ParseInt(jobID, 10, 32)
 	if err != nil {
 		sLog.WithError(err).Errorf("miner provided bad jobid")
-		return false
+		return nil, events.RejectMalformed
 	}
 
 	if s.configuration.ValidateShares {
 		if !Validate(oB, nB, tU) {
-			return false // Submitted a bad share
+			return nil, events.RejectInvalid // Submitted a bad share
 		}
 	}
 
@@ -500,27 +738,17 @@ func (s *Server) ProcessSubmission(miner *Miner, jobID, nonce, oprHash, target s
 	// E.g: If we are between minute 0 and minute 1, the job is
 	// stale
 	if !s.ShareGate.CanSubmit() {
-		return false
+		return nil, events.RejectGate
 	}
 
-	submit := &ShareSubmission{
+	return &ShareSubmission{
 		Username: miner.username,
 		MinerID:  miner.minerid,
 		JobID:    int32(jobHeight),
 		OPRHash:  oB,
 		Nonce:    nB,
 		Target:   tU,
-	}
-
-	for _, export := range s.submissionExports {
-		select { // Non blocking
-		case export <- submit:
-		default:
-			sLog.Warnf("failed to export share")
-		}
-	}
-
-	return true
+	}, ""
 }
 
 func (s *Server) GetVersion(clientName string) error {
@@ -587,9 +815,41 @@ func (s *Server) StopMining(clientName string) error {
 }
 
 // GetSubmissionExport should be called in an init phase, so does not
-// need to be thread safe
-func (s *Server) GetSubmissionExport() <-chan *ShareSubmission {
+// need to be thread safe. Shares are dropped if the export is full, so it is
+// not for the accounting.
+func (s *Server) GetSubmissionExport(name string) <-chan *ShareSubmission {
 	c := make(chan *ShareSubmission, 250)
-	s.submissionExports = append(s.submissionExports, c)
+	s.submissionExports = append(s.submissionExports, submissionExport{name: name, c: c})
 	return c
 }
+
+// GetAccountingExport returns the accountant's shares. Every accepted share
+// is on it, in order. It should be called once, in the init phase.
+func (s *Server) GetAccountingExport() <-chan *ShareSubmission {
+	s.accountingExport = make(chan *ShareSubmission, s.config.GetInt(config.ConfigStratumAccountingBuffer))
+	return s.accountingExport
+}
+
+// exportAccounting queues the share for the accountant, waiting up to the
+// timeout for room. It returns false if the share could not be queued.
+func (s *Server) exportAccounting(submit *ShareSubmission) bool {
+	if s.accountingExport == nil {
+		return true // No accountant
+	}
+	defer func() { accountingQueue.Set(float64(len(s.accountingExport))) }()
+
+	select {
+	case s.accountingExport <- submit:
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(s.accountingTimeout)
+	defer timer.Stop()
+	select {
+	case s.accountingExport <- submit:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
@@ -5,12 +5,20 @@ import (
 	"context"
 	"encoding/json"
 	"net"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/FactomWyomingEntity/prosper-pool/abuse"
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	"github.com/FactomWyomingEntity/prosper-pool/events"
 	. "github.com/FactomWyomingEntity/prosper-pool/stratum"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
 )
 
 func serverAndClient(t *testing.T) (s *Server, miner *Client, srv net.Conn, cli net.Conn) {
@@ -157,3 +165,213 @@ func TestServer_SetTarget(t *testing.T) {
 	require.NoError(err)
 	// TODO: ensure client miner has updated target internally (once this is being done)
 }
+
+func TestServer_AccountingExport(t *testing.T) {
+	require := require.New(t)
+	conf := viper.New()
+	config.SetDefaults(conf)
+	conf.Set(config.ConfigStratumCheckAllWork, false)
+	conf.Set(config.ConfigStratumAccountingBuffer, 1)
+	conf.Set(config.ConfigStratumAccountingTimeout, 50*time.Millisecond)
+
+	s, err := NewServer(conf)
+	require.NoError(err)
+	acct := s.GetAccountingExport()
+	submit := s.GetSubmissionExport("submit")
+
+	job := &Job{JobID: 10, OPRHash: "00011111af870a1f49129f9c82d935665d352fffffea3296208f6f7b16faaabc"}
+	s.UpdateCurrentJob(job)
+	srv, cli := net.Pipe()
+	defer srv.Close()
+	defer cli.Close()
+	m := InitMiner(srv)
+
+	require.True(s.ProcessSubmission(m, "10", "01", job.OPRHash, "ffff000000000000"))
+
+	// The accountant's queue is full, so the share is rejected after the
+	// timeout instead of being dropped
+	start := time.Now()
+	require.False(s.ProcessSubmission(m, "10", "02", job.OPRHash, "ffff000000000000"))
+	require.True(time.Since(start) >= 50*time.Millisecond)
+
+	// Once the accountant catches up, a waiting share gets in
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-acct
+	}()
+	require.True(s.ProcessSubmission(m, "10", "03", job.OPRHash, "ffff000000000000"))
+	share := <-acct
+	require.Equal([]byte{0x03}, share.Nonce)
+
+	// The submitter only saw the accepted shares
+	require.Len(submit, 2)
+}
+
+func TestServer_Guard(t *testing.T) {
+	require := require.New(t)
+	conf := viper.New()
+	config.SetDefaults(conf)
+	conf.Set(config.ConfigStratumCheckAllWork, false)
+	conf.Set(config.ConfigAbuseMaxBadMessages, 1)
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(err)
+	defer db.Close()
+	guard, err := abuse.NewGuard(conf, db)
+	require.NoError(err)
+
+	s, err := NewServer(conf)
+	require.NoError(err)
+	s.SetGuard(guard)
+
+	srv, cli := net.Pipe()
+	s.NewConn(srv)
+
+	// Too much garbage bans the ip, and closes the connection
+	_, err = cli.Write([]byte("not json\n"))
+	require.NoError(err)
+	_, err = cli.Write([]byte("still not json\n"))
+	require.NoError(err)
+	_, err = bufio.NewReader(cli).ReadByte()
+	require.Error(err)
+
+	bans, err := guard.Bans(false, time.Now())
+	require.NoError(err)
+	require.Len(bans, 1)
+	require.Equal(abuse.ReasonBadMessages, bans[0].Reason)
+
+	// A new connection from the ip is refused
+	srv, cli = net.Pipe()
+	s.NewConn(srv)
+	_, err = cli.Write([]byte("{}\n"))
+	require.Error(err)
+}
+
+// rpcCall sends the request, and reads until the response to it
+func rpcCall(t *testing.T, conn net.Conn, r *bufio.Reader, req string) Response {
+	_, err := conn.Write([]byte(req + "\n"))
+	require.NoError(t, err)
+
+	var sent Request
+	require.NoError(t, json.Unmarshal([]byte(req), &sent))
+	for {
+		line, err := r.ReadBytes('\n')
+		require.NoError(t, err)
+		var resp Response
+		if json.Unmarshal(line, &resp) == nil && resp.ID == sent.ID && (resp.Result != nil || resp.Error != nil) {
+			return resp
+		}
+	}
+}
+
+func TestServer_FailedAuthorizeCannotSubmit(t *testing.T) {
+	require := require.New(t)
+	conf := viper.New()
+	config.SetDefaults(conf)
+	conf.Set(config.ConfigStratumCheckAllWork, false)
+	conf.Set(config.ConfigStratumRequireAuth, false)
+	conf.Set(config.ConfigAbuseMaxConnectionsPerIP, 0)
+	conf.Set(config.ConfigAbuseMaxConnectionsPerUser, 1)
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(err)
+	defer db.Close()
+	guard, err := abuse.NewGuard(conf, db)
+	require.NoError(err)
+
+	s, err := NewServer(conf)
+	require.NoError(err)
+	s.SetGuard(guard)
+
+	srv, owner := net.Pipe()
+	defer owner.Close()
+	s.NewConn(srv)
+	resp := rpcCall(t, owner, bufio.NewReader(owner), `{"id":1,"method":"mining.authorize","params":["alice,rig"]}`)
+	require.Equal("true", string(resp.Result))
+
+	// Over the user limit, so the authorize fails
+	srv, cli := net.Pipe()
+	defer cli.Close()
+	s.NewConn(srv)
+	r := bufio.NewReader(cli)
+	resp = rpcCall(t, cli, r, `{"id":1,"method":"mining.authorize","params":["alice,other"]}`)
+	require.Equal("false", string(resp.Result))
+
+	// The failed authorize must not submit as the user
+	resp = rpcCall(t, cli, r, `{"id":2,"method":"mining.submit","params":["alice","1","00","00","ffff000000000000"]}`)
+	require.NotNil(resp.Error)
+	require.Equal("miner is not authorized", resp.Error.Data)
+}
+
+func TestMinerMap_DisconnectUser(t *testing.T) {
+	require := require.New(t)
+	conf := viper.New()
+	config.SetDefaults(conf)
+	conf.Set(config.ConfigStratumCheckAllWork, false)
+	conf.Set(config.ConfigStratumRequireAuth, false)
+	s, err := NewServer(conf)
+	require.NoError(err)
+
+	connect := func(user string) (net.Conn, *bufio.Reader) {
+		srv, cli := net.Pipe()
+		s.NewConn(srv)
+		r := bufio.NewReader(cli)
+		resp := rpcCall(t, cli, r, `{"id":1,"method":"mining.authorize","params":["`+user+`,rig"]}`)
+		require.Equal("true", string(resp.Result))
+		return cli, r
+	}
+	alice, aliceR := connect("alice")
+	defer alice.Close()
+	bob, _ := connect("bob")
+	defer bob.Close()
+
+	require.Equal(1, s.Miners.DisconnectUser("alice"))
+	for {
+		if _, err := aliceR.ReadByte(); err != nil {
+			break
+		}
+	}
+	require.Eventually(func() bool { return s.Miners.Len() == 1 }, time.Second, 10*time.Millisecond)
+	require.Equal("bob", s.Miners.SnapShot()[0].Username)
+}
+
+func TestServer_WebSocket(t *testing.T) {
+	require := require.New(t)
+	conf := viper.New()
+	config.SetDefaults(conf)
+	conf.Set(config.ConfigStratumCheckAllWork, false)
+	conf.Set(config.ConfigStratumRequireAuth, false)
+	s, err := NewServer(conf)
+	require.NoError(err)
+	bus := events.NewBus()
+	s.SetBus(bus)
+	connected := bus.Subscribe("test", 1, events.DropNewest, events.TopicMinerConnected)
+
+	web := httptest.NewServer(s.WebSocketHandler())
+	defer web.Close()
+	url := "ws" + strings.TrimPrefix(web.URL, "http")
+	require.True(IsWebSocketAddress(url))
+
+	miner, err := NewClient("user", "miner", "password", "invitecode", "payoutaddress", "0.0.1")
+	require.NoError(err)
+	require.NoError(miner.Connect(url))
+	defer miner.Close()
+	require.NoError(miner.Handshake())
+
+	// The server sees the miner's real address
+	ev := <-connected.Events()
+	require.Equal("user", ev.(events.MinerConnected).Username)
+	require.True(strings.HasPrefix(ev.(events.MinerConnected).IP, "127.0.0.1:"))
+
+	// A browser sends and gets one message to a frame, with no newline
+	ws, err := websocket.Dial(url, "", "http://localhost/")
+	require.NoError(err)
+	defer ws.Close()
+	require.NoError(websocket.Message.Send(ws, `{"id":7,"method":"mining.subscribe","params":["browser"]}`))
+	var frame string
+	require.NoError(websocket.Message.Receive(ws, &frame))
+	require.NotContains(frame, "\n")
+	var resp Response
+	require.NoError(json.Unmarshal([]byte(frame), &resp))
+	require.EqualValues(7, resp.ID)
+}
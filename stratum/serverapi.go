@@ -1,5 +1,9 @@
 package stratum
 
+import (
+	"github.com/FactomWyomingEntity/prosper-pool/events"
+)
+
 // MinerSnapShot is for the admins to get a glimpse at the set of miners on
 // the stratum server. Since the miners are active connections, we will save
 // a snapshot.
@@ -20,6 +24,18 @@ type MinerSnapShot struct {
 	Authorized      bool
 }
 
+// busMiner is the miner as it is published on the event bus
+func (m *Miner) busMiner() events.Miner {
+	return events.Miner{
+		SessionID: m.sessionID,
+		Username:  m.username,
+		MinerID:   m.minerid,
+		IP:        m.conn.RemoteAddr().String(),
+		Agent:     m.agent,
+		Target:    m.preferredTarget,
+	}
+}
+
 func (m *Miner) SnapShot() (snap MinerSnapShot) {
 	return MinerSnapShot{
 		IP:              m.conn.RemoteAddr().String(),
@@ -0,0 +1,174 @@
+package stratum
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+// maxWebSocketMessage matches the longest line the tcp transport reads
+const maxWebSocketMessage = 4096
+
+// webSocketConn carries the stratum messages over a websocket, one json
+// message to a frame. It looks like the line based tcp connection to the
+// server and client, so they handle both transports the same.
+type webSocketConn struct {
+	*websocket.Conn
+	// remote is the client's address, the server side websocket only knows
+	// the origin
+	remote net.Addr
+
+	// pending is the rest of the frame being read, with its newline
+	pending []byte
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newWebSocketConn(ws *websocket.Conn, remote net.Addr) *webSocketConn {
+	ws.PayloadType = websocket.TextFrame
+	return &webSocketConn{
+		Conn:   ws,
+		remote: remote,
+		closed: make(chan struct{}),
+	}
+}
+
+// Read returns the frames as lines
+func (c *webSocketConn) Read(b []byte) (int, error) {
+	for len(c.pending) == 0 {
+		var msg []byte
+		if err := websocket.Message.Receive(c.Conn, &msg); err != nil {
+			return 0, err
+		}
+		// A newline can only be whitespace in valid json, and must not
+		// split the message
+		msg = bytes.TrimSpace(bytes.Map(func(r rune) rune {
+			if r == '\n' || r == '\r' {
+				return ' '
+			}
+			return r
+		}, msg))
+		if len(msg) > 0 {
+			c.pending = append(msg, '\n')
+		}
+	}
+
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write sends each write as a frame. The json encoders write a whole message
+// at a time.
+func (c *webSocketConn) Write(b []byte) (int, error) {
+	if err := websocket.Message.Send(c.Conn, string(bytes.TrimRight(b, "\n"))); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *webSocketConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+func (c *webSocketConn) RemoteAddr() net.Addr {
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// ListenWebSocket serves the stratum protocol over websockets, for miners
+// behind http only proxies, and monitoring from the browser. It does nothing
+// if the websocket port is not set.
+func (s *Server) ListenWebSocket(ctx context.Context) {
+	if s.webSocketPort == 0 {
+		return
+	}
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", s.webSocketPort),
+		Handler: s.WebSocketHandler(),
+	}
+
+	// Capture a cancel and close the server
+	go func() {
+		select {
+		case <-ctx.Done():
+			log.Infof("closing stratum websocket server")
+			_ = server.Close()
+			return
+		}
+	}()
+
+	log.Printf("Stratum websocket server listening on %s", server.Addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.WithError(err).Fatal("failed to launch stratum websocket server")
+	}
+}
+
+// WebSocketHandler upgrades the requests to stratum websockets. By factoring
+// this out, we can create unit tests using httptest
+func (s *Server) WebSocketHandler() http.Handler {
+	return websocket.Server{
+		// Any origin is fine, the miners authorize with the stratum messages,
+		// not cookies
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler:   s.serveWebSocket,
+	}
+}
+
+// serveWebSocket runs a miner on the websocket. The websocket is closed when
+// this returns, so it waits for the miner to go away.
+func (s *Server) serveWebSocket(ws *websocket.Conn) {
+	ws.MaxPayloadBytes = maxWebSocketMessage
+	conn := newWebSocketConn(ws, s.webSocketRemote(ws.Request()))
+	s.newMiner(conn)
+	<-conn.closed
+}
+
+// webSocketRemote returns the client's address. Behind a trusted proxy, it is
+// the last address the proxy added to X-Forwarded-For.
+func (s *Server) webSocketRemote(r *http.Request) net.Addr {
+	addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	if !s.trustedProxy(addr) {
+		return addr
+	}
+
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	if ip := net.ParseIP(strings.TrimSpace(forwarded[len(forwarded)-1])); ip != nil {
+		return &net.TCPAddr{IP: ip}
+	}
+	return addr
+}
+
+// IsWebSocketAddress returns if the pool address is a websocket url, rather
+// than a tcp host and port
+func IsWebSocketAddress(address string) bool {
+	return strings.HasPrefix(address, "ws://") || strings.HasPrefix(address, "wss://")
+}
+
+// DialWebSocket connects to a pool's websocket url
+func DialWebSocket(url string) (net.Conn, error) {
+	// The origin is only for browsers, but the handshake needs one
+	origin := "http" + strings.TrimPrefix(url, "ws")
+	ws, err := websocket.Dial(url, "", origin)
+	if err != nil {
+		return nil, err
+	}
+	// The remote address of a client websocket is the url, so a reconnect
+	// dials the url again
+	return newWebSocketConn(ws, nil), nil
+}
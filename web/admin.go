@@ -0,0 +1,337 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/FactomWyomingEntity/prosper-pool/abuse"
+	"github.com/FactomWyomingEntity/prosper-pool/authentication"
+	"github.com/FactomWyomingEntity/prosper-pool/polling"
+	"github.com/FactomWyomingEntity/prosper-pool/workers"
+	rpc "github.com/gorilla/rpc/v2"
+	"github.com/gorilla/rpc/v2/json2"
+	log "github.com/sirupsen/logrus"
+)
+
+// AdminServices is the admin api. It is a separate type from the
+// HttpServices, so none of the public api methods are served behind the admin
+// path, and none of the admin methods are public.
+type AdminServices struct {
+	s *HttpServices
+}
+
+func (s *HttpServices) AdminAPIMux() *rpc.Server {
+	apiMux := rpc.NewServer()
+	apiMux.RegisterCodec(json2.NewCodec(), "application/json")
+	err := apiMux.RegisterService(&AdminServices{s: s}, "admin")
+	if err != nil {
+		log.WithError(err).Fatal("failed to create admin api")
+	}
+
+	return apiMux
+}
+
+// admin returns the uid of the admin making the request, for the audit trail
+func (a *AdminServices) admin(r *http.Request) (string, error) {
+	user, err := a.s.GetCurrentUser(r)
+	if err != nil {
+		return "", err
+	}
+	return user.UID, nil
+}
+
+// confirm returns the uid of the admin making a sensitive change, after
+// checking a fresh two factor code
+func (a *AdminServices) confirm(r *http.Request, code string) (string, error) {
+	admin, err := a.admin(r)
+	if err != nil {
+		return "", err
+	}
+	if err := a.s.checkTwoFactor(admin, code); err != nil {
+		return "", err
+	}
+	return admin, nil
+}
+
+type ListCodesParams struct {
+	// All includes the claimed and revoked codes
+	All bool `json:"all"`
+}
+
+func (a *AdminServices) ListCodes(r *http.Request, args *ListCodesParams, reply *[]authentication.InviteCode) error {
+	codes, err := a.s.Auth.ListCodes(args.All)
+	if err != nil {
+		return err
+	}
+	*reply = codes
+	return nil
+}
+
+func (a *AdminServices) CreateCode(r *http.Request, args *authentication.CodeOptions, reply *string) error {
+	admin, err := a.admin(r)
+	if err != nil {
+		return err
+	}
+
+	*reply, err = a.s.Auth.GenerateCode(admin, *args)
+	return err
+}
+
+type ReferralParams struct {
+	Owner string `json:"owner"`
+}
+
+// Referrals returns the users that joined with the owner's invite codes
+func (a *AdminServices) Referrals(r *http.Request, args *ReferralParams, reply *[]AdminUser) error {
+	users, err := a.s.Auth.Referrals(args.Owner)
+	if err != nil {
+		return err
+	}
+
+	*reply = make([]AdminUser, len(users))
+	for i, u := range users {
+		(*reply)[i] = NewAdminUser(u)
+	}
+	return nil
+}
+
+type CodeParams struct {
+	Code string `json:"code"`
+}
+
+func (a *AdminServices) RevokeCode(r *http.Request, args *CodeParams, reply *bool) error {
+	admin, err := a.admin(r)
+	if err != nil {
+		return err
+	}
+
+	if err := a.s.Auth.RevokeCode(args.Code, admin); err != nil {
+		return err
+	}
+	*reply = true
+	return nil
+}
+
+// AdminUser is the user info an admin can see
+type AdminUser struct {
+	UID           string `json:"uid"`
+	Role          string `json:"role"`
+	Status        string `json:"status"`
+	StatusReason  string `json:"statusreason,omitempty"`
+	PayoutAddress string `json:"payoutaddress"`
+	InviteCode    string `json:"invitecode"`
+	TwoFactor     bool   `json:"twofactor"`
+}
+
+func NewAdminUser(u authentication.User) AdminUser {
+	return AdminUser{
+		UID:           u.UID,
+		Role:          u.Role,
+		Status:        u.Status,
+		StatusReason:  u.StatusReason,
+		PayoutAddress: u.PayoutAddress,
+		InviteCode:    u.InviteCode,
+		TwoFactor:     u.TOTPEnabled,
+	}
+}
+
+func (a *AdminServices) ListUsers(r *http.Request, _ *json.RawMessage, reply *[]AdminUser) error {
+	users, err := a.s.Auth.ListUsers()
+	if err != nil {
+		return err
+	}
+
+	*reply = make([]AdminUser, len(users))
+	for i, u := range users {
+		(*reply)[i] = NewAdminUser(u)
+	}
+	return nil
+}
+
+type SetRoleParams struct {
+	UID  string `json:"uid"`
+	Role string `json:"role"` // 'admin' or 'user'
+	// TOTP is a fresh two factor code of the admin
+	TOTP string `json:"totp"`
+}
+
+func (a *AdminServices) SetRole(r *http.Request, args *SetRoleParams, reply *bool) error {
+	admin, err := a.confirm(r, args.TOTP)
+	if err != nil {
+		return err
+	}
+
+	if err := a.s.Auth.SetRole(args.UID, args.Role, admin); err != nil {
+		return err
+	}
+	*reply = true
+	return nil
+}
+
+type SetStatusParams struct {
+	UID    string `json:"uid"`
+	Status string `json:"status"` // 'active', 'disabled' or 'banned'
+	Reason string `json:"reason"`
+	TOTP   string `json:"totp"`
+}
+
+func (a *AdminServices) SetStatus(r *http.Request, args *SetStatusParams, reply *bool) error {
+	admin, err := a.confirm(r, args.TOTP)
+	if err != nil {
+		return err
+	}
+
+	if err := a.s.Auth.SetStatus(args.UID, args.Status, args.Reason, admin); err != nil {
+		return err
+	}
+	// Miners only check the status when they authorize, so drop the ones
+	// already connected
+	if a.s.StratumServer != nil && !a.s.Auth.Active(args.UID) {
+		closed := a.s.StratumServer.Miners.DisconnectUser(args.UID)
+		wLog.WithFields(log.Fields{"uid": args.UID, "miners": closed}).Infof("disconnected the miners of an inactive user")
+	}
+	*reply = true
+	return nil
+}
+
+type SetPayoutAddressParams struct {
+	UID     string `json:"uid"`
+	Address string `json:"address"`
+	TOTP    string `json:"totp"`
+}
+
+func (a *AdminServices) SetPayoutAddress(r *http.Request, args *SetPayoutAddressParams, reply *bool) error {
+	admin, err := a.confirm(r, args.TOTP)
+	if err != nil {
+		return err
+	}
+
+	if err := a.s.Auth.SetPayoutAddress(args.UID, args.Address, admin); err != nil {
+		return err
+	}
+	*reply = true
+	return nil
+}
+
+type ResetTwoFactorParams struct {
+	UID  string `json:"uid"`
+	TOTP string `json:"totp"`
+}
+
+// ResetTwoFactor turns off two factor for a user that lost their device
+func (a *AdminServices) ResetTwoFactor(r *http.Request, args *ResetTwoFactorParams, reply *bool) error {
+	admin, err := a.confirm(r, args.TOTP)
+	if err != nil {
+		return err
+	}
+
+	if err := a.s.Auth.ResetTOTP(args.UID, admin); err != nil {
+		return err
+	}
+	*reply = true
+	return nil
+}
+
+type WorkersParams struct {
+	// UID filters to a single user
+	UID string `json:"uid"`
+	// Offline only returns the offline workers
+	Offline bool `json:"offline"`
+}
+
+// Workers returns the workers the pool has seen
+func (a *AdminServices) Workers(r *http.Request, args *WorkersParams, reply *[]workers.Worker) error {
+	if a.s.Workers == nil {
+		return fmt.Errorf("worker registry not loaded")
+	}
+
+	var err error
+	if args.UID == "" {
+		*reply, err = a.s.Workers.Workers(args.Offline)
+		return err
+	}
+
+	list, err := a.s.Workers.UserWorkers(args.UID)
+	if err != nil {
+		return err
+	}
+	*reply = make([]workers.Worker, 0, len(list))
+	for _, w := range list {
+		if !args.Offline || !w.Online {
+			*reply = append(*reply, w)
+		}
+	}
+	return nil
+}
+
+type BansParams struct {
+	// All includes the expired and cleared bans
+	All bool `json:"all"`
+}
+
+// Bans returns the ban list of the stratum server
+func (a *AdminServices) Bans(r *http.Request, args *BansParams, reply *[]abuse.Ban) error {
+	if a.s.Guard == nil {
+		return fmt.Errorf("abuse guard not loaded")
+	}
+
+	var err error
+	*reply, err = a.s.Guard.Bans(args.All, time.Now())
+	return err
+}
+
+type ClearBanParams struct {
+	ID uint `json:"id"`
+}
+
+// ClearBan lets a banned ip connect again
+func (a *AdminServices) ClearBan(r *http.Request, args *ClearBanParams, reply *bool) error {
+	if a.s.Guard == nil {
+		return fmt.Errorf("abuse guard not loaded")
+	}
+	admin, err := a.admin(r)
+	if err != nil {
+		return err
+	}
+
+	ban, err := a.s.Guard.Clear(args.ID, admin, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := a.s.Auth.Audit(admin, authentication.AuditClearBan, ban.Value, fmt.Sprintf("ban %d: %s", ban.ID, ban.Reason)); err != nil {
+		return err
+	}
+	*reply = true
+	return nil
+}
+
+// DataSourceHealth returns the circuit breaker and quota state of each
+// datasource
+func (a *AdminServices) DataSourceHealth(r *http.Request, _ *json.RawMessage, reply *[]polling.SourceHealth) error {
+	if a.s.Poller == nil {
+		return fmt.Errorf("datasources not loaded")
+	}
+	*reply = a.s.Poller.Health()
+	return nil
+}
+
+type AuditTrailParams struct {
+	// Target filters to a single user or code
+	Target string `json:"target"`
+	Limit  int    `json:"limit"`
+}
+
+func (a *AdminServices) AuditTrail(r *http.Request, args *AuditTrailParams, reply *[]authentication.AuditLog) error {
+	if args.Limit <= 0 || args.Limit > int(MaxLimit) {
+		args.Limit = int(MaxLimit)
+	}
+
+	logs, err := a.s.Auth.AuditTrail(args.Target, args.Limit)
+	if err != nil {
+		return err
+	}
+	*reply = logs
+	return nil
+}
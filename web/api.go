@@ -2,14 +2,20 @@ package web
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/FactomWyomingEntity/prosper-pool/minutekeeper"
+	"github.com/FactomWyomingEntity/prosper-pool/notify"
 
 	"github.com/FactomWyomingEntity/prosper-pool/sharesubmit"
 
 	"github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/authentication"
+	"github.com/FactomWyomingEntity/prosper-pool/config"
 	"github.com/FactomWyomingEntity/prosper-pool/database"
+	"github.com/FactomWyomingEntity/prosper-pool/workers"
 	rpc "github.com/gorilla/rpc/v2"
 	"github.com/gorilla/rpc/v2/json2"
 	"github.com/jinzhu/gorm"
@@ -58,6 +64,94 @@ func (s *HttpServices) Rewards(r *http.Request, args *database.PaginationParams,
 	return nil
 }
 
+type ProfitabilityResponse struct {
+	Data       []accounting.JobLedger      `json:"data"`
+	Pagination database.PaginationResponse `json:"info"`
+}
+
+// Profitability returns the ec cost versus rewards for each job
+func (s *HttpServices) Profitability(r *http.Request, args *database.PaginationParams, reply *ProfitabilityResponse) error {
+	args.Default(50, "desc", "job_id").Max(MaxLimit)
+	db, err := database.SimplePagination(s.db, *args)
+	if err != nil {
+		return err
+	}
+
+	err = db.Find(&reply.Data).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil // No records
+	}
+	if err != nil {
+		return err
+	}
+
+	total := database.TotalCount(db.Model(&accounting.JobLedger{}))
+	reply.Pagination.TotalRecords = total
+	reply.Pagination.Records = len(reply.Data)
+	return nil
+}
+
+type JobPriceParams struct {
+	Asset string `json:"asset"`
+	database.PaginationParams
+}
+
+type JobPriceResponse struct {
+	Data       []database.JobPrice         `json:"data"`
+	Pagination database.PaginationResponse `json:"info"`
+}
+
+// JobPrices returns the prices the pool used for each job, and how far they
+// were from the winning oprs.
+func (s *HttpServices) JobPrices(r *http.Request, args *JobPriceParams, reply *JobPriceResponse) error {
+	args.Default(50, "desc", "job_id").Max(MaxLimit)
+	db, err := database.SimplePagination(s.db, args.PaginationParams)
+	if err != nil {
+		return err
+	}
+
+	// Filter
+	if args.Asset != "" {
+		db = db.Where("asset = ?", args.Asset)
+	}
+
+	err = db.Find(&reply.Data).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil // No records
+	}
+	if err != nil {
+		return err
+	}
+
+	total := database.TotalCount(db.Model(&database.JobPrice{}))
+	reply.Pagination.TotalRecords = total
+	reply.Pagination.Records = len(reply.Data)
+	return nil
+}
+
+type PriceDeviationParams struct {
+	// Jobs is how many of the most recent graded jobs to summarize
+	Jobs int `json:"jobs"`
+}
+
+// PriceDeviations summarizes how far each asset's price has been from the
+// winning oprs over the recent jobs.
+func (s *HttpServices) PriceDeviations(r *http.Request, args *PriceDeviationParams, reply *[]database.AssetDeviation) error {
+	if args.Jobs <= 0 {
+		args.Jobs = 144 // About a day
+	}
+	if args.Jobs > 144*30 {
+		args.Jobs = 144 * 30
+	}
+
+	deviations, err := database.AssetDeviations(s.db, args.Jobs)
+	if err != nil {
+		return err
+	}
+	*reply = deviations
+	return nil
+}
+
 type EntrySubmissionParams struct {
 	JobID int32 `json:"jobid"`
 	database.PaginationParams
@@ -94,6 +188,380 @@ func (s *HttpServices) EntrySubmissions(r *http.Request, args *EntrySubmissionPa
 	return nil
 }
 
+// User apis. These all require the user to be logged in, or an api token with
+// the scope. They only return the user's own data. Changing the payout
+// address needs a login.
+
+// UserBalance returns what the user has earned, and what has been paid
+func (s *HttpServices) UserBalance(r *http.Request, _ *json.RawMessage, reply *accounting.Balance) error {
+	user, err := s.GetScopedUser(r, authentication.ScopePayouts)
+	if err != nil {
+		return err
+	}
+
+	*reply, err = accounting.UserBalance(s.db, user.UID)
+	return err
+}
+
+// UserPayment is a payment made to the user
+type UserPayment struct {
+	EntryHash     string    `json:"entryhash"`
+	PayoutAddress string    `json:"payoutaddress"`
+	PaymentAmount int64     `json:"paymentamount"` // In PEG
+	CreatedAt     time.Time `json:"created"`
+}
+
+type UserPaymentsResponse struct {
+	Data       []UserPayment               `json:"data"`
+	Pagination database.PaginationResponse `json:"info"`
+}
+
+// UserPayments returns the payout history of the user
+func (s *HttpServices) UserPayments(r *http.Request, args *database.PaginationParams, reply *UserPaymentsResponse) error {
+	user, err := s.GetScopedUser(r, authentication.ScopePayouts)
+	if err != nil {
+		return err
+	}
+
+	args.Default(50, "desc", "created_at").Max(MaxLimit)
+	db, err := database.SimplePagination(s.db, *args)
+	if err != nil {
+		return err
+	}
+	db = db.Where("user_id = ?", user.UID)
+
+	err = db.Table("paids").Where("deleted_at IS NULL").Find(&reply.Data).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil // No records
+	}
+	if err != nil {
+		return err
+	}
+
+	total := database.TotalCount(db.Model(&accounting.Paid{}))
+	reply.Pagination.TotalRecords = total
+	reply.Pagination.Records = len(reply.Data)
+	return nil
+}
+
+type UserEarningsResponse struct {
+	Data       []accounting.UserOwedPayouts `json:"data"`
+	Pagination database.PaginationResponse  `json:"info"`
+}
+
+// UserEarnings returns what the user earned in each block
+func (s *HttpServices) UserEarnings(r *http.Request, args *database.PaginationParams, reply *UserEarningsResponse) error {
+	user, err := s.GetScopedUser(r, authentication.ScopePayouts)
+	if err != nil {
+		return err
+	}
+
+	args.Default(50, "desc", "job_id").Max(MaxLimit)
+	db, err := database.SimplePagination(s.db, *args)
+	if err != nil {
+		return err
+	}
+	db = db.Where("user_id = ?", user.UID)
+
+	err = db.Find(&reply.Data).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil // No records
+	}
+	if err != nil {
+		return err
+	}
+
+	total := database.TotalCount(db.Model(&accounting.UserOwedPayouts{}))
+	reply.Pagination.TotalRecords = total
+	reply.Pagination.Records = len(reply.Data)
+	return nil
+}
+
+type UserPayoutAddressResponse struct {
+	// Current is the address being paid right now
+	Current string `json:"current"`
+	// Latest is the newest address asked for. It is paid once it takes
+	// effect.
+	Latest  string                               `json:"latest"`
+	History []authentication.PayoutAddressChange `json:"history"`
+}
+
+// UserPayoutAddress returns the user's payout addresses and their history
+func (s *HttpServices) UserPayoutAddress(r *http.Request, _ *json.RawMessage, reply *UserPayoutAddressResponse) error {
+	user, err := s.GetScopedUser(r, authentication.ScopePayouts)
+	if err != nil {
+		return err
+	}
+
+	reply.Latest = user.PayoutAddress
+	reply.Current, err = authentication.PayoutAddressAt(s.db, *user, time.Now())
+	if err != nil {
+		return err
+	}
+	reply.History, err = s.Auth.PayoutAddressHistory(user.UID)
+	return err
+}
+
+type ChangePayoutAddressParams struct {
+	Address string `json:"address"`
+	// Password is the user's password, it must be entered again
+	Password string `json:"password"`
+	// TOTP is a two factor code, needed if the user has two factor on
+	TOTP string `json:"totp"`
+}
+
+// ChangePayoutAddress changes the user's payout address. The new address
+// receives payouts after the cooldown.
+func (s *HttpServices) ChangePayoutAddress(r *http.Request, args *ChangePayoutAddressParams, reply *authentication.PayoutAddressChange) error {
+	user, err := s.GetCurrentUser(r)
+	if err != nil {
+		return err
+	}
+
+	// The password goes first, so a typo does not use up the two factor code
+	if err := s.Auth.CheckPassword(user.UID, args.Password); err != nil {
+		return err
+	}
+	if err := s.checkTwoFactor(user.UID, args.TOTP); err != nil {
+		return err
+	}
+
+	change, err := s.Auth.ChangeOwnPayoutAddress(user.UID, args.Address,
+		s.conf.GetDuration(config.ConfigPoolAddressCooldown))
+	if err != nil {
+		return err
+	}
+	*reply = *change
+	return nil
+}
+
+// WorkerTokens returns the user's worker tokens. The tokens themselves are
+// only shown when they are made.
+func (s *HttpServices) WorkerTokens(r *http.Request, _ *json.RawMessage, reply *[]authentication.WorkerToken) error {
+	user, err := s.GetScopedUser(r, authentication.ScopeWorkers)
+	if err != nil {
+		return err
+	}
+
+	*reply, err = s.Auth.WorkerTokens(user.UID)
+	return err
+}
+
+type CreateWorkerTokenParams struct {
+	Name string `json:"name"`
+	// MinerID limits the token to a single worker
+	MinerID string `json:"minerid"`
+}
+
+type CreateWorkerTokenResponse struct {
+	// Token is the password for the miners to authorize with
+	Token string                     `json:"token"`
+	Info  authentication.WorkerToken `json:"info"`
+}
+
+// CreateWorkerToken makes a token miners can use instead of the account
+// password.
+func (s *HttpServices) CreateWorkerToken(r *http.Request, args *CreateWorkerTokenParams, reply *CreateWorkerTokenResponse) error {
+	user, err := s.GetScopedUser(r, authentication.ScopeWorkers)
+	if err != nil {
+		return err
+	}
+
+	token, info, err := s.Auth.NewWorkerToken(user.UID, args.Name, args.MinerID)
+	if err != nil {
+		return err
+	}
+	reply.Token = token
+	reply.Info = *info
+	return nil
+}
+
+type RevokeWorkerTokenParams struct {
+	ID uint `json:"id"`
+}
+
+func (s *HttpServices) RevokeWorkerToken(r *http.Request, args *RevokeWorkerTokenParams, reply *bool) error {
+	user, err := s.GetScopedUser(r, authentication.ScopeWorkers)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Auth.RevokeWorkerToken(user.UID, args.ID); err != nil {
+		return err
+	}
+	*reply = true
+	return nil
+}
+
+// UserWorker is a miner of the user. Connected miners are included even if
+// they have no shares in the job yet.
+type UserWorker struct {
+	accounting.WorkerStats
+	Connected bool   `json:"connected"`
+	Agent     string `json:"agent,omitempty"`
+}
+
+type UserWorkersResponse struct {
+	JobID   int32        `json:"jobid"`
+	Workers []UserWorker `json:"workers"`
+}
+
+// UserWorkers returns the hashrate and shares of each of the user's miners in
+// the current job.
+func (s *HttpServices) UserWorkers(r *http.Request, _ *json.RawMessage, reply *UserWorkersResponse) error {
+	user, err := s.GetScopedUser(r, authentication.ScopeStats)
+	if err != nil {
+		return err
+	}
+
+	if s.Accountant == nil {
+		return fmt.Errorf("accountant not loaded")
+	}
+
+	reply.JobID = s.Accountant.LatestJob()
+	index := make(map[string]int)
+	for _, w := range s.Accountant.UserWorkers(reply.JobID, user.UID) {
+		index[w.MinerID] = len(reply.Workers)
+		reply.Workers = append(reply.Workers, UserWorker{WorkerStats: w})
+	}
+
+	if s.StratumServer != nil {
+		for _, m := range s.StratumServer.MinersSnapShot() {
+			if !m.Authorized || m.Username != user.UID {
+				continue
+			}
+			i, ok := index[m.Minerid]
+			if !ok {
+				i = len(reply.Workers)
+				index[m.Minerid] = i
+				reply.Workers = append(reply.Workers, UserWorker{WorkerStats: accounting.WorkerStats{MinerID: m.Minerid}})
+			}
+			reply.Workers[i].Connected = true
+			reply.Workers[i].Agent = m.Agent
+		}
+	}
+	return nil
+}
+
+// WorkerHistory returns all of the user's workers the pool has seen, with
+// when they were last seen and if they are offline.
+func (s *HttpServices) WorkerHistory(r *http.Request, _ *json.RawMessage, reply *[]workers.Worker) error {
+	user, err := s.GetScopedUser(r, authentication.ScopeStats)
+	if err != nil {
+		return err
+	}
+
+	if s.Workers == nil {
+		return fmt.Errorf("worker registry not loaded")
+	}
+	*reply, err = s.Workers.UserWorkers(user.UID)
+	return err
+}
+
+type WorkerSessionsParams struct {
+	MinerID string `json:"minerid"`
+	Limit   int    `json:"limit"`
+}
+
+// WorkerSessions returns the most recent connections of one of the user's
+// workers
+func (s *HttpServices) WorkerSessions(r *http.Request, args *WorkerSessionsParams, reply *[]workers.WorkerSession) error {
+	user, err := s.GetScopedUser(r, authentication.ScopeStats)
+	if err != nil {
+		return err
+	}
+
+	if s.Workers == nil {
+		return fmt.Errorf("worker registry not loaded")
+	}
+	if args.Limit <= 0 || args.Limit > int(MaxLimit) {
+		args.Limit = int(MaxLimit)
+	}
+	*reply, err = s.Workers.Sessions(user.UID, args.MinerID, args.Limit)
+	return err
+}
+
+type NotificationSettingsResponse struct {
+	WebhookURL  string              `json:"webhookurl"`
+	Preferences []notify.Preference `json:"preferences"`
+}
+
+// NotificationSettings returns the user's webhook, and how they get each
+// event
+func (s *HttpServices) NotificationSettings(r *http.Request, _ *json.RawMessage, reply *NotificationSettingsResponse) error {
+	user, err := s.GetCurrentUser(r)
+	if err != nil {
+		return err
+	}
+
+	if s.Notifier == nil {
+		return fmt.Errorf("notifications not loaded")
+	}
+	settings, prefs, err := s.Notifier.Preferences(*user)
+	if err != nil {
+		return err
+	}
+	reply.WebhookURL = settings.WebhookURL
+	reply.Preferences = prefs
+	return nil
+}
+
+type SetNotificationParams struct {
+	Event   string `json:"event"`
+	Email   bool   `json:"email"`
+	Webhook bool   `json:"webhook"`
+}
+
+// SetNotification sets if the user gets an event by email, and by webhook
+func (s *HttpServices) SetNotification(r *http.Request, args *SetNotificationParams, reply *bool) error {
+	user, err := s.GetCurrentUser(r)
+	if err != nil {
+		return err
+	}
+
+	if s.Notifier == nil {
+		return fmt.Errorf("notifications not loaded")
+	}
+	if user.Role != authentication.RoleAdmin && notify.IsPoolEvent(args.Event) {
+		return fmt.Errorf("only admins get '%s' events", args.Event)
+	}
+	if err := s.Notifier.SetPreference(user.UID, args.Event, args.Email, args.Webhook); err != nil {
+		return err
+	}
+	*reply = true
+	return nil
+}
+
+type SetWebhookParams struct {
+	URL string `json:"url"`
+}
+
+type SetWebhookResponse struct {
+	URL string `json:"url"`
+	// Secret signs the deliveries. It is only shown once.
+	Secret string `json:"secret"`
+}
+
+// SetWebhook sets the url the user's events are posted to. An empty url
+// removes the webhook.
+func (s *HttpServices) SetWebhook(r *http.Request, args *SetWebhookParams, reply *SetWebhookResponse) error {
+	user, err := s.GetCurrentUser(r)
+	if err != nil {
+		return err
+	}
+
+	if s.Notifier == nil {
+		return fmt.Errorf("notifications not loaded")
+	}
+	secret, err := s.Notifier.SetWebhook(user.UID, args.URL)
+	if err != nil {
+		return err
+	}
+	reply.URL = args.URL
+	reply.Secret = secret
+	return nil
+}
+
 func (s *HttpServices) SubmitSync(r *http.Request, _ *json.RawMessage, reply *minutekeeper.MinuteKeeperStatus) error {
 	*reply = s.MinuteKeeper.Status()
 	return nil
@@ -0,0 +1,98 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"net/http"
+
+	"github.com/FactomWyomingEntity/prosper-pool/authentication"
+	"github.com/FactomWyomingEntity/prosper-pool/notify"
+)
+
+// Notifications lets the user pick which events they are emailed, and set
+// the webhook the events are posted to
+func (s *HttpServices) Notifications(w http.ResponseWriter, r *http.Request) {
+	w.Write(s.Nav())
+
+	user, err := s.GetCurrentUser(r)
+	if err != nil {
+		_, _ = fmt.Fprintf(w, "<pre>Error:%s</pre>", err.Error())
+		return
+	}
+	if s.Notifier == nil {
+		_, _ = fmt.Fprintf(w, "<pre>Error:notifications not loaded</pre>")
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("<pre>")
+	if r.Method == http.MethodPost {
+		switch r.FormValue("action") {
+		case "webhook":
+			url := r.FormValue("url")
+			secret, err := s.Notifier.SetWebhook(user.UID, url)
+			switch {
+			case err != nil:
+				buf.WriteString(fmt.Sprintf("Error:%s\n\n", html.EscapeString(err.Error())))
+			case url == "":
+				buf.WriteString("Webhook removed\n\n")
+			default:
+				buf.WriteString("Webhook set. Deliveries are signed with this secret, it will not be shown again:\n")
+				buf.WriteString(fmt.Sprintf("\t%s\n\n", secret))
+			}
+		case "preference":
+			event := r.FormValue("event")
+			if user.Role != authentication.RoleAdmin && notify.IsPoolEvent(event) {
+				err = fmt.Errorf("only admins get '%s' events", event)
+			} else {
+				err = s.Notifier.SetPreference(user.UID, event, r.FormValue("email") != "", r.FormValue("webhook") != "")
+			}
+			if err != nil {
+				buf.WriteString(fmt.Sprintf("Error:%s\n\n", html.EscapeString(err.Error())))
+			}
+		}
+	}
+
+	settings, prefs, err := s.Notifier.Preferences(*user)
+	if err != nil {
+		_, _ = fmt.Fprintf(w, "<pre>Error:%s</pre>", err.Error())
+		return
+	}
+
+	webhook := settings.WebhookURL
+	if webhook == "" {
+		webhook = "none"
+	}
+	buf.WriteString(fmt.Sprintf("Notifications for %s\n", user.UID))
+	buf.WriteString(fmt.Sprintf("\tWebhook: %s\n", html.EscapeString(webhook)))
+	buf.WriteString("</pre>")
+
+	buf.WriteString(`
+	<form method="post" action="/user/notifications">
+		<input type="hidden" name="action" value="webhook">
+		<label>Webhook URL <input type="text" name="url"></label>
+		<input type="submit" value="Set">
+	</form>
+	`)
+	for _, p := range prefs {
+		buf.WriteString(fmt.Sprintf(`
+	<form method="post" action="/user/notifications">
+		<input type="hidden" name="action" value="preference">
+		<input type="hidden" name="event" value="%s">
+		%s
+		<label><input type="checkbox" name="email" value="on"%s> Email</label>
+		<label><input type="checkbox" name="webhook" value="on"%s> Webhook</label>
+		<input type="submit" value="Save">
+	</form>
+	`, p.Event, p.Event, checked(p.Email), checked(p.Webhook)))
+	}
+	_, _ = w.Write(buf.Bytes())
+}
+
+func checked(b bool) string {
+	if b {
+		return " checked"
+	}
+	return ""
+}
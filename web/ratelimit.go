@@ -0,0 +1,62 @@
+package web
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter allows a number of requests per key in each window. The
+// windows are fixed, so a burst of up to twice the limit can straddle the
+// end of one window and the start of the next.
+type RateLimiter struct {
+	Limit  int
+	Window time.Duration
+
+	sync.Mutex
+	windows map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	r := new(RateLimiter)
+	r.Limit = limit
+	r.Window = window
+	r.windows = make(map[string]*rateWindow)
+	return r
+}
+
+// Allow counts a request for the key, and returns if it is within the limit.
+// A limit of 0 or less is unlimited.
+func (r *RateLimiter) Allow(key string, now time.Time) bool {
+	if r.Limit <= 0 {
+		return true
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	w, ok := r.windows[key]
+	if !ok || now.Sub(w.start) >= r.Window {
+		// Old windows are dropped as we go, so the map does not grow forever
+		if len(r.windows) > 10000 {
+			r.prune(now)
+		}
+		w = &rateWindow{start: now}
+		r.windows[key] = w
+	}
+
+	w.count++
+	return w.count <= r.Limit
+}
+
+func (r *RateLimiter) prune(now time.Time) {
+	for k, w := range r.windows {
+		if now.Sub(w.start) >= r.Window {
+			delete(r.windows, k)
+		}
+	}
+}
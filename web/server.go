@@ -3,15 +3,22 @@ package web
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/FactomWyomingEntity/prosper-pool/minutekeeper"
+	"github.com/FactomWyomingEntity/prosper-pool/notify"
 
 	"github.com/FactomWyomingEntity/prosper-pool/stratum"
 
 	"github.com/jinzhu/gorm"
 
+	"github.com/FactomWyomingEntity/prosper-pool/abuse"
+	"github.com/FactomWyomingEntity/prosper-pool/accounting"
 	"github.com/FactomWyomingEntity/prosper-pool/authentication"
 	"github.com/FactomWyomingEntity/prosper-pool/config"
+	"github.com/FactomWyomingEntity/prosper-pool/events"
+	"github.com/FactomWyomingEntity/prosper-pool/polling"
+	"github.com/FactomWyomingEntity/prosper-pool/workers"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
@@ -24,15 +31,27 @@ type HttpServices struct {
 	Auth          *authentication.Authenticator
 	StratumServer *stratum.Server
 	MinuteKeeper  *minutekeeper.MinuteKeeper
+	Poller        *polling.DataSources
+	Accountant    *accounting.Accountant
+	Workers       *workers.Registry
+	Notifier      *notify.Service
+	Guard         *abuse.Guard
 	Primary       *http.Server
 	conf          *viper.Viper
 	db            *gorm.DB
+
+	stats            statsCache
+	jobs             *events.Subscription
+	tokenLimiter     *RateLimiter
+	twoFactorLimiter *RateLimiter
 }
 
 func NewHttpServices(conf *viper.Viper, db *gorm.DB) *HttpServices {
 	s := new(HttpServices)
 	s.conf = conf
 	s.db = db
+	s.tokenLimiter = NewRateLimiter(conf.GetInt(config.ConfigWebAPITokenRateLimit), time.Minute)
+	s.twoFactorLimiter = NewRateLimiter(twoFactorAttempts, time.Minute)
 	return s
 }
 
@@ -44,6 +63,31 @@ func (s *HttpServices) SetMinuteKeeper(mk *minutekeeper.MinuteKeeper) {
 	s.MinuteKeeper = mk
 }
 
+func (s *HttpServices) SetAccountant(a *accounting.Accountant) {
+	s.Accountant = a
+}
+
+func (s *HttpServices) SetWorkers(r *workers.Registry) {
+	s.Workers = r
+}
+
+func (s *HttpServices) SetNotifier(n *notify.Service) {
+	s.Notifier = n
+}
+
+func (s *HttpServices) SetGuard(g *abuse.Guard) {
+	s.Guard = g
+}
+
+// SetBus subscribes to the new jobs, so the stats are fresh for each block
+func (s *HttpServices) SetBus(b *events.Bus) {
+	s.jobs = b.Subscribe("web", 1, events.DropOldest, events.TopicNewJob)
+}
+
+func (s *HttpServices) SetPoller(p *polling.DataSources) {
+	s.Poller = p
+}
+
 // MiddleWare acts as a middleware for all requests to the web/api
 func (s *HttpServices) MiddleWare() func(http.Handler) http.Handler {
 	f := func(h http.Handler) http.Handler {
@@ -70,6 +114,10 @@ func (s *HttpServices) InitPrimary(auth *authentication.Authenticator) {
 	// Init a basic "whoami"
 	primaryMux.HandleFunc("/whoami", s.WhoAmI)
 	primaryMux.HandleFunc("/user/owed", s.OwedPayouts)
+	primaryMux.HandleFunc("/user/payout", s.PayoutAddress)
+	primaryMux.HandleFunc("/user/tokens", s.APITokens)
+	primaryMux.HandleFunc("/user/2fa", s.TwoFactor)
+	primaryMux.HandleFunc("/user/notifications", s.Notifications)
 	primaryMux.HandleFunc("/pool/rewards", s.PoolRewards)
 	primaryMux.HandleFunc("/pool/submissions", s.PoolSubmissions)
 	// primaryMux.HandleFunc("/api/v1/submitsync", s.MinuteKeeperInfo)
@@ -82,6 +130,7 @@ func (s *HttpServices) InitPrimary(auth *authentication.Authenticator) {
 	adminMux := http.NewServeMux()
 	adminMux.HandleFunc("/admin/links", s.AdminLinks)
 	adminMux.HandleFunc("/admin/miners", s.PoolMiners)
+	adminMux.HandleFunc("/admin/profitability", s.PoolProfitability)
 	primaryMux.Handle("/admin/", s.Auth.Authority.Authorize("admin")(adminMux))
 
 	// Add /auth to primary mux
@@ -89,9 +138,10 @@ func (s *HttpServices) InitPrimary(auth *authentication.Authenticator) {
 
 	apiBase := "/api/v1"
 	primaryMux.Handle(apiBase, s.APIMux(apiBase))
+	primaryMux.Handle(apiBase+"/admin", s.Auth.Authority.Authorize("admin")(s.AdminAPIMux()))
 
 	s.Primary = &http.Server{
-		Handler: s.MiddleWare()(auth.GetSessionManager(primaryMux)),
+		Handler: s.MiddleWare()(s.APITokenAuth(auth.GetSessionManager(s.TwoFactorGate(primaryMux)))),
 		Addr:    fmt.Sprintf("0.0.0.0:%d", s.conf.GetInt(config.ConfigWebPort)),
 	}
 }
@@ -99,6 +149,18 @@ func (s *HttpServices) InitPrimary(auth *authentication.Authenticator) {
 func (s *HttpServices) Listen() {
 	wLog.Infof("Serving primary web on %s", s.Primary.Addr)
 	go s.Primary.ListenAndServe()
+	if s.jobs != nil {
+		go s.listenJobs()
+	}
+}
+
+// listenJobs drops the cached stats when a new job starts
+func (s *HttpServices) listenJobs() {
+	for range s.jobs.Events() {
+		s.stats.Lock()
+		s.stats.stats.Updated = time.Time{}
+		s.stats.Unlock()
+	}
 }
 
 func (s *HttpServices) Close() error {
@@ -0,0 +1,145 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	"github.com/FactomWyomingEntity/prosper-pool/difficulty"
+	"github.com/FactomWyomingEntity/prosper-pool/sharesubmit"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	BlocksPerDay  = 144
+	BlocksPerWeek = BlocksPerDay * 7
+)
+
+// PoolStats are the headline pool stats. They are public, so prospective
+// miners and pool listings can see how the pool is doing.
+type PoolStats struct {
+	JobID int32 `json:"jobid"`
+
+	// Miners are the unique users connected, workers are the connections
+	Miners  int `json:"miners"`
+	Workers int `json:"workers"`
+
+	PoolHashrate    float64 `json:"poolhashrate"`    // In h/s
+	NetworkHashrate float64 `json:"networkhashrate"` // In h/s, estimated from the submit ema
+
+	// GradedShare is the pool's share of the graded set over the last day
+	GradedShare float64 `json:"gradedshare"`
+
+	Day  RewardSummary `json:"day"`
+	Week RewardSummary `json:"week"`
+
+	PoolFeeRate string    `json:"poolfeerate"`
+	Updated     time.Time `json:"updated"`
+}
+
+// RewardSummary is what the pool won over some number of blocks
+type RewardSummary struct {
+	Blocks     int   `json:"blocks"`     // Blocks looked at
+	BlocksWon  int   `json:"blockswon"`  // Blocks with at least 1 winning opr
+	PEGEarned  int64 `json:"pegearned"`  // In PEG
+	Graded     int   `json:"graded"`     // Our oprs in the graded sets
+	GradedSize int   `json:"gradedsize"` // All oprs in the graded sets
+}
+
+// statsCache keeps the last pool stats, so the stats api is cheap to poll
+type statsCache struct {
+	sync.Mutex
+	stats PoolStats
+}
+
+// PoolStats returns the public pool stats. They are cached for the
+// configured duration.
+func (s *HttpServices) PoolStats(r *http.Request, _ *json.RawMessage, reply *PoolStats) error {
+	s.stats.Lock()
+	defer s.stats.Unlock()
+
+	if time.Since(s.stats.stats.Updated) < s.conf.GetDuration(config.ConfigWebStatsCache) {
+		*reply = s.stats.stats
+		return nil
+	}
+
+	stats, err := s.computePoolStats()
+	if err != nil {
+		return err
+	}
+	s.stats.stats = stats
+	*reply = stats
+	return nil
+}
+
+func (s *HttpServices) computePoolStats() (PoolStats, error) {
+	stats := PoolStats{Updated: time.Now()}
+
+	if s.StratumServer != nil {
+		users := make(map[string]bool)
+		for _, m := range s.StratumServer.MinersSnapShot() {
+			if !m.Authorized {
+				continue
+			}
+			users[m.Username] = true
+			stats.Workers++
+		}
+		stats.Miners = len(users)
+	}
+
+	var last accounting.OwedPayouts
+	err := s.db.Order("job_id desc").First(&last).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return stats, err
+	}
+
+	if s.Accountant != nil {
+		stats.JobID = s.Accountant.LatestJob()
+		stats.PoolHashrate = s.Accountant.PoolHashrate(stats.JobID)
+		stats.PoolFeeRate = s.Accountant.PoolFeeRate.String()
+	}
+	if stats.PoolHashrate == 0 {
+		// Too early in the job, use the last job's hashrate
+		stats.PoolHashrate = last.TotalHashrate
+	}
+
+	var ema sharesubmit.EMA
+	err = s.db.Order("block_height desc").First(&ema).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return stats, err
+	}
+	if ema.EMAValue != 0 && ema.Cutoff != 0 {
+		stats.NetworkHashrate = difficulty.EffectiveHashRate(ema.EMAValue, ema.Cutoff, difficulty.MiningPeriodSeconds)
+	}
+
+	if stats.Day, err = s.rewardSummary(last.JobID, BlocksPerDay); err != nil {
+		return stats, err
+	}
+	if stats.Week, err = s.rewardSummary(last.JobID, BlocksPerWeek); err != nil {
+		return stats, err
+	}
+	if stats.Day.GradedSize > 0 {
+		stats.GradedShare = float64(stats.Day.Graded) / float64(stats.Day.GradedSize)
+	}
+
+	return stats, nil
+}
+
+// rewardSummary sums up the rewards of the blocks up to and including the
+// job.
+func (s *HttpServices) rewardSummary(jobid int32, blocks int32) (RewardSummary, error) {
+	summary := RewardSummary{Blocks: int(blocks)}
+	if jobid == 0 {
+		return summary, nil
+	}
+
+	row := s.db.Model(&accounting.OwedPayouts{}).
+		Where("job_id > ? AND job_id <= ?", jobid-blocks, jobid).
+		Select("count(case when winning > 0 then 1 end), coalesce(sum(pool_reward), 0), " +
+			"coalesce(sum(graded), 0), coalesce(sum(graded_size), 0)").Row()
+	err := row.Scan(&summary.BlocksWon, &summary.PEGEarned, &summary.Graded, &summary.GradedSize)
+	return summary, err
+}
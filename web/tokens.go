@@ -0,0 +1,149 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FactomWyomingEntity/prosper-pool/authentication"
+	log "github.com/sirupsen/logrus"
+)
+
+type contextKey string
+
+const apiTokenKey contextKey = "apitoken"
+
+// tokenAuth is the user and token of a request made with an api token
+type tokenAuth struct {
+	Token *authentication.APIToken
+	User  *authentication.User
+}
+
+// bearerToken returns the token in the Authorization header, if any
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// APITokenAuth checks the api token in the Authorization header. Requests
+// without one pass through to the session login. Each token is rate limited.
+func (s *HttpServices) APITokenAuth(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		tLog := wLog.WithFields(log.Fields{"path": r.URL.Path, "ip": r.RemoteAddr})
+		t, user, err := s.Auth.LookupAPIToken(token)
+		if err != nil {
+			tLog.WithError(err).Warnf("api token rejected")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		tLog = tLog.WithFields(log.Fields{"token": t.ID, "user": user.UID})
+		if !s.tokenLimiter.Allow(fmt.Sprintf("%d", t.ID), time.Now()) {
+			tLog.Warnf("api token rate limited")
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		tLog.Infof("api token used")
+		ctx := context.WithValue(r.Context(), apiTokenKey, &tokenAuth{Token: t, User: user})
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetScopedUser returns the user of a request that is allowed the scope. A
+// logged in user is allowed everything, an api token only its scopes.
+func (s *HttpServices) GetScopedUser(r *http.Request, scope string) (*authentication.User, error) {
+	if auth, ok := r.Context().Value(apiTokenKey).(*tokenAuth); ok {
+		if !auth.Token.HasScope(scope) {
+			return nil, fmt.Errorf("api token does not have the '%s' scope", scope)
+		}
+		return auth.User, nil
+	}
+	return s.GetCurrentUser(r)
+}
+
+// APITokens lets the user make and revoke their api tokens
+func (s *HttpServices) APITokens(w http.ResponseWriter, r *http.Request) {
+	w.Write(s.Nav())
+
+	user, err := s.GetCurrentUser(r)
+	if err != nil {
+		_, _ = fmt.Fprintf(w, "<pre>Error:%s</pre>", err.Error())
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("<pre>")
+	if r.Method == http.MethodPost {
+		switch r.FormValue("action") {
+		case "create":
+			token, _, err := s.Auth.NewAPIToken(user.UID, r.FormValue("name"), r.Form["scope"])
+			if err != nil {
+				buf.WriteString(fmt.Sprintf("Error:%s\n\n", html.EscapeString(err.Error())))
+			} else {
+				buf.WriteString("New api token, it will not be shown again:\n")
+				buf.WriteString(fmt.Sprintf("\t%s\n", token))
+				buf.WriteString("Send it in the header 'Authorization: Bearer <token>'\n\n")
+			}
+		case "revoke":
+			id, _ := strconv.ParseUint(r.FormValue("id"), 10, 64)
+			if err := s.Auth.RevokeAPIToken(user.UID, uint(id)); err != nil {
+				buf.WriteString(fmt.Sprintf("Error:%s\n\n", html.EscapeString(err.Error())))
+			} else {
+				buf.WriteString(fmt.Sprintf("Api token %d revoked\n\n", id))
+			}
+		}
+	}
+
+	tokens, err := s.Auth.APITokens(user.UID)
+	if err != nil {
+		_, _ = fmt.Fprintf(w, "<pre>Error:%s</pre>", err.Error())
+		return
+	}
+
+	buf.WriteString(fmt.Sprintf("Api tokens for %s\n", user.UID))
+	for _, t := range tokens {
+		used := "never"
+		if t.LastUsed != nil {
+			used = t.LastUsed.UTC().Format(time.RFC3339)
+		}
+		state := ""
+		if t.Revoked {
+			state = " (revoked)"
+		}
+		buf.WriteString(fmt.Sprintf("\tID: %d, Name: %s, Scopes: %s, Created: %s, Last Used: %s%s\n",
+			t.ID, html.EscapeString(t.Name), t.Scopes, t.CreatedAt.UTC().Format(time.RFC3339), used, state))
+	}
+	buf.WriteString("</pre>")
+
+	buf.WriteString(`
+	<form method="post" action="/user/tokens">
+		<input type="hidden" name="action" value="create">
+		<label>Name <input type="text" name="name"></label><br />
+		<label><input type="checkbox" name="scope" value="stats"> Read stats</label><br />
+		<label><input type="checkbox" name="scope" value="payouts"> Read payouts</label><br />
+		<label><input type="checkbox" name="scope" value="workers"> Manage workers</label><br />
+		<input type="submit" value="Create">
+	</form>
+	<form method="post" action="/user/tokens">
+		<input type="hidden" name="action" value="revoke">
+		<label>ID <input type="text" name="id"></label>
+		<input type="submit" value="Revoke">
+	</form>
+	`)
+	_, _ = w.Write(buf.Bytes())
+}
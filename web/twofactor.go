@@ -0,0 +1,150 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+)
+
+// twoFactorAttempts is the number of two factor codes a user can try each
+// minute. There are only a million codes, so they must not be guessable.
+const twoFactorAttempts = 5
+
+// TwoFactorGate holds back users with two factor on until they enter a code
+// for this login. Pages are sent to /user/2fa, and the api refuses them.
+// Requests made with an api token are not logins, so they pass.
+func (s *HttpServices) TwoFactorGate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(apiTokenKey).(*tokenAuth); ok ||
+			r.URL.Path == "/user/2fa" || strings.HasPrefix(r.URL.Path, "/auth/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := s.GetCurrentUser(r)
+		if err == nil && user.TOTPEnabled && !s.Auth.TwoFactorVerified(r, user) {
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				http.Error(w, "two factor code required", http.StatusUnauthorized)
+				return
+			}
+			http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// allowTwoFactor limits how many codes a user can try
+func (s *HttpServices) allowTwoFactor(uid string) error {
+	if !s.twoFactorLimiter.Allow(uid, time.Now()) {
+		return fmt.Errorf("too many two factor attempts, try again in a minute")
+	}
+	return nil
+}
+
+// checkTwoFactor confirms a sensitive action with a fresh code
+func (s *HttpServices) checkTwoFactor(uid, code string) error {
+	if err := s.allowTwoFactor(uid); err != nil {
+		return err
+	}
+	return s.Auth.CheckTwoFactor(uid, code)
+}
+
+// TwoFactor lets the user turn two factor on and off, and enter their code
+// after logging in.
+func (s *HttpServices) TwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := s.GetCurrentUser(r)
+	if err != nil {
+		w.Write(s.Nav())
+		_, _ = fmt.Fprintf(w, "<pre>Error:%s</pre>", err.Error())
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("<pre>")
+	if r.Method == http.MethodPost {
+		code := r.FormValue("code")
+		action := r.FormValue("action")
+		err := s.allowTwoFactor(user.UID)
+		if action == "enroll" {
+			err = nil // No code to guess
+		}
+
+		if err == nil {
+			switch action {
+			case "enroll":
+				var secret, uri string
+				secret, uri, err = s.Auth.EnrollTOTP(user.UID, s.conf.GetString(config.ConfigWebTwoFactorIssuer))
+				if err == nil {
+					buf.WriteString("Add this account to your authenticator app, then enter a code to turn on two factor.\n")
+					buf.WriteString(fmt.Sprintf("\tSecret: %s\n", secret))
+					buf.WriteString(fmt.Sprintf("\tLink:   <a href=\"%s\">%s</a>\n\n", html.EscapeString(uri), html.EscapeString(uri)))
+					user.TOTPSecret = secret
+				}
+			case "confirm":
+				if err = s.Auth.ConfirmTOTP(user.UID, code); err == nil {
+					// Turning it on used a code, so this login already passed
+					user.TOTPEnabled = true
+					err = s.Auth.MarkSession(w, r, user)
+					buf.WriteString("Two factor is on\n\n")
+				}
+			case "verify":
+				if err = s.Auth.VerifySession(w, r, user, code); err == nil {
+					http.Redirect(w, r, "/users", http.StatusSeeOther)
+					return
+				}
+			case "disable":
+				if err = s.Auth.DisableTOTP(user.UID, code); err == nil {
+					user.TOTPEnabled = false
+					user.TOTPSecret = ""
+					buf.WriteString("Two factor is off\n\n")
+				}
+			}
+		}
+		if err != nil {
+			buf.WriteString(fmt.Sprintf("Error:%s\n\n", html.EscapeString(err.Error())))
+		}
+	}
+
+	verified := user.TOTPEnabled && s.Auth.TwoFactorVerified(r, user)
+	switch {
+	case user.TOTPEnabled && !verified:
+		buf.WriteString("Enter the code from your authenticator app to continue.\n</pre>")
+		buf.WriteString(twoFactorForm("verify", "Verify"))
+	case user.TOTPEnabled:
+		buf.WriteString("Two factor is on. Payout address changes and admin actions ask for a code.\n</pre>")
+		buf.WriteString(twoFactorForm("disable", "Turn off"))
+	case user.TOTPSecret != "":
+		buf.WriteString("Two factor is waiting for a code from your authenticator app.\n</pre>")
+		buf.WriteString(twoFactorForm("confirm", "Turn on"))
+		buf.WriteString(twoFactorForm("enroll", "New secret"))
+	default:
+		buf.WriteString("Two factor is off.\n</pre>")
+		buf.WriteString(twoFactorForm("enroll", "Set up"))
+	}
+
+	// Only show the links once the user is through two factor
+	if !user.TOTPEnabled || verified {
+		w.Write(s.Nav())
+	}
+	_, _ = w.Write(buf.Bytes())
+}
+
+func twoFactorForm(action, label string) string {
+	input := `<label>Code <input type="text" name="code" autocomplete="one-time-code"></label>`
+	if action == "enroll" {
+		input = ""
+	}
+	return fmt.Sprintf(`
+	<form method="post" action="/user/2fa">
+		<input type="hidden" name="action" value="%s">
+		%s
+		<input type="submit" value="%s">
+	</form>
+	`, action, input, label)
+}
@@ -4,14 +4,17 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"html"
 	"math"
 	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/FactomWyomingEntity/prosper-pool/accounting"
 	"github.com/FactomWyomingEntity/prosper-pool/authentication"
+	"github.com/FactomWyomingEntity/prosper-pool/config"
 	"github.com/FactomWyomingEntity/prosper-pool/sharesubmit"
 )
 
@@ -31,6 +34,7 @@ func (s *HttpServices) AdminLinks(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(`
 	<ul>
 		<li><a href="/admin/miners">Miners</a></li>
+		<li><a href="/admin/profitability">Profitability</a></li>
 	</ul>
 	`))
 }
@@ -42,6 +46,10 @@ func (s *HttpServices) UserLinks(w http.ResponseWriter, r *http.Request) {
 	<ul>
 		<li><a href="/whoami">WhoAmI?</a></li>
 		<li><a href="/user/owed">Owed</a></li>
+		<li><a href="/user/payout">Payout Address</a></li>
+		<li><a href="/user/tokens">API Tokens</a></li>
+		<li><a href="/user/2fa">Two Factor</a></li>
+		<li><a href="/user/notifications">Notifications</a></li>
 		<li><a href="/auth/login">Login</a></li>
 		<li><a href="/auth/logout">Logout</a></li>
 	</ul>
@@ -75,6 +83,9 @@ func (s *HttpServices) GetCurrentUser(r *http.Request) (*authentication.User, er
 	user := s.Auth.GetCurrentUser(r)
 	if user != nil {
 		if uc, ok := user.(*authentication.User); ok {
+			if !uc.Active() {
+				return nil, fmt.Errorf("user is %s", uc.Status)
+			}
 			return uc, nil
 		}
 		return nil, fmt.Errorf("internal error: unknown user")
@@ -87,7 +98,7 @@ func (s *HttpServices) OwedPayouts(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("<pre>"))
 	defer w.Write([]byte("</pre>"))
 
-	user, err := s.GetCurrentUser(r)
+	user, err := s.GetScopedUser(r, authentication.ScopePayouts)
 	if err != nil {
 		_, _ = fmt.Fprintf(w, "Error:%s", err.Error())
 		return
@@ -108,6 +119,79 @@ func (s *HttpServices) OwedPayouts(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(buf.Bytes())
 }
 
+// PayoutAddress shows the user's payout address, and lets them change it. The
+// password, and a two factor code if it is on, must be entered to make a
+// change.
+func (s *HttpServices) PayoutAddress(w http.ResponseWriter, r *http.Request) {
+	w.Write(s.Nav())
+
+	user, err := s.GetCurrentUser(r)
+	if err != nil {
+		_, _ = fmt.Fprintf(w, "<pre>Error:%s</pre>", err.Error())
+		return
+	}
+
+	cooldown := s.conf.GetDuration(config.ConfigPoolAddressCooldown)
+	var buf bytes.Buffer
+	buf.WriteString("<pre>")
+	if r.Method == http.MethodPost {
+		var change *authentication.PayoutAddressChange
+		// The password goes first, so a typo does not use up the two
+		// factor code
+		err := s.Auth.CheckPassword(user.UID, r.FormValue("password"))
+		if err == nil {
+			err = s.checkTwoFactor(user.UID, r.FormValue("code"))
+		}
+		if err == nil {
+			change, err = s.Auth.ChangeOwnPayoutAddress(user.UID, r.FormValue("address"), cooldown)
+		}
+		if err != nil {
+			buf.WriteString(fmt.Sprintf("Error:%s\n\n", html.EscapeString(err.Error())))
+		} else {
+			buf.WriteString(fmt.Sprintf("Payout address changed to %s. It will receive payouts after %s\n\n",
+				change.Address, change.EffectiveAt.UTC().Format(time.RFC3339)))
+			user.PayoutAddress = change.Address
+		}
+	}
+
+	current, err := authentication.PayoutAddressAt(s.db, *user, time.Now())
+	if err != nil {
+		_, _ = fmt.Fprintf(w, "<pre>Error:%s</pre>", err.Error())
+		return
+	}
+	history, err := s.Auth.PayoutAddressHistory(user.UID)
+	if err != nil {
+		_, _ = fmt.Fprintf(w, "<pre>Error:%s</pre>", err.Error())
+		return
+	}
+
+	// Addresses from registration were not validated, so escape them
+	buf.WriteString(fmt.Sprintf("Payouts currently go to: %s\n", html.EscapeString(current)))
+	if current != user.PayoutAddress {
+		buf.WriteString(fmt.Sprintf("Pending change to: %s\n", html.EscapeString(user.PayoutAddress)))
+	}
+	buf.WriteString(fmt.Sprintf("\nA new address receives payouts %s after the change.\n", cooldown))
+	for _, c := range history {
+		state := ""
+		if c.Superseded {
+			state = " (superseded)"
+		}
+		buf.WriteString(fmt.Sprintf("\t%s -> %s, Changed: %s, Effective: %s, By: %s%s\n",
+			html.EscapeString(c.Previous), c.Address, c.CreatedAt.UTC().Format(time.RFC3339),
+			c.EffectiveAt.UTC().Format(time.RFC3339), html.EscapeString(c.ChangedBy), state))
+	}
+	buf.WriteString("</pre>")
+	buf.WriteString(`
+	<form method="post" action="/user/payout">
+		<label>New payout address <input type="text" name="address" size="60"></label><br />
+		<label>Password <input type="password" name="password"></label><br />
+		<label>Two factor code, if on <input type="text" name="code" autocomplete="one-time-code"></label><br />
+		<input type="submit" value="Change">
+	</form>
+	`)
+	_, _ = w.Write(buf.Bytes())
+}
+
 func (s *HttpServices) PoolRewards(w http.ResponseWriter, r *http.Request) {
 	w.Write(s.Nav())
 	w.Write([]byte("<pre>"))
@@ -126,6 +210,32 @@ func (s *HttpServices) PoolRewards(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(buf.Bytes())
 }
 
+func (s *HttpServices) PoolProfitability(w http.ResponseWriter, r *http.Request) {
+	w.Write(s.Nav())
+	w.Write([]byte("<pre>"))
+	defer w.Write([]byte("</pre>"))
+	// Only grab last 100 blocks
+	var ledgers []accounting.JobLedger
+	s.db.Order("job_id desc").Limit(100).Find(&ledgers)
+
+	var cost, earned int64
+	for _, l := range ledgers {
+		cost += l.ECCostPEG
+		earned += l.PoolReward
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("This page displays the ec cost versus the rewards of the last 100 jobs\n"))
+	buf.WriteString(fmt.Sprintf("Total PEG earned: %s, Total EC cost in PEG: %s, Net: %s\n",
+		FactoshiToFactoid(uint64(earned)), FactoshiToFactoid(uint64(cost)), factoshiString(earned-cost)))
+	for _, l := range ledgers {
+		buf.WriteString(fmt.Sprintf("\tHeight: %d, Submitted: %d, Blocked: %d, ECs: %d, EC PEG: %s, PEG: %s, Profit: %s, Deducted: %t\n",
+			l.JobID, l.Submissions, l.Blocked, l.ECCost, FactoshiToFactoid(uint64(l.ECCostPEG)),
+			FactoshiToFactoid(uint64(l.PoolReward)), factoshiString(l.Profit), l.Deducted))
+	}
+	_, _ = w.Write(buf.Bytes())
+}
+
 func (s *HttpServices) PoolSubmissions(w http.ResponseWriter, r *http.Request) {
 	w.Write(s.Nav())
 	w.Write([]byte("<pre>"))
@@ -220,6 +330,14 @@ func FactoshiToFactoid(i uint64) string {
 	return fmt.Sprintf("%s%s", ds, rs)
 }
 
+// factoshiString is FactoshiToFactoid that handles negative amounts
+func factoshiString(i int64) string {
+	if i < 0 {
+		return "-" + FactoshiToFactoid(uint64(-i))
+	}
+	return FactoshiToFactoid(uint64(i))
+}
+
 // FactoidToFactoshi takes a Factoid amount as a string and returns the value in
 // factoids
 func FactoidToFactoshi(amt string) uint64 {
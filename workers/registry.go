@@ -0,0 +1,450 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	"github.com/FactomWyomingEntity/prosper-pool/difficulty"
+	"github.com/FactomWyomingEntity/prosper-pool/events"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var (
+	wrkLog = log.WithFields(log.Fields{"mod": "workers"})
+)
+
+const (
+	// FlushInterval is how often the share counts are written, and silent
+	// workers are checked
+	FlushInterval = time.Minute
+	// HashrateWindow is the window of shares the recent hashrate is from
+	HashrateWindow = 5 * time.Minute
+)
+
+// Worker is a single mining machine of a user, the minerid half of
+// 'username,minerid'. It outlives the connections, so users and admins can
+// see workers that went quiet.
+type Worker struct {
+	ID        uint       `gorm:"primary_key" json:"id"`
+	UserID    string     `gorm:"unique_index:worker_user_miner" json:"userid"`
+	MinerID   string     `gorm:"unique_index:worker_user_miner" json:"minerid"`
+	FirstSeen time.Time  `json:"firstseen"`
+	LastSeen  time.Time  `json:"lastseen"`
+	LastShare *time.Time `json:"lastshare,omitempty"`
+	Agent     string     `json:"agent"`
+	IP        string     `json:"ip"`
+	// Target is the current target of the worker in hex
+	Target string `json:"target"`
+	Shares int64  `json:"shares"`
+	// HashRate is from the shares of the last HashrateWindow, in h/s
+	HashRate float64 `json:"hashrate"`
+	// Online is false once the worker is silent for the offline duration
+	Online bool `json:"online"`
+	// Connections are the open stratum sessions of the worker
+	Connections int `json:"connections"`
+}
+
+// WorkerSession is a single connection of a worker
+type WorkerSession struct {
+	ID             uint       `gorm:"primary_key" json:"id"`
+	WorkerID       uint       `gorm:"index:worker_session_worker" json:"workerid"`
+	SessionID      string     `gorm:"index:worker_session_session" json:"session"`
+	IP             string     `json:"ip"`
+	Agent          string     `json:"agent"`
+	ConnectedAt    time.Time  `json:"connected"`
+	DisconnectedAt *time.Time `json:"disconnected,omitempty"`
+}
+
+// recentWork is the shares of a worker not yet written to the database
+type recentWork struct {
+	shares    int64
+	lastShare time.Time
+
+	// hashes are the expected hashes of the shares since windowStart
+	hashes      float64
+	windowStart time.Time
+}
+
+// Registry keeps the workers table up to date from the stratum server. The
+// stratum server should never wait on the database, so everything comes in
+// on the event bus and is handled in Run.
+type Registry struct {
+	DB *gorm.DB
+	// OfflineAfter is how long a worker can go without a share or a
+	// connection before it is offline
+	OfflineAfter time.Duration
+	// Bus is told when a worker goes offline, or comes back online
+	Bus *events.Bus
+	// sessions must see every connect and disconnect, or the session is left
+	// open. The shares can be dropped if we fall behind.
+	sessions *events.Subscription
+	shares   *events.Subscription
+
+	sync.Mutex
+	recent map[string]*recentWork
+}
+
+func NewRegistry(conf *viper.Viper, db *gorm.DB) (*Registry, error) {
+	r := new(Registry)
+	r.DB = db
+	r.OfflineAfter = conf.GetDuration(config.ConfigStratumWorkerOfflineAfter)
+	if r.OfflineAfter <= 0 {
+		return nil, fmt.Errorf("worker offline duration must be greater than 0")
+	}
+	r.recent = make(map[string]*recentWork)
+
+	r.DB.AutoMigrate(&Worker{})
+	r.DB.AutoMigrate(&WorkerSession{})
+	return r, nil
+}
+
+// SetBus subscribes the registry to the miners and their shares
+func (r *Registry) SetBus(b *events.Bus) {
+	r.Bus = b
+	r.sessions = b.Subscribe("worker-sessions", 1000, events.Block,
+		events.TopicMinerConnected, events.TopicMinerDisconnected)
+	r.shares = b.Subscribe("worker-shares", 1000, events.DropNewest, events.TopicShareAccepted)
+}
+
+// Run handles the worker events and shares until the context is done
+func (r *Registry) Run(ctx context.Context) {
+	// Any sessions left open were cut off by the pool stopping
+	if err := r.CloseSessions(time.Now()); err != nil {
+		wrkLog.WithError(err).Error("failed to close old sessions")
+	}
+
+	var sessions, shares <-chan events.Event
+	if r.sessions != nil {
+		sessions = r.sessions.Events()
+		shares = r.shares.Events()
+	}
+
+	ticker := time.NewTicker(FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := r.Flush(time.Now()); err != nil {
+				wrkLog.WithError(err).Error("failed to flush workers")
+			}
+			return
+		case e := <-shares:
+			if share, ok := e.(events.ShareAccepted); ok {
+				r.AddShare(share)
+			}
+		case e := <-sessions:
+			var err error
+			var m events.Miner
+			switch e := e.(type) {
+			case events.MinerConnected:
+				m = e.Miner
+				err = r.Connect(e.Miner, e.Time)
+			case events.MinerDisconnected:
+				m = e.Miner
+				err = r.Disconnect(e.Miner, e.Time)
+			}
+			if err != nil {
+				wrkLog.WithError(err).WithFields(log.Fields{"user": m.Username, "minerid": m.MinerID}).
+					Error("failed to record worker")
+			}
+		case now := <-ticker.C:
+			if err := r.Flush(now); err != nil {
+				wrkLog.WithError(err).Error("failed to flush workers")
+			}
+			offline, err := r.MarkOffline(now)
+			if err != nil {
+				wrkLog.WithError(err).Error("failed to mark offline workers")
+			}
+			for _, w := range offline {
+				wrkLog.WithFields(log.Fields{"user": w.UserID, "minerid": w.MinerID, "lastseen": w.LastSeen}).
+					Infof("worker offline")
+			}
+		}
+	}
+}
+
+// CloseSessions closes every open session, and zeros the connections
+func (r *Registry) CloseSessions(now time.Time) error {
+	err := r.DB.Model(&WorkerSession{}).Where("disconnected_at IS NULL").Update("disconnected_at", now).Error
+	if err != nil {
+		return err
+	}
+	return r.DB.Model(&Worker{}).Where("connections <> ?", 0).Update("connections", 0).Error
+}
+
+// worker returns the worker, making it if it is new
+func (r *Registry) worker(db *gorm.DB, userID, minerID string, now time.Time) (*Worker, error) {
+	var w Worker
+	err := db.Where("user_id = ? AND miner_id = ?", userID, minerID).
+		Attrs(Worker{UserID: userID, MinerID: minerID, FirstSeen: now, LastSeen: now, Online: true}).
+		FirstOrCreate(&w).Error
+	return &w, err
+}
+
+// backOnline marks an offline worker as online, and returns if it was offline
+func (r *Registry) backOnline(db *gorm.DB, w *Worker) (bool, error) {
+	res := db.Model(&Worker{}).Where("id = ? AND online = ?", w.ID, false).Update("online", true)
+	return res.RowsAffected == 1, res.Error
+}
+
+func (r *Registry) status(w Worker, online bool) {
+	r.Bus.Publish(events.WorkerStatus{
+		UserID:   w.UserID,
+		MinerID:  w.MinerID,
+		Online:   online,
+		LastSeen: w.LastSeen,
+	})
+}
+
+// Connect records a new session of the worker
+func (r *Registry) Connect(m events.Miner, now time.Time) error {
+	tx := r.DB.Begin()
+	// A miner authorizing again on the same connection ends the old session
+	if err := r.endSession(tx, m.SessionID, now); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	w, err := r.worker(tx, m.Username, m.MinerID, now)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	ip := host(m.IP)
+	err = tx.Model(w).Updates(map[string]interface{}{
+		"last_seen":   now,
+		"agent":       m.Agent,
+		"ip":          ip,
+		"target":      fmt.Sprintf("%x", m.Target),
+		"connections": gorm.Expr("connections + 1"),
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	back, err := r.backOnline(tx, w)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Create(&WorkerSession{
+		WorkerID:    w.ID,
+		SessionID:   m.SessionID,
+		IP:          ip,
+		Agent:       m.Agent,
+		ConnectedAt: now,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	if back {
+		r.status(*w, true)
+	}
+	return nil
+}
+
+// Disconnect ends the session of the worker
+func (r *Registry) Disconnect(m events.Miner, now time.Time) error {
+	tx := r.DB.Begin()
+	if err := r.endSession(tx, m.SessionID, now); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// The agent and target can change after the worker authorized
+	err := tx.Model(&Worker{}).Where("user_id = ? AND miner_id = ?", m.Username, m.MinerID).
+		Updates(map[string]interface{}{
+			"agent":  m.Agent,
+			"target": fmt.Sprintf("%x", m.Target),
+		}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// endSession closes the open sessions with the session id
+func (r *Registry) endSession(tx *gorm.DB, sessionID string, now time.Time) error {
+	var sessions []WorkerSession
+	err := tx.Where("session_id = ? AND disconnected_at IS NULL", sessionID).Find(&sessions).Error
+	if err != nil {
+		return err
+	}
+
+	for _, s := range sessions {
+		if err := tx.Model(&s).Update("disconnected_at", now).Error; err != nil {
+			return err
+		}
+		err := tx.Model(&Worker{}).Where("id = ? AND connections > ?", s.WorkerID, 0).
+			Update("connections", gorm.Expr("connections - 1")).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddShare counts an accepted share. It is written on the next flush.
+func (r *Registry) AddShare(share events.ShareAccepted) {
+	hashes, _ := new(big.Float).SetInt(difficulty.TotalHashes(share.Target)).Float64()
+
+	r.Lock()
+	defer r.Unlock()
+	key := accounting.WorkerKey(share.Username, share.MinerID)
+	work, ok := r.recent[key]
+	if !ok {
+		work = &recentWork{windowStart: share.Time}
+		r.recent[key] = work
+	}
+	work.shares++
+	work.lastShare = share.Time
+	work.hashes += hashes
+}
+
+// Flush writes the shares since the last flush. The hashrate is updated once
+// the hashrate window is full.
+func (r *Registry) Flush(now time.Time) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for key, work := range r.recent {
+		userID, minerID := splitKey(key)
+		updates := make(map[string]interface{})
+		if work.shares > 0 {
+			updates["shares"] = gorm.Expr("shares + ?", work.shares)
+			updates["last_share"] = work.lastShare
+			updates["last_seen"] = work.lastShare
+		}
+
+		window := now.Sub(work.windowStart)
+		full := window >= HashrateWindow
+		if full {
+			updates["hash_rate"] = work.hashes / window.Seconds()
+		}
+
+		if len(updates) > 0 {
+			w, err := r.worker(r.DB, userID, minerID, now)
+			if err != nil {
+				return err
+			}
+			if err := r.DB.Model(w).Updates(updates).Error; err != nil {
+				return err
+			}
+			if work.shares > 0 {
+				back, err := r.backOnline(r.DB, w)
+				if err != nil {
+					return err
+				}
+				if back {
+					r.status(*w, true)
+				}
+			}
+		}
+
+		work.shares = 0
+		if full {
+			if work.hashes == 0 {
+				// No shares for a whole window, the worker has no hashrate
+				delete(r.recent, key)
+				continue
+			}
+			work.hashes = 0
+			work.windowStart = now
+		}
+	}
+	return nil
+}
+
+// MarkOffline marks the workers silent for longer than OfflineAfter as
+// offline, and returns them.
+func (r *Registry) MarkOffline(now time.Time) ([]Worker, error) {
+	var silent []Worker
+	err := r.DB.Where("online = ? AND last_seen < ?", true, now.Add(-r.OfflineAfter)).Find(&silent).Error
+	if err != nil || len(silent) == 0 {
+		return nil, err
+	}
+
+	ids := make([]uint, len(silent))
+	for i, w := range silent {
+		ids[i] = w.ID
+	}
+	err = r.DB.Model(&Worker{}).Where("id IN (?)", ids).Updates(map[string]interface{}{
+		"online":    false,
+		"hash_rate": 0,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, w := range silent {
+		r.status(w, false)
+	}
+	return silent, nil
+}
+
+// UserWorkers returns the user's workers
+func (r *Registry) UserWorkers(userID string) ([]Worker, error) {
+	var workers []Worker
+	err := r.DB.Where("user_id = ?", userID).Order("miner_id asc").Find(&workers).Error
+	return workers, err
+}
+
+// Workers returns all the workers, or only the offline workers
+func (r *Registry) Workers(offline bool) ([]Worker, error) {
+	var workers []Worker
+	q := r.DB.Order("user_id asc, miner_id asc")
+	if offline {
+		q = q.Where("online = ?", false)
+	}
+	err := q.Find(&workers).Error
+	return workers, err
+}
+
+// Sessions returns the most recent sessions of the worker
+func (r *Registry) Sessions(userID, minerID string, limit int) ([]WorkerSession, error) {
+	var w Worker
+	err := r.DB.Where("user_id = ? AND miner_id = ?", userID, minerID).First(&w).Error
+	if err != nil {
+		return nil, fmt.Errorf("no worker '%s'", minerID)
+	}
+
+	var sessions []WorkerSession
+	err = r.DB.Where("worker_id = ?", w.ID).Order("id desc").Limit(limit).Find(&sessions).Error
+	return sessions, err
+}
+
+// host drops the port from an address
+func host(addr string) string {
+	h, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return h
+}
+
+// splitKey splits the 'username,minerid' key. Usernames cannot have a comma,
+// as stratum splits on it.
+func splitKey(key string) (string, string) {
+	parts := strings.SplitN(key, ",", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
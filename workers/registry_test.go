@@ -0,0 +1,161 @@
+package workers_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	"github.com/FactomWyomingEntity/prosper-pool/difficulty"
+	"github.com/FactomWyomingEntity/prosper-pool/events"
+	. "github.com/FactomWyomingEntity/prosper-pool/workers"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func registryForTests(t *testing.T) *Registry {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+
+	conf := viper.New()
+	config.SetDefaults(conf)
+	r, err := NewRegistry(conf, db)
+	require.NoError(t, err)
+	return r
+}
+
+func TestRegistry(t *testing.T) {
+	require := require.New(t)
+	r := registryForTests(t)
+	defer r.DB.Close()
+
+	bus := events.NewBus()
+	r.Bus = bus
+	sub := bus.Subscribe("test", 10, events.Block, events.TopicWorkerStatus)
+	statuses := func() []bool {
+		var online []bool
+		for len(sub.Events()) > 0 {
+			e := (<-sub.Events()).(events.WorkerStatus)
+			require.Equal("rig", e.MinerID)
+			online = append(online, e.Online)
+		}
+		return online
+	}
+
+	start := time.Now()
+	rig := events.Miner{
+		IP:        "10.0.0.1:5555",
+		SessionID: "session-1",
+		Target:    difficulty.PDiff,
+		Agent:     "prosper-miner/1.0",
+		Username:  "alice",
+		MinerID:   "rig",
+	}
+	require.NoError(r.Connect(rig, start))
+
+	workers, err := r.UserWorkers("alice")
+	require.NoError(err)
+	require.Len(workers, 1)
+	w := workers[0]
+	require.Equal("rig", w.MinerID)
+	require.Equal("10.0.0.1", w.IP)
+	require.Equal("ffff000000000000", w.Target)
+	require.True(w.Online)
+	require.Equal(1, w.Connections)
+
+	// Authorizing again on the same connection is a new session
+	require.NoError(r.Connect(rig, start.Add(time.Second)))
+	sessions, err := r.Sessions("alice", "rig", 10)
+	require.NoError(err)
+	require.Len(sessions, 2)
+	require.Nil(sessions[0].DisconnectedAt)
+	require.NotNil(sessions[1].DisconnectedAt)
+
+	// Shares are only written on a flush, and the hashrate once the window
+	// is full
+	for i := 0; i < 10; i++ {
+		r.AddShare(events.ShareAccepted{Username: "alice", MinerID: "rig", Target: difficulty.PDiff, Time: start.Add(time.Minute)})
+	}
+	require.NoError(r.Flush(start.Add(time.Minute)))
+	workers, _ = r.UserWorkers("alice")
+	require.EqualValues(10, workers[0].Shares)
+	require.Zero(workers[0].HashRate)
+
+	require.NoError(r.Flush(start.Add(time.Minute + HashrateWindow)))
+	workers, _ = r.UserWorkers("alice")
+	require.EqualValues(10, workers[0].Shares)
+	require.InDelta(10*65536.0/HashrateWindow.Seconds(), workers[0].HashRate, 1)
+
+	require.NoError(r.Disconnect(rig, start.Add(2*time.Minute)))
+	workers, _ = r.UserWorkers("alice")
+	require.Equal(0, workers[0].Connections)
+
+	// Silent workers go offline
+	offline, err := r.MarkOffline(start.Add(time.Minute + r.OfflineAfter - time.Second))
+	require.NoError(err)
+	require.Len(offline, 0)
+	offline, err = r.MarkOffline(start.Add(time.Minute + r.OfflineAfter + time.Second))
+	require.NoError(err)
+	require.Len(offline, 1)
+
+	all, err := r.Workers(true)
+	require.NoError(err)
+	require.Len(all, 1)
+	require.False(all[0].Online)
+	require.Zero(all[0].HashRate)
+	require.Equal([]bool{false}, statuses())
+
+	// A share brings it back
+	r.AddShare(events.ShareAccepted{Username: "alice", MinerID: "rig", Target: difficulty.PDiff, Time: start.Add(time.Hour)})
+	require.NoError(r.Flush(start.Add(time.Hour)))
+	all, _ = r.Workers(true)
+	require.Len(all, 0)
+	require.Equal([]bool{true}, statuses())
+}
+
+func TestRegistry_CloseSessions(t *testing.T) {
+	require := require.New(t)
+	r := registryForTests(t)
+	defer r.DB.Close()
+
+	now := time.Now()
+	require.NoError(r.Connect(events.Miner{SessionID: "a", Username: "alice", MinerID: "rig"}, now))
+	require.NoError(r.Connect(events.Miner{SessionID: "b", Username: "alice", MinerID: "rig"}, now))
+	workers, _ := r.UserWorkers("alice")
+	require.Equal(2, workers[0].Connections)
+
+	require.NoError(r.CloseSessions(now))
+	workers, _ = r.UserWorkers("alice")
+	require.Equal(0, workers[0].Connections)
+	sessions, _ := r.Sessions("alice", "rig", 10)
+	for _, s := range sessions {
+		require.NotNil(s.DisconnectedAt)
+	}
+}
+
+func TestRegistry_SharesDoNotDropSessions(t *testing.T) {
+	require := require.New(t)
+	r := registryForTests(t)
+	defer r.DB.Close()
+	r.SetBus(events.NewBus())
+
+	// A burst of shares fills the share buffer before the disconnect
+	now := time.Now()
+	rig := events.Miner{SessionID: "a", Username: "alice", MinerID: "rig"}
+	r.Bus.Publish(events.MinerConnected{Miner: rig, Time: now})
+	for i := 0; i < 2000; i++ {
+		r.Bus.Publish(events.ShareAccepted{Username: "alice", MinerID: "rig", Target: difficulty.PDiff, Time: now})
+	}
+	r.Bus.Publish(events.MinerDisconnected{Miner: rig, Time: now})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	require.Eventually(func() bool {
+		sessions, err := r.Sessions("alice", "rig", 10)
+		return err == nil && len(sessions) == 1 && sessions[0].DisconnectedAt != nil
+	}, time.Second, 10*time.Millisecond)
+}